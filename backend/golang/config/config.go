@@ -1,6 +1,11 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Config 应用程序配置结构
 type Config struct {
@@ -11,6 +16,57 @@ type Config struct {
 
 	// Kafka Topics 配置
 	KafkaTopics KafkaTopicConfig
+
+	// Kafka生产者配置
+	KafkaProducer KafkaProducerConfig
+
+	// Kafka消费者组配置
+	KafkaConsumer KafkaConsumerConfig
+
+	// Kafka不可用时的本地spool配置
+	Spool SpoolConfig
+
+	// 事件Schema注册表配置
+	Schema SchemaConfig
+
+	// 事件接收配置
+	Ingestion IngestionConfig
+
+	// 事件上报限流配置
+	RateLimit RateLimitConfig
+
+	// 个人敏感信息脱敏配置
+	Privacy PrivacyConfig
+
+	// 机器人/爬虫流量识别配置
+	Bot BotConfig
+
+	// 管理接口令牌（为空则不校验，仅用于本地开发）
+	AdminToken string
+}
+
+// IngestionConfig 事件接收配置
+type IngestionConfig struct {
+	FreshWindowSeconds    int64 // 实时窗口：在此时间内的事件按实时事件处理
+	LatenessWindowSeconds int64 // 迟到窗口：超过实时窗口但在此时间内的事件走回填路径，超过则拒绝
+
+	MaxPayloadBytes int64 // 单个请求体解压后的最大字节数
+	RequireWriteKey bool  // 是否要求上报请求携带项目写入密钥（否则未携带密钥的事件归属默认项目）
+
+	DedupWindow time.Duration // 事件去重窗口（相同event_id在窗口内只计数一次）
+
+	TrustedProxies    []string // 受信任代理（CIDR），仅信任这些代理传递的 X-Forwarded-For / X-Real-IP
+	GeoIPDatabasePath string   // 本地MaxMind格式GeoIP数据库文件（为空则不做地理位置解析）
+
+	RedirectAllowedHosts []string // 点击追踪链接允许跳转的域名（为空则只允许跳转到与请求相同的域名）
+
+	SignatureTolerance time.Duration // 服务端签名上报允许的时间戳偏差
+}
+
+// SchemaConfig 事件Schema注册表配置
+type SchemaConfig struct {
+	FilePath       string        // Schema JSON文件路径（为空则只从数据库加载）
+	ReloadInterval time.Duration // 自动重新加载间隔（0表示不自动加载）
 }
 
 // KafkaTopicConfig Kafka主题配置
@@ -18,11 +74,90 @@ type KafkaTopicConfig struct {
 	// 主要用户事件主题
 	UserEvents string
 
+	// 死信主题：无法解析或多次处理失败的消息（为空则不启用）
+	DeadLetter string
+
 	// 预留：未来可能的其他主题
 	SystemEvents string // 系统事件（预留）
 	AlertEvents  string // 告警事件（预留）
 }
 
+// KafkaProducerConfig Kafka生产者配置
+type KafkaProducerConfig struct {
+	Mode        string        // 发送模式：async（攒批异步发送）或 sync（同步发送）
+	Linger      time.Duration // 消息攒批的最长等待时间
+	BatchSize   int           // 单批最大消息数
+	Compression string        // 压缩算法：none, gzip, snappy, lz4, zstd
+	SendTimeout time.Duration // 等待投递结果的最长时间
+}
+
+// KafkaConsumerConfig Kafka消费者组配置
+type KafkaConsumerConfig struct {
+	GroupID           string        // 消费者组ID（多个副本使用同一组ID时分摊分区）
+	InitialOffset     string        // 消费者组没有已提交位移时的起始位置：newest 或 oldest
+	RebalanceStrategy string        // 分区分配策略：range, roundrobin, sticky
+	RetryBackoff      time.Duration // 事件处理失败后的重试间隔（逐次翻倍）
+	MaxAttempts       int           // 单条消息最多处理次数，超过后发送到死信主题
+	Workers           int           // 处理消息的worker数（应小于MySQL连接池大小）
+	QueueSize         int           // 每个worker的队列容量，队列满时暂停拉取
+}
+
+// SpoolConfig 本地spool配置
+type SpoolConfig struct {
+	Dir             string        // spool 目录（为空则不启用，Kafka失败时返回503由客户端重试）
+	SegmentBytes    int64         // 单个段文件的最大字节数
+	MaxBytes        int64         // spool 总容量上限
+	FsyncPolicy     string        // fsync 策略：always, interval, never
+	FsyncInterval   time.Duration // interval 策略下的落盘间隔
+	ReplayInterval  time.Duration // 回放检查间隔
+	ReplayBatchSize int           // 每批回放的事件数
+}
+
+// PrivacyConfig 个人敏感信息脱敏配置（事件发送到Kafka之前执行）
+type PrivacyConfig struct {
+	ScrubEnabled       bool     // 是否启用脱敏
+	RedactPatterns     []string // 正则表达式（分号分隔），匹配内容替换为 [REDACTED]
+	QueryParamDenyList []string // URL中需要脱敏的查询参数
+	TruncateIP         bool     // 截断IP地址（在地理位置解析之后）
+	HashFields         []string // 需要加盐哈希的字段（如 user_id、extra_data.email）
+	HashSalt           string   // 哈希盐值
+
+	// 同意状态与 DNT/Sec-GPC 处理模式：full, anonymous, drop
+	ConsentDefaultMode string // 事件未携带同意状态时的处理模式
+	ConsentOptOutMode  string // 请求携带 DNT: 1 或 Sec-GPC: 1 时允许的最宽松模式
+
+	// 用户数据删除与导出（GDPR）
+	ExportDir        string        // 导出文件目录
+	ExportTTL        time.Duration // 导出文件保留时间，过期后删除
+	ErasureTombstone time.Duration // 删除用户后丢弃其删除前事件的时间窗口（应不小于Kafka消息保留时间）
+	JobPollInterval  time.Duration // 删除/导出任务的轮询间隔
+}
+
+// BotConfig 机器人/爬虫流量识别配置（判定为机器人的事件不计入默认统计）
+type BotConfig struct {
+	Enabled              bool          // 是否启用机器人识别
+	UserAgentSignatures  []string      // User-Agent 特征（不区分大小写的子串匹配）
+	DatacenterCIDRFile   string        // 数据中心IP网段列表文件（每行一个CIDR，#开头为注释；为空则不按IP判定）
+	MaxEventsPerMinute   int           // 单个用户每分钟事件数上限，超过判定为机器人（0表示不检查）
+	PassiveViewThreshold int           // 同一会话浏览数达到该值且没有任何滚动、可见性变化事件时判定为机器人（0表示不检查）
+	FlagTTL              time.Duration // 用户被判定为机器人后，后续事件沿用判定结果的时间
+}
+
+// botUASignatures 机器人 User-Agent 特征
+// 默认只包含明确的爬虫、自动化和监控特征；HTTP客户端库特征（Android SDK、Java服务端、App内置HTTP栈也会使用）
+// 需通过 BOT_UA_MATCH_HTTP_LIBRARIES=true 启用
+func botUASignatures() []string {
+	signatures := getEnvList("BOT_UA_SIGNATURES", []string{
+		"bot", "crawl", "spider", "slurp", "headless", "phantomjs", "selenium", "puppeteer", "playwright",
+		"lighthouse", "pingdom", "uptimerobot", "statuscake", "site24x7",
+	})
+	if getEnv("BOT_UA_MATCH_HTTP_LIBRARIES", "false") == "true" {
+		signatures = append(signatures,
+			"curl", "wget", "python-requests", "python-urllib", "go-http-client", "okhttp", "java/", "httpclient")
+	}
+	return signatures
+}
+
 // Load 加载配置
 func Load() *Config {
 	return &Config{
@@ -33,9 +168,95 @@ func Load() *Config {
 
 		KafkaTopics: KafkaTopicConfig{
 			UserEvents:   getEnv("KAFKA_TOPIC_USER_EVENTS", "user_events"),
+			DeadLetter:   getEnv("KAFKA_TOPIC_DEAD_LETTER", "user_events_dlq"),
 			SystemEvents: getEnv("KAFKA_TOPIC_SYSTEM_EVENTS", "system_events"),
 			AlertEvents:  getEnv("KAFKA_TOPIC_ALERT_EVENTS", "alert_events"),
 		},
+
+		KafkaProducer: KafkaProducerConfig{
+			Mode:        getEnv("KAFKA_PRODUCER_MODE", "async"),
+			Linger:      time.Duration(getEnvInt("KAFKA_PRODUCER_LINGER_MS", 10)) * time.Millisecond,
+			BatchSize:   getEnvInt("KAFKA_PRODUCER_BATCH_SIZE", 100),
+			Compression: getEnv("KAFKA_PRODUCER_COMPRESSION", "snappy"),
+			SendTimeout: time.Duration(getEnvInt("KAFKA_PRODUCER_SEND_TIMEOUT_MS", 10000)) * time.Millisecond,
+		},
+
+		KafkaConsumer: KafkaConsumerConfig{
+			GroupID:           getEnv("KAFKA_CONSUMER_GROUP", "insightflow-processor"),
+			InitialOffset:     getEnv("KAFKA_CONSUMER_INITIAL_OFFSET", "newest"),
+			RebalanceStrategy: getEnv("KAFKA_CONSUMER_REBALANCE_STRATEGY", "roundrobin"),
+			RetryBackoff:      time.Duration(getEnvInt("KAFKA_CONSUMER_RETRY_BACKOFF_MS", 1000)) * time.Millisecond,
+			MaxAttempts:       getEnvInt("KAFKA_CONSUMER_MAX_ATTEMPTS", 5),
+			Workers:           getEnvInt("KAFKA_CONSUMER_WORKERS", 16),
+			QueueSize:         getEnvInt("KAFKA_CONSUMER_QUEUE_SIZE", 100),
+		},
+
+		Spool: SpoolConfig{
+			Dir:             getEnv("SPOOL_DIR", "./data/spool"),
+			SegmentBytes:    int64(getEnvInt("SPOOL_SEGMENT_BYTES", 16*1024*1024)),
+			MaxBytes:        int64(getEnvInt("SPOOL_MAX_BYTES", 1024*1024*1024)),
+			FsyncPolicy:     getEnv("SPOOL_FSYNC_POLICY", "interval"),
+			FsyncInterval:   time.Duration(getEnvInt("SPOOL_FSYNC_INTERVAL_MS", 1000)) * time.Millisecond,
+			ReplayInterval:  time.Duration(getEnvInt("SPOOL_REPLAY_INTERVAL_MS", 1000)) * time.Millisecond,
+			ReplayBatchSize: getEnvInt("SPOOL_REPLAY_BATCH_SIZE", 500),
+		},
+
+		Schema: SchemaConfig{
+			FilePath:       getEnv("SCHEMA_REGISTRY_FILE", ""),
+			ReloadInterval: time.Duration(getEnvInt("SCHEMA_RELOAD_INTERVAL_SECONDS", 60)) * time.Second,
+		},
+
+		Ingestion: IngestionConfig{
+			FreshWindowSeconds:    int64(getEnvInt("EVENT_FRESH_WINDOW_SECONDS", 300)),
+			LatenessWindowSeconds: int64(getEnvInt("EVENT_LATENESS_WINDOW_SECONDS", 7*24*3600)),
+			MaxPayloadBytes:       int64(getEnvInt("EVENT_MAX_PAYLOAD_BYTES", 5*1024*1024)),
+			RequireWriteKey:       getEnv("REQUIRE_WRITE_KEY", "false") == "true",
+			DedupWindow:           time.Duration(getEnvInt("EVENT_DEDUP_WINDOW_SECONDS", 24*3600)) * time.Second,
+			TrustedProxies:        getEnvList("TRUSTED_PROXIES", []string{"127.0.0.1/32", "::1/128"}),
+			GeoIPDatabasePath:     getEnv("GEOIP_DB_PATH", ""),
+			RedirectAllowedHosts:  getEnvList("COLLECT_REDIRECT_HOSTS", nil),
+			SignatureTolerance:    time.Duration(getEnvInt("TRACK_SIGNATURE_TOLERANCE_SECONDS", 300)) * time.Second,
+		},
+
+		RateLimit: RateLimitConfig{
+			ProjectEventsPerSecond: float64(getEnvInt("RATE_LIMIT_PROJECT_EPS", 1000)),
+			ProjectBurst:           int64(getEnvInt("RATE_LIMIT_PROJECT_BURST", 5000)),
+			IPEventsPerSecond:      float64(getEnvInt("RATE_LIMIT_IP_EPS", 50)),
+			IPBurst:                int64(getEnvInt("RATE_LIMIT_IP_BURST", 200)),
+		},
+
+		Privacy: PrivacyConfig{
+			ScrubEnabled: getEnv("PII_SCRUB_ENABLED", "true") == "true",
+			RedactPatterns: getEnvListSep("PII_REDACT_PATTERNS", ";", []string{
+				`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, // 邮箱地址
+			}),
+			QueryParamDenyList: getEnvList("PII_QUERY_PARAM_DENYLIST", []string{
+				"email", "token", "access_token", "id_token", "refresh_token",
+				"password", "passwd", "secret", "api_key", "apikey", "auth",
+			}),
+			TruncateIP: getEnv("PII_TRUNCATE_IP", "false") == "true",
+			HashFields: getEnvList("PII_HASH_FIELDS", nil),
+			HashSalt:   getEnv("PII_HASH_SALT", ""),
+
+			ConsentDefaultMode: getEnv("CONSENT_DEFAULT_MODE", "full"),
+			ConsentOptOutMode:  getEnv("CONSENT_OPT_OUT_MODE", "anonymous"),
+
+			ExportDir:        getEnv("PRIVACY_EXPORT_DIR", "./data/exports"),
+			ExportTTL:        time.Duration(getEnvInt("PRIVACY_EXPORT_TTL_HOURS", 7*24)) * time.Hour,
+			ErasureTombstone: time.Duration(getEnvInt("PRIVACY_ERASURE_TOMBSTONE_HOURS", 7*24)) * time.Hour,
+			JobPollInterval:  time.Duration(getEnvInt("PRIVACY_JOB_POLL_INTERVAL_SECONDS", 10)) * time.Second,
+		},
+
+		Bot: BotConfig{
+			Enabled:              getEnv("BOT_DETECTION_ENABLED", "true") == "true",
+			UserAgentSignatures:  botUASignatures(),
+			DatacenterCIDRFile:   getEnv("BOT_DATACENTER_CIDR_FILE", ""),
+			MaxEventsPerMinute:   getEnvInt("BOT_MAX_EVENTS_PER_MINUTE", 120),
+			PassiveViewThreshold: getEnvInt("BOT_PASSIVE_VIEW_THRESHOLD", 20),
+			FlagTTL:              time.Duration(getEnvInt("BOT_FLAG_TTL_HOURS", 24)) * time.Hour,
+		},
+
+		AdminToken: getEnv("ADMIN_TOKEN", ""),
 	}
 }
 
@@ -48,6 +269,14 @@ func (c *Config) GetMainTopic() string {
 	return c.KafkaTopics.UserEvents
 }
 
+// RateLimitConfig 事件上报限流配置（令牌桶，单位：事件数；速率为0表示不限流）
+type RateLimitConfig struct {
+	ProjectEventsPerSecond float64 // 每个项目每秒允许的事件数
+	ProjectBurst           int64   // 每个项目允许的突发事件数
+	IPEventsPerSecond      float64 // 每个IP每秒允许的事件数
+	IPBurst                int64   // 每个IP允许的突发事件数
+}
+
 // getEnv 获取环境变量，如果不存在则返回默认值
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -55,3 +284,34 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt 获取整数环境变量，如果不存在或无法解析则返回默认值
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvList 获取逗号分隔的列表环境变量，如果不存在则返回默认值
+func getEnvList(key string, defaultValue []string) []string {
+	return getEnvListSep(key, ",", defaultValue)
+}
+
+// getEnvListSep 获取指定分隔符的列表环境变量（用于可能包含逗号的取值，如正则表达式）
+func getEnvListSep(key, sep string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"insightflow/models"
+)
+
+// 追踪像素使用的Cookie
+const (
+	collectUserCookie    = "if_uid"
+	collectSessionCookie = "if_sid"
+
+	collectUserCookieMaxAge    = 365 * 24 * 3600
+	collectSessionCookieMaxAge = 30 * 60
+)
+
+// collectPropertyPrefix 自定义属性查询参数前缀（如 p.campaign=spring 写入 extra_data.campaign）
+const collectPropertyPrefix = "p."
+
+// transparentGIF 1x1 透明GIF
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// HandleCollect 处理GET方式的事件采集（追踪像素，用于邮件打开、AMP页面和无JS环境）
+// 事件从查询参数和Cookie构建，走与 HandleEvents 相同的验证、增强和Kafka流程
+// 携带 redirect 参数时作为点击追踪链接：记录事件后302跳转到目标地址
+// 为了不影响页面展示和用户跳转，事件被拒绝或限流时同样返回像素/跳转，只记录日志
+func (eh *EventHandler) HandleCollect(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// 点击追踪跳转目标（只允许http/https，可配置允许的域名）
+	redirectURL := query.Get("redirect")
+	if redirectURL != "" && !eh.isAllowedRedirect(r, redirectURL) {
+		http.Error(w, "Invalid redirect URL", http.StatusBadRequest)
+		return
+	}
+
+	event := eh.buildCollectEvent(w, r, redirectURL)
+	accepted, rejections, limit, err := eh.ingestEvents(r, []models.UserEvent{event}, ingestOptions{})
+	if !limit.Allowed {
+		log.Printf("像素事件被限流: 原因=%s", limit.Reason)
+	} else if err != nil {
+		log.Printf("像素事件发送失败，已丢弃: %v", err)
+	} else if accepted == 0 && len(rejections) > 0 {
+		log.Printf("像素事件被拒绝: %s", rejections[0].Message)
+	}
+
+	// 禁止缓存，保证每次打开/点击都会请求
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+
+	if redirectURL != "" {
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Content-Length", strconv.Itoa(len(transparentGIF)))
+	w.Write(transparentGIF)
+}
+
+// buildCollectEvent 从查询参数和Cookie构建事件
+// 未提供用户ID和会话ID时从Cookie读取，仍没有则生成新的ID并写入Cookie
+func (eh *EventHandler) buildCollectEvent(w http.ResponseWriter, r *http.Request, redirectURL string) models.UserEvent {
+	query := r.URL.Query()
+
+	event := models.UserEvent{
+		EventID:     query.Get("event_id"),
+		UserID:      query.Get("user_id"),
+		SessionID:   query.Get("session_id"),
+		EventType:   query.Get("event_type"),
+		PageURL:     query.Get("page_url"),
+		PageTitle:   query.Get("page_title"),
+		Element:     query.Get("element"),
+		ElementText: query.Get("element_text"),
+	}
+
+	// 默认事件类型：跳转链接为点击，像素为浏览
+	if event.EventType == "" {
+		event.EventType = models.EventTypeView
+		if redirectURL != "" {
+			event.EventType = models.EventTypeClick
+		}
+	}
+
+	// 页面URL：查询参数 > Referer > 跳转目标
+	if event.PageURL == "" {
+		event.PageURL = r.Referer()
+	}
+	if event.PageURL == "" {
+		event.PageURL = redirectURL
+	}
+
+	// 时间戳：未提供、无效或超出迟到窗口时使用服务器时间
+	// 像素和跳转链接在请求时才触发（邮件里写死的时间戳早已过期），请求时间就是事件时间
+	timeService := eh.ServiceManager.GetTimeService()
+	if timestamp, err := strconv.ParseInt(query.Get("timestamp"), 10, 64); err == nil && timestamp > 0 {
+		event.Timestamp = timestamp
+	}
+	if event.Timestamp <= 0 || timeService.GetEventAge(&event) > eh.Config.Ingestion.LatenessWindowSeconds {
+		timeService.SetCurrentTimestamp(&event)
+	}
+
+	if event.UserID == "" {
+		event.UserID = collectCookieID(w, r, collectUserCookie, "anon_", collectUserCookieMaxAge)
+	}
+	if event.SessionID == "" {
+		event.SessionID = collectCookieID(w, r, collectSessionCookie, "sess_", collectSessionCookieMaxAge)
+	}
+
+	event.ExtraData = collectProperties(query, redirectURL)
+	return event
+}
+
+// collectProperties 收集自定义属性：extra_data 参数（JSON对象）和 p.<name> 参数
+func collectProperties(query url.Values, redirectURL string) interface{} {
+	properties := make(map[string]interface{})
+
+	if raw := query.Get("extra_data"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &properties); err != nil {
+			log.Printf("像素事件extra_data解析失败: %v", err)
+		}
+	}
+
+	for key, values := range query {
+		if name := strings.TrimPrefix(key, collectPropertyPrefix); name != key && name != "" && len(values) > 0 {
+			properties[name] = values[0]
+		}
+	}
+
+	if redirectURL != "" {
+		properties["target_url"] = redirectURL
+	}
+
+	if len(properties) == 0 {
+		return nil
+	}
+	return properties
+}
+
+// collectCookieID 读取Cookie中的ID，没有则生成并写入Cookie（会话Cookie每次请求都续期）
+func collectCookieID(w http.ResponseWriter, r *http.Request, name, prefix string, maxAge int) string {
+	var id string
+	if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+		id = cookie.Value
+	} else {
+		id = prefix + randomID()
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// randomID 生成随机ID
+func randomID() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// isAllowedRedirect 检查跳转目标：必须是http/https绝对地址，且在域名白名单内；
+// 未配置白名单时只允许跳转到与请求相同的域名，避免成为开放跳转
+func (eh *EventHandler) isAllowedRedirect(r *http.Request, target string) bool {
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return false
+	}
+
+	allowedHosts := eh.Config.Ingestion.RedirectAllowedHosts
+	if len(allowedHosts) == 0 {
+		return strings.EqualFold(parsed.Host, r.Host)
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
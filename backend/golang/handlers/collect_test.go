@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"insightflow/config"
+	"insightflow/infrastructure"
+	"insightflow/services"
+)
+
+// newCollectTestHandler 创建不依赖Kafka和Redis的事件处理器（事件写入临时spool）
+func newCollectTestHandler(t *testing.T) *EventHandler {
+	t.Helper()
+
+	cfg := config.Load()
+	cfg.RateLimit = config.RateLimitConfig{}
+
+	spool, err := infrastructure.OpenSpool(infrastructure.SpoolOptions{
+		Dir:          t.TempDir(),
+		SegmentBytes: 1 << 20,
+		MaxBytes:     1 << 24,
+		FsyncPolicy:  "never",
+	})
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	t.Cleanup(func() { spool.Close() })
+
+	handler := NewEventHandler(cfg, nil, nil, nil, services.NewServiceManager())
+	handler.Spool = spool
+	return handler
+}
+
+// TestHandleCollectWithoutTimestamp 未携带 timestamp 的像素请求使用服务器时间，事件正常发送
+func TestHandleCollectWithoutTimestamp(t *testing.T) {
+	for _, target := range []string{
+		"/api/collect?page_url=https://example.com/mail",
+		"/api/collect?page_url=https://example.com/mail&timestamp=1000",
+	} {
+		handler := newCollectTestHandler(t)
+
+		recorder := httptest.NewRecorder()
+		handler.HandleCollect(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", target, recorder.Code, http.StatusOK)
+		}
+		if depth := handler.Spool.Depth(); depth != 1 {
+			t.Fatalf("%s: published %d events, want 1", target, depth)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"insightflow/infrastructure"
+)
+
+// DeadLetterHandler 死信队列管理处理器
+type DeadLetterHandler struct {
+	DeadLetter *infrastructure.DeadLetterQueue // 为空表示未启用死信队列
+}
+
+// NewDeadLetterHandler 创建死信队列管理处理器
+func NewDeadLetterHandler(deadLetter *infrastructure.DeadLetterQueue) *DeadLetterHandler {
+	return &DeadLetterHandler{
+		DeadLetter: deadLetter,
+	}
+}
+
+// HandleListDeadLetters 查看尚未重新投递的死信消息（limit 默认50，最多1000）
+func (dh *DeadLetterHandler) HandleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if dh.DeadLetter == nil {
+		http.Error(w, "未启用死信队列", http.StatusNotFound)
+		return
+	}
+	limit, ok := parseLimit(w, r, 50, 1000)
+	if !ok {
+		return
+	}
+
+	list, err := dh.DeadLetter.List(limit)
+	if err != nil {
+		log.Printf("查看死信队列失败: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// HandleRedriveDeadLetters 将死信消息重新投递到主topic（limit 默认1000，最多10000）
+// 无法解析的消息默认跳过，include_parse_errors=true 时一并投递
+func (dh *DeadLetterHandler) HandleRedriveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if dh.DeadLetter == nil {
+		http.Error(w, "未启用死信队列", http.StatusNotFound)
+		return
+	}
+	limit, ok := parseLimit(w, r, 1000, 10000)
+	if !ok {
+		return
+	}
+
+	includeParseErrors := r.URL.Query().Get("include_parse_errors") == "true"
+
+	// 中途失败时已投递的部分不会回滚，返回已投递数和错误
+	redriven, skipped, err := dh.DeadLetter.Redrive(limit, includeParseErrors)
+	response := map[string]interface{}{
+		"redriven": redriven,
+		"skipped":  skipped,
+	}
+	status := http.StatusOK
+	if err != nil {
+		log.Printf("重新投递死信消息失败: %v", err)
+		response["error"] = err.Error()
+		status = http.StatusBadGateway
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseLimit 解析 limit 查询参数（1到max之间，未指定时使用默认值），无效时输出400
+func parseLimit(w http.ResponseWriter, r *http.Request, defaultLimit, max int) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > max {
+		http.Error(w, "limit 必须是1到"+strconv.Itoa(max)+"之间的整数", http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
@@ -3,10 +3,15 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"insightflow/config"
 	"insightflow/infrastructure"
 	"insightflow/models"
 	"insightflow/services"
@@ -17,77 +22,308 @@ import (
 
 // EventHandler 事件处理器
 type EventHandler struct {
-	KafkaProducer  *infrastructure.KafkaProducer
-	EventProcessor *services.EventProcessor
-	Redis          *redis.Client
-	ServiceManager *services.ServiceManager
+	Config           *config.Config
+	KafkaProducer    *infrastructure.KafkaProducer
+	EventProcessor   *services.EventProcessor
+	Redis            *redis.Client
+	ServiceManager   *services.ServiceManager
+	ClientIPResolver *services.ClientIPResolver
+	RateLimiter      *services.RateLimiter
+	ConsentPolicy    services.ConsentPolicy
+	Spool            *infrastructure.Spool // Kafka不可用时的本地spool（可为空）
 }
 
 // NewEventHandler 创建事件处理器
-func NewEventHandler(kafkaProducer *infrastructure.KafkaProducer, eventProcessor *services.EventProcessor, redis *redis.Client, serviceManager *services.ServiceManager) *EventHandler {
+func NewEventHandler(cfg *config.Config, kafkaProducer *infrastructure.KafkaProducer, eventProcessor *services.EventProcessor, redis *redis.Client, serviceManager *services.ServiceManager) *EventHandler {
 	return &EventHandler{
-		KafkaProducer:  kafkaProducer,
-		EventProcessor: eventProcessor,
-		Redis:          redis,
-		ServiceManager: serviceManager,
+		Config:           cfg,
+		KafkaProducer:    kafkaProducer,
+		EventProcessor:   eventProcessor,
+		Redis:            redis,
+		ServiceManager:   serviceManager,
+		ClientIPResolver: services.NewClientIPResolver(cfg.Ingestion.TrustedProxies),
+		RateLimiter: services.NewRateLimiter(redis,
+			services.RateLimit{Rate: cfg.RateLimit.ProjectEventsPerSecond, Burst: cfg.RateLimit.ProjectBurst},
+			services.RateLimit{Rate: cfg.RateLimit.IPEventsPerSecond, Burst: cfg.RateLimit.IPBurst},
+		),
+		ConsentPolicy: services.ConsentPolicy{
+			DefaultMode: cfg.Privacy.ConsentDefaultMode,
+			OptOutMode:  cfg.Privacy.ConsentOptOutMode,
+		},
 	}
 }
 
 // HandleEvents 处理事件上报
 func (eh *EventHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
-	var req models.EventBatchRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	// 解析请求体（支持gzip/deflate压缩、sendBeacon文本和NDJSON）
+	events, err := decodeEventPayload(r, eh.Config.Ingestion.MaxPayloadBytes)
+	if err != nil {
+		log.Printf("解析事件请求失败: %v", err)
+		status := http.StatusBadRequest
+		var payloadErr *payloadError
+		if errors.As(err, &payloadErr) {
+			status = payloadErr.status
+		}
+		http.Error(w, "Invalid payload: "+err.Error(), status)
 		return
 	}
 
-	// 验证和预处理事件
-	validEvents := make([]models.UserEvent, 0, len(req.Events))
-	for _, event := range req.Events {
+	accepted, rejections, limit, err := eh.ingestEvents(r, events, ingestOptions{})
+	if !limit.Allowed {
+		writeRateLimited(w, limit, len(events))
+		return
+	}
+	if err != nil {
+		writeUnavailable(w, len(events))
+		return
+	}
+
+	// 返回接收结果（包含被拒绝事件明细）
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildEventResponse(accepted, rejections))
+}
+
+// ingestOptions 事件接收选项
+type ingestOptions struct {
+	// serverSide 服务端签名上报：允许任意历史时间戳（超出实时窗口的事件走回填路径），
+	// 事件来源默认为server，且不用请求方（服务端）的IP和User-Agent补全事件
+	serverSide bool
+}
+
+// ingestEvents 事件接收主流程：限流、验证、新鲜度检查、增强，并发送到Kafka
+// 返回接收的事件数和被拒绝事件明细；被限流时返回的 RateLimitResult.Allowed 为 false；
+// 事件无法发送到Kafka也无法写入spool时返回错误（事件未被接收，客户端应重试）
+func (eh *EventHandler) ingestEvents(r *http.Request, events []models.UserEvent, opts ingestOptions) (int, []models.EventRejection, services.RateLimitResult, error) {
+	// 事件归属的项目由写入密钥决定，忽略客户端自带的project_id
+	projectID := services.ProjectIDFromContext(r.Context())
+
+	// 请求上下文，用于补全事件中缺失的字段
+	reqCtx := services.RequestContext{
+		UserAgent: r.UserAgent(),
+		ClientIP:  eh.ClientIPResolver.Resolve(r),
+	}
+	enrichCtx := reqCtx
+	if opts.serverSide {
+		enrichCtx = services.RequestContext{}
+	}
+
+	// DNT/Sec-GPC 是访客浏览器的信号，服务端上报只看事件自带的同意状态
+	optOut := !opts.serverSide && services.HasOptOutSignal(r.Header)
+
+	// 限流与配额检查（按本批事件数计算）
+	var monthlyQuota int64
+	if project, ok := services.ProjectFromContext(r.Context()); ok {
+		monthlyQuota = project.MonthlyEventQuota
+	}
+	limit := eh.RateLimiter.AllowEvents(r.Context(), projectID, reqCtx.ClientIP, len(events), monthlyQuota)
+	if !limit.Allowed {
+		log.Printf("事件上报被限流: 项目=%s, IP=%s, 原因=%s, 事件数=%d", projectID, reqCtx.ClientIP, limit.Reason, len(events))
+		return 0, nil, limit, nil
+	}
+
+	// 验证和预处理事件，记录每个被拒绝事件的原因
+	scrubber := eh.ServiceManager.GetPIIScrubber()
+	redactions := make(map[string]int64)
+	validEvents := make([]models.UserEvent, 0, len(events))
+	rejections := make([]models.EventRejection, 0)
+	for i, event := range events {
+		event.ProjectID = projectID
+		if !opts.serverSide {
+			event.Source = models.EventSourceClient
+		} else if event.Source != models.EventSourceClient {
+			event.Source = models.EventSourceServer
+		}
+
 		// 使用验证器验证事件
 		if err := eh.ServiceManager.GetEventValidator().ValidateComplete(&event); err != nil {
-			log.Printf("事件验证失败: %v", err)
+			log.Printf("事件验证失败: index=%d, %v", i, err)
+			rejections = append(rejections, toRejection(i, err))
 			continue
 		}
 
-		// 使用时间服务设置时间戳（如果没有的话）
-		if event.Timestamp <= 0 {
-			eh.ServiceManager.GetTimeService().SetCurrentTimestamp(&event)
+		// 检查事件新鲜度：实时窗口内正常处理，迟到窗口内走回填路径，超出则拒绝
+		// 迟到标记只由服务端判定，客户端自带的取值会被清除（否则可借此绕过实时统计）
+		event.IsLate = false
+		timeService := eh.ServiceManager.GetTimeService()
+		ingestion := eh.Config.Ingestion
+		if !timeService.IsEventFresh(&event, ingestion.FreshWindowSeconds) {
+			if !opts.serverSide && !timeService.IsEventLate(&event, ingestion.FreshWindowSeconds, ingestion.LatenessWindowSeconds) {
+				age := timeService.GetEventAge(&event)
+				log.Printf("事件过期，用户: %s, 年龄: %d秒", event.UserID, age)
+				rejections = append(rejections, models.EventRejection{
+					Index:   i,
+					Field:   "timestamp",
+					Rule:    fmt.Sprintf("max_age_%ds", ingestion.LatenessWindowSeconds),
+					Code:    models.RejectCodeEventExpired,
+					Message: fmt.Sprintf("事件已过期: 年龄%d秒，超过%d秒", age, ingestion.LatenessWindowSeconds),
+				})
+				continue
+			}
+			event.IsLate = true
 		}
 
-		// 检查事件新鲜度（超过5分钟的事件丢弃）
-		if !eh.ServiceManager.GetTimeService().IsEventFresh(&event, 300) {
-			log.Printf("事件过期，用户: %s, 年龄: %d秒", event.UserID, eh.ServiceManager.GetTimeService().GetEventAge(&event))
-			continue
+		// 事件增强：解析设备、浏览器、操作系统和地理位置
+		eh.ServiceManager.GetEventEnricher().Enrich(&event, enrichCtx)
+
+		// 机器人识别需要完整的User-Agent和IP，在脱敏和处理模式精简之前执行
+		eh.ServiceManager.GetBotDetector().ClassifyRequest(&event)
+
+		// 脱敏在增强之后（地理位置解析需要完整IP）、发送到Kafka之前执行
+		for field, count := range scrubber.Scrub(&event) {
+			redactions[field] += count
 		}
 
+		// 按同意状态确定处理模式，未同意完整追踪的事件不把用户标识发送到Kafka
+		services.ApplyTrackingMode(&event, eh.ConsentPolicy.Mode(event.Consent, optOut))
+
 		validEvents = append(validEvents, event)
 	}
+	scrubber.RecordRedactions(r.Context(), projectID, redactions)
+
+	// 批量发送有效事件到Kafka（不可用时写入本地spool）
+	if err := eh.publishEvents(validEvents); err != nil {
+		return 0, rejections, limit, err
+	}
 
-	// 发送有效事件到Kafka
-	for _, event := range validEvents {
-		if err := eh.KafkaProducer.SendEvent(event); err != nil {
-			log.Printf("发送事件到Kafka失败: %v", err)
-			// 降级：直接处理事件
-			go eh.EventProcessor.ProcessEvent(event)
+	return len(validEvents), rejections, limit, nil
+}
+
+// HandleTrack 处理服务端签名上报（订单发货、订阅续费等不经过浏览器的事件）
+// 请求体格式与 /api/events 相同，允许携带任意历史时间戳
+func (eh *EventHandler) HandleTrack(w http.ResponseWriter, r *http.Request) {
+	events, err := decodeEventPayload(r, eh.Config.Ingestion.MaxPayloadBytes)
+	if err != nil {
+		log.Printf("解析服务端上报请求失败: %v", err)
+		status := http.StatusBadRequest
+		var payloadErr *payloadError
+		if errors.As(err, &payloadErr) {
+			status = payloadErr.status
 		}
+		http.Error(w, "Invalid payload: "+err.Error(), status)
+		return
+	}
+
+	accepted, rejections, limit, err := eh.ingestEvents(r, events, ingestOptions{serverSide: true})
+	if !limit.Allowed {
+		writeRateLimited(w, limit, len(events))
+		return
+	}
+	if err != nil {
+		writeUnavailable(w, len(events))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildEventResponse(accepted, rejections))
+}
+
+// publishEvents 发送事件到Kafka
+// spool中有积压或最近一次发送失败时直接追加到spool，保证Kafka恢复后按顺序回放，也不必每个请求都等待发送超时；
+// 发送失败时整批写入spool，保证同一用户的事件顺序（已投递成功的事件回放后按event_id去重）；
+// 未配置spool或写入spool失败时返回错误，由客户端重试
+func (eh *EventHandler) publishEvents(events []models.UserEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var sendErr error
+	if eh.Spool == nil || (eh.Spool.Depth() == 0 && eh.KafkaProducer.Healthy()) {
+		report := eh.KafkaProducer.SendEvents(events)
+		if len(report.Failed) == 0 {
+			return nil
+		}
+		log.Printf("发送事件到Kafka失败: 失败=%d/%d, %v", len(report.Failed), len(events), report.Err)
+		sendErr = fmt.Errorf("发送事件到Kafka失败: 失败=%d/%d, %v", len(report.Failed), len(events), report.Err)
+	}
+
+	if eh.Spool == nil {
+		return sendErr
+	}
+	if err := eh.Spool.Append(events); err != nil {
+		log.Printf("写入本地spool失败: %v", err)
+		return fmt.Errorf("写入本地spool失败: %w", err)
+	}
+	return nil
+}
+
+// unavailableRetryAfter 事件无法接收时建议客户端重试的间隔（秒）
+const unavailableRetryAfter = 5
+
+// writeUnavailable 输出事件暂时无法接收的响应（503 + Retry-After）
+func writeUnavailable(w http.ResponseWriter, count int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(unavailableRetryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(models.EventResponse{
+		Status:   models.EventStatusUnavailable,
+		Message:  "Event pipeline unavailable, retry later",
+		Count:    0,
+		Rejected: count,
+	})
+}
+
+// writeRateLimited 输出限流响应（429 + Retry-After）
+func writeRateLimited(w http.ResponseWriter, limit services.RateLimitResult, count int) {
+	retryAfter := int64(math.Ceil(limit.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
 	}
 
-	// 返回成功响应
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	w.WriteHeader(http.StatusTooManyRequests)
 	json.NewEncoder(w).Encode(models.EventResponse{
-		Status:  "success",
-		Message: "Events received",
-		Count:   len(validEvents),
+		Status:   models.EventStatusRateLimited,
+		Message:  "Rate limit exceeded: " + limit.Reason,
+		Count:    0,
+		Rejected: count,
 	})
 }
 
+// buildEventResponse 根据接收和拒绝数量构建事件接收响应
+func buildEventResponse(accepted int, rejections []models.EventRejection) models.EventResponse {
+	status := models.EventStatusSuccess
+	message := "Events received"
+	if len(rejections) > 0 {
+		if accepted > 0 {
+			status = models.EventStatusPartialSuccess
+			message = "Some events were rejected"
+		} else {
+			status = models.EventStatusRejected
+			message = "All events were rejected"
+		}
+	}
+
+	return models.EventResponse{
+		Status:     status,
+		Message:    message,
+		Count:      accepted,
+		Rejected:   len(rejections),
+		Rejections: rejections,
+	}
+}
+
+// toRejection 将验证错误转换为拒绝明细
+func toRejection(index int, err error) models.EventRejection {
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.ToRejection(index)
+	}
+	return models.EventRejection{
+		Index:   index,
+		Rule:    "valid_event",
+		Code:    models.RejectCodeInvalidEvent,
+		Message: err.Error(),
+	}
+}
+
 // HandleOnlineUsers 处理在线用户数查询
 func (eh *EventHandler) HandleOnlineUsers(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
+	projectID := services.ProjectIDFromContext(r.Context())
 
 	// 使用统计服务获取在线用户数
-	count := eh.ServiceManager.GetStatsService().GetOnlineUserCount(ctx)
+	count := eh.ServiceManager.GetStatsService().GetOnlineUserCount(ctx, projectID)
 
 	response := map[string]interface{}{
 		"count":     count,
@@ -101,9 +337,35 @@ func (eh *EventHandler) HandleOnlineUsers(w http.ResponseWriter, r *http.Request
 // HandleHotPages 处理热门页面查询
 func (eh *EventHandler) HandleHotPages(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
+	projectID := services.ProjectIDFromContext(r.Context())
+
+	q, err := parseEventQuery(r, 24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 带过滤或分组条件时按事件明细统计（默认最近24小时），否则使用实时排行榜
+	if q.HasConditions() {
+		pages, truncated, err := eh.EventProcessor.QueryHotPages(ctx, q, 10)
+		if err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		response := map[string]interface{}{
+			"pages":            pages,
+			"filters":          q.Filters,
+			"group_by":         q.GroupBy,
+			"groups_truncated": truncated,
+			"timestamp":        eh.ServiceManager.GetTimeService().GetCurrentTimeString(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
 
 	// 使用统计服务获取热门页面（内置缓存逻辑）
-	hotPages, err := eh.ServiceManager.GetStatsService().GetHotPages(ctx, 10)
+	hotPages, err := eh.ServiceManager.GetStatsService().GetHotPages(ctx, projectID, 10)
 	if err != nil {
 		log.Printf("获取热门页面失败: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -122,20 +384,190 @@ func (eh *EventHandler) HandleHotPages(w http.ResponseWriter, r *http.Request) {
 // HandleEventStats 处理事件统计查询
 func (eh *EventHandler) HandleEventStats(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
+	projectID := services.ProjectIDFromContext(r.Context())
+
+	q, err := parseEventQuery(r, 24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 带过滤或分组条件时按事件明细统计（默认最近24小时）
+	if q.HasConditions() {
+		stats, err := eh.EventProcessor.QueryEventStats(ctx, q)
+		if err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+		return
+	}
 
 	// 使用统计服务获取事件统计
-	stats := eh.ServiceManager.GetStatsService().GetEventStats(ctx)
+	stats := eh.ServiceManager.GetStatsService().GetEventStats(ctx, projectID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// maxTimeSeriesPoints 时间序列最多返回的时间点数（按小时为90天）
+const maxTimeSeriesPoints = 90 * 24
+
+// HandleTimeSeries 处理事件时间序列查询（interval=hour|day，支持过滤和分组）
+func (eh *EventHandler) HandleTimeSeries(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	interval := time.Hour
+	switch r.URL.Query().Get("interval") {
+	case "", "hour":
+	case "day":
+		interval = 24 * time.Hour
+	default:
+		http.Error(w, "interval 取值为 hour 或 day", http.StatusBadRequest)
+		return
+	}
+
+	q, err := parseEventQuery(r, 24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if queryUntil(q).Sub(q.Since) > maxTimeSeriesPoints*interval {
+		http.Error(w, fmt.Sprintf("时间范围过大：最多 %d 个时间点（按小时最多90天）", maxTimeSeriesPoints), http.StatusBadRequest)
+		return
+	}
+
+	points, truncated, err := eh.EventProcessor.QueryTimeSeries(ctx, q, interval)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"interval":         interval.String(),
+		"points":           points,
+		"filters":          q.Filters,
+		"group_by":         q.GroupBy,
+		"groups_truncated": truncated,
+		"timestamp":        eh.ServiceManager.GetTimeService().GetCurrentTimeString(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeQueryError 输出明细统计查询错误
+func writeQueryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("明细统计查询失败: %v", err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// HandleBreakdown 处理维度分布查询（device_type, browser, os, country, region, city）
+func (eh *EventHandler) HandleBreakdown(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	projectID := services.ProjectIDFromContext(r.Context())
+	statsService := eh.ServiceManager.GetStatsService()
+
+	dimension := r.URL.Query().Get("dimension")
+	if !statsService.IsValidDimension(dimension) {
+		http.Error(w, "不支持的统计维度: "+dimension, http.StatusBadRequest)
+		return
+	}
+
+	breakdown, err := statsService.GetBreakdown(ctx, projectID, dimension)
+	if err != nil {
+		log.Printf("获取维度分布失败: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"dimension": dimension,
+		"values":    breakdown,
+		"timestamp": eh.ServiceManager.GetTimeService().GetCurrentTimeString(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleConsentStats 处理同意率查询（按天统计完整追踪、匿名和丢弃的事件数，days 默认7，最多90）
+func (eh *EventHandler) HandleConsentStats(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	projectID := services.ProjectIDFromContext(r.Context())
+
+	days := 7
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 90 {
+			http.Error(w, "days 必须是1到90之间的整数", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+
+	stats, err := eh.ServiceManager.GetStatsService().GetConsentStats(ctx, projectID, days)
+	if err != nil {
+		log.Printf("获取同意率统计失败: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// HandleBotStats 处理机器人流量统计查询（机器人流量不计入其他统计接口）
+func (eh *EventHandler) HandleBotStats(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	projectID := services.ProjectIDFromContext(r.Context())
+
+	stats, err := eh.ServiceManager.GetStatsService().GetBotStats(ctx, projectID, 10)
+	if err != nil {
+		log.Printf("获取机器人流量统计失败: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
 }
 
+// HandleIngestionStats 处理上报限流与配额统计查询
+func (eh *EventHandler) HandleIngestionStats(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	projectID := services.ProjectIDFromContext(r.Context())
+
+	var monthlyQuota int64
+	if project, ok := services.ProjectFromContext(r.Context()); ok {
+		monthlyQuota = project.MonthlyEventQuota
+	}
+
+	response := map[string]interface{}{
+		"project_id":          projectID,
+		"monthly_event_quota": monthlyQuota,
+		"monthly_usage":       eh.RateLimiter.GetMonthlyUsage(ctx, projectID),
+		"rejected_events":     eh.RateLimiter.GetRejectedCounts(ctx, projectID),
+		"pii_redactions":      eh.ServiceManager.GetPIIScrubber().GetRedactionCounts(ctx, projectID),
+		"timestamp":           eh.ServiceManager.GetTimeService().GetCurrentTimeString(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 // HandleConversionRate 处理转化率查询
 func (eh *EventHandler) HandleConversionRate(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
+	projectID := services.ProjectIDFromContext(r.Context())
 
 	// 使用统计服务获取转化率
-	rate := eh.ServiceManager.GetStatsService().GetConversionRate(ctx)
+	rate := eh.ServiceManager.GetStatsService().GetConversionRate(ctx, projectID)
 
 	response := map[string]interface{}{
 		"rate":      rate,
@@ -149,9 +581,10 @@ func (eh *EventHandler) HandleConversionRate(w http.ResponseWriter, r *http.Requ
 // HandleDashboard 处理仪表盘数据查询
 func (eh *EventHandler) HandleDashboard(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
+	projectID := services.ProjectIDFromContext(r.Context())
 
 	// 使用统计服务获取仪表盘数据（内置缓存逻辑）
-	stats, err := eh.ServiceManager.GetStatsService().GetDashboardStats(ctx)
+	stats, err := eh.ServiceManager.GetStatsService().GetDashboardStats(ctx, projectID)
 	if err != nil {
 		log.Printf("获取仪表盘统计失败: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -173,8 +606,12 @@ func (eh *EventHandler) HandleUserEvents(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// 配置了哈希 user_id 时，事件中存储的是哈希后的ID
+	userID = eh.ServiceManager.GetPIIScrubber().HashUserID(userID)
+
 	// 使用缓存服务生成缓存键（用户事件缓存1小时）
-	cacheKey := eh.ServiceManager.GetCacheService().GenerateCacheKey("user_events", userID)
+	projectID := services.ProjectIDFromContext(r.Context())
+	cacheKey := services.ProjectKey(projectID, eh.ServiceManager.GetCacheService().GenerateCacheKey("user_events", userID))
 
 	// 尝试从缓存获取
 	ctx := context.Background()
@@ -187,7 +624,7 @@ func (eh *EventHandler) HandleUserEvents(w http.ResponseWriter, r *http.Request)
 	}
 
 	// 缓存未命中，查询数据库
-	events := eh.EventProcessor.GetUserPath(userID, 100)
+	events := eh.EventProcessor.GetUserPath(projectID, userID, 100)
 
 	response := map[string]interface{}{
 		"user_id":   userID,
@@ -216,9 +653,16 @@ func (eh *EventHandler) HandleFunnelAnalysis(w http.ResponseWriter, r *http.Requ
 	vars := mux.Vars(r)
 	funnelId := vars["funnelId"]
 
-	// 使用缓存服务生成缓存键（漏斗分析缓存30分钟）
+	q, err := parseEventQuery(r, 24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 使用缓存服务生成缓存键（漏斗分析缓存30分钟，查询条件不同分别缓存）
+	projectID := q.ProjectID
 	timeKey := eh.ServiceManager.GetTimeService().GetCurrentTimeString()[:16] // YYYY-MM-DD HH:MM
-	cacheKey := eh.ServiceManager.GetCacheService().GenerateCacheKey("funnel", funnelId, timeKey)
+	cacheKey := services.ProjectKey(projectID, eh.ServiceManager.GetCacheService().GenerateCacheKey("funnel", funnelId, timeKey, r.URL.Query().Encode()))
 
 	// 尝试从缓存获取
 	ctx := context.Background()
@@ -231,7 +675,7 @@ func (eh *EventHandler) HandleFunnelAnalysis(w http.ResponseWriter, r *http.Requ
 	}
 
 	// 缓存未命中，重新计算
-	funnel := eh.EventProcessor.CalculateFunnel()
+	funnel := eh.EventProcessor.CalculateFunnel(q)
 
 	// 添加时间戳和漏斗ID
 	response := map[string]interface{}{
@@ -239,6 +683,9 @@ func (eh *EventHandler) HandleFunnelAnalysis(w http.ResponseWriter, r *http.Requ
 		"steps":           funnel.Steps,
 		"total_users":     funnel.TotalUsers,
 		"conversion_rate": funnel.ConversionRate,
+		"groups":          funnel.Groups,
+		"filters":         q.Filters,
+		"group_by":        q.GroupBy,
 		"timestamp":       eh.ServiceManager.GetTimeService().GetCurrentTimeString(),
 		"cache_key":       cacheKey,
 	}
@@ -258,6 +705,48 @@ func (eh *EventHandler) HandleFunnelAnalysis(w http.ResponseWriter, r *http.Requ
 	w.Write(responseData)
 }
 
+// HandleListSchemas 列出当前生效的事件Schema
+func (eh *EventHandler) HandleListSchemas(w http.ResponseWriter, r *http.Request) {
+	registry := eh.ServiceManager.GetSchemaRegistry()
+	if registry == nil {
+		http.Error(w, "Schema registry not configured", http.StatusNotFound)
+		return
+	}
+
+	response := map[string]interface{}{
+		"schemas":   registry.List(),
+		"loaded_at": registry.LoadedAt().Format("2006-01-02 15:04:05"),
+		"timestamp": eh.ServiceManager.GetTimeService().GetCurrentTimeString(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleReloadSchemas 重新加载事件Schema（无需重启服务）
+func (eh *EventHandler) HandleReloadSchemas(w http.ResponseWriter, r *http.Request) {
+	registry := eh.ServiceManager.GetSchemaRegistry()
+	if registry == nil {
+		http.Error(w, "Schema registry not configured", http.StatusNotFound)
+		return
+	}
+
+	if err := registry.Reload(); err != nil {
+		log.Printf("重新加载事件Schema失败: %v", err)
+		http.Error(w, "Failed to reload schemas", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":    "success",
+		"count":     len(registry.List()),
+		"timestamp": eh.ServiceManager.GetTimeService().GetCurrentTimeString(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 // HandleHealth 健康检查
 func (eh *EventHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	// 使用时间服务获取标准化时间
@@ -280,6 +769,15 @@ func (eh *EventHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 		Service:   "InsightFlow Data Processor",
 	}
 
+	// 报告本地spool积压（有积压说明Kafka投递不畅）
+	if eh.Spool != nil {
+		spoolStatus := eh.Spool.Stats()
+		response.Spool = &spoolStatus
+		if spoolStatus.Depth > 0 {
+			response.Status = "degraded"
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"insightflow/services"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// IdentityHandler 用户身份关联处理器
+type IdentityHandler struct {
+	IdentityService *services.IdentityService
+	Redis           *redis.Client
+	ServiceManager  *services.ServiceManager
+}
+
+// NewIdentityHandler 创建用户身份关联处理器
+func NewIdentityHandler(identityService *services.IdentityService, redis *redis.Client, serviceManager *services.ServiceManager) *IdentityHandler {
+	return &IdentityHandler{
+		IdentityService: identityService,
+		Redis:           redis,
+		ServiceManager:  serviceManager,
+	}
+}
+
+// identifyRequest 登录关联请求
+type identifyRequest struct {
+	UserID      string `json:"user_id"`
+	AnonymousID string `json:"anonymous_id"`
+}
+
+// aliasRequest 用户ID合并请求
+type aliasRequest struct {
+	UserID     string `json:"user_id"`
+	PreviousID string `json:"previous_id"`
+}
+
+// identityResponse 身份关联结果
+type identityResponse struct {
+	ProjectID   string   `json:"project_id"`
+	UserID      string   `json:"user_id"`
+	CanonicalID string   `json:"canonical_id"`
+	Aliases     []string `json:"aliases,omitempty"`
+}
+
+// HandleIdentify 用户登录后将匿名ID关联到登录用户ID（仅限服务端签名调用）
+func (ih *IdentityHandler) HandleIdentify(w http.ResponseWriter, r *http.Request) {
+	var req identifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	scrubber := ih.ServiceManager.GetPIIScrubber()
+	req.AnonymousID, req.UserID = scrubber.HashUserID(req.AnonymousID), scrubber.HashUserID(req.UserID)
+
+	projectID := services.ProjectIDFromContext(r.Context())
+	canonicalID, err := ih.IdentityService.Identify(r.Context(), projectID, req.AnonymousID, req.UserID)
+	if err != nil {
+		writeIdentityError(w, err)
+		return
+	}
+	ih.invalidateUserCache(projectID, req.AnonymousID, req.UserID, canonicalID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identityResponse{ProjectID: projectID, UserID: req.UserID, CanonicalID: canonicalID})
+}
+
+// HandleAlias 将旧用户ID合并到新用户ID（旧ID已关联的所有ID一并合并；仅限服务端签名调用）
+func (ih *IdentityHandler) HandleAlias(w http.ResponseWriter, r *http.Request) {
+	var req aliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	scrubber := ih.ServiceManager.GetPIIScrubber()
+	req.PreviousID, req.UserID = scrubber.HashUserID(req.PreviousID), scrubber.HashUserID(req.UserID)
+
+	projectID := services.ProjectIDFromContext(r.Context())
+	canonicalID, err := ih.IdentityService.Alias(r.Context(), projectID, req.PreviousID, req.UserID)
+	if err != nil {
+		writeIdentityError(w, err)
+		return
+	}
+	ih.invalidateUserCache(projectID, req.PreviousID, req.UserID, canonicalID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identityResponse{ProjectID: projectID, UserID: req.UserID, CanonicalID: canonicalID})
+}
+
+// HandleGetIdentity 查询用户的规范ID及关联的所有ID
+func (ih *IdentityHandler) HandleGetIdentity(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["userId"]
+	if userID == "" {
+		http.Error(w, "用户ID不能为空", http.StatusBadRequest)
+		return
+	}
+
+	userID = ih.ServiceManager.GetPIIScrubber().HashUserID(userID)
+
+	projectID := services.ProjectIDFromContext(r.Context())
+	canonicalID := ih.IdentityService.Resolve(r.Context(), projectID, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identityResponse{
+		ProjectID:   projectID,
+		UserID:      userID,
+		CanonicalID: canonicalID,
+		Aliases:     ih.IdentityService.Aliases(r.Context(), projectID, canonicalID),
+	})
+}
+
+// invalidateUserCache 清除关联双方的用户事件缓存
+func (ih *IdentityHandler) invalidateUserCache(projectID string, userIDs ...string) {
+	cacheService := ih.ServiceManager.GetCacheService()
+	keys := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		keys = append(keys, services.ProjectKey(projectID, cacheService.GenerateCacheKey("user_events", userID)))
+	}
+	ih.Redis.Del(context.Background(), keys...)
+}
+
+// writeIdentityError 输出身份关联相关错误
+func writeIdentityError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidIdentity):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrIdentityConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("用户身份关联失败: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
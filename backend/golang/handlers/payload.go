@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"insightflow/models"
+)
+
+// 事件请求体解析错误
+var (
+	errUnsupportedEncoding    = errors.New("unsupported content encoding")
+	errUnsupportedContentType = errors.New("unsupported content type")
+	errPayloadTooLarge        = errors.New("payload too large")
+)
+
+// payloadError 请求体解析错误，携带对应的HTTP状态码
+type payloadError struct {
+	status int
+	err    error
+}
+
+func (e *payloadError) Error() string { return e.err.Error() }
+func (e *payloadError) Unwrap() error { return e.err }
+
+// decodeEventPayload 解析事件上报请求体
+// 支持的格式：
+// - Content-Encoding: gzip / deflate / identity
+// - application/json：{"events": [...]}、事件数组或单个事件
+// - text/plain：navigator.sendBeacon 发送的JSON字符串，格式同上
+// - application/x-ndjson：每行一个事件（也兼容每行一个批量请求）
+func decodeEventPayload(r *http.Request, maxBytes int64) ([]models.UserEvent, error) {
+	body, err := decodeContentEncoding(r)
+	if err != nil {
+		return nil, &payloadError{status: http.StatusUnsupportedMediaType, err: err}
+	}
+	defer body.Close()
+
+	// 限制解压后的大小，防止压缩炸弹
+	data, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err != nil {
+		return nil, &payloadError{status: http.StatusBadRequest, err: err}
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, &payloadError{status: http.StatusRequestEntityTooLarge, err: errPayloadTooLarge}
+	}
+
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		if parsed, _, err := mime.ParseMediaType(contentType); err == nil {
+			mediaType = parsed
+		}
+	}
+
+	var events []models.UserEvent
+	switch mediaType {
+	case "application/json", "text/plain":
+		events, err = decodeJSONEvents(data)
+	case "application/x-ndjson", "application/jsonl", "application/jsonlines":
+		events, err = decodeNDJSONEvents(data)
+	default:
+		return nil, &payloadError{status: http.StatusUnsupportedMediaType, err: fmt.Errorf("%w: %s", errUnsupportedContentType, mediaType)}
+	}
+	if err != nil {
+		return nil, &payloadError{status: http.StatusBadRequest, err: err}
+	}
+	return events, nil
+}
+
+// decodeContentEncoding 根据Content-Encoding返回解压后的请求体
+func decodeContentEncoding(r *http.Request) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r.Body)
+	case "deflate":
+		return zlib.NewReader(r.Body)
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, r.Header.Get("Content-Encoding"))
+	}
+}
+
+// decodeJSONEvents 解析JSON请求体（批量对象、事件数组或单个事件）
+func decodeJSONEvents(data []byte) ([]models.UserEvent, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("empty body")
+	}
+
+	if data[0] == '[' {
+		var events []models.UserEvent
+		if err := json.Unmarshal(data, &events); err != nil {
+			return nil, err
+		}
+		return events, nil
+	}
+
+	return decodeJSONObject(data)
+}
+
+// decodeNDJSONEvents 解析换行分隔的JSON流
+func decodeNDJSONEvents(data []byte) ([]models.UserEvent, error) {
+	var events []models.UserEvent
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		lineEvents, err := decodeJSONObject(line)
+		if err != nil {
+			return nil, fmt.Errorf("第%d行解析失败: %w", lineNumber, err)
+		}
+		events = append(events, lineEvents...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// decodeJSONObject 解析单个JSON对象：含events字段时按批量请求处理，否则按单个事件处理
+func decodeJSONObject(data []byte) ([]models.UserEvent, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+
+	if _, ok := probe["events"]; ok {
+		var req models.EventBatchRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, err
+		}
+		return req.Events, nil
+	}
+
+	var event models.UserEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+	return []models.UserEvent{event}, nil
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"insightflow/models"
+	"insightflow/services"
+
+	"github.com/gorilla/mux"
+)
+
+// PrivacyHandler 用户数据删除与导出处理器（GDPR）
+type PrivacyHandler struct {
+	PrivacyService   *services.PrivacyService
+	ServiceManager   *services.ServiceManager
+	ClientIPResolver *services.ClientIPResolver
+}
+
+// NewPrivacyHandler 创建用户数据删除与导出处理器
+func NewPrivacyHandler(privacyService *services.PrivacyService, serviceManager *services.ServiceManager, clientIPResolver *services.ClientIPResolver) *PrivacyHandler {
+	return &PrivacyHandler{
+		PrivacyService:   privacyService,
+		ServiceManager:   serviceManager,
+		ClientIPResolver: clientIPResolver,
+	}
+}
+
+// HandleEraseUser 创建删除用户数据的任务（异步执行，返回任务状态）
+func (ph *PrivacyHandler) HandleEraseUser(w http.ResponseWriter, r *http.Request) {
+	ph.submit(w, r, models.PrivacyJobErase)
+}
+
+// HandleExportUser 创建导出用户数据的任务（异步执行，完成后通过任务状态中的下载地址获取）
+func (ph *PrivacyHandler) HandleExportUser(w http.ResponseWriter, r *http.Request) {
+	ph.submit(w, r, models.PrivacyJobExport)
+}
+
+// HandleGetJob 查询删除/导出任务状态
+func (ph *PrivacyHandler) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	projectID := services.ProjectIDFromContext(r.Context())
+	job, err := ph.PrivacyService.GetJob(r.Context(), projectID, mux.Vars(r)["jobId"])
+	if err != nil {
+		writePrivacyError(w, err)
+		return
+	}
+	ph.writeJob(w, http.StatusOK, job)
+}
+
+// HandleDownloadExport 下载导出文件
+func (ph *PrivacyHandler) HandleDownloadExport(w http.ResponseWriter, r *http.Request) {
+	projectID := services.ProjectIDFromContext(r.Context())
+	jobID := mux.Vars(r)["jobId"]
+
+	filePath, err := ph.PrivacyService.ExportFile(r.Context(), projectID, jobID, ph.actor(r))
+	if err != nil {
+		writePrivacyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(filePath)+`"`)
+	http.ServeFile(w, r, filePath)
+}
+
+// submit 创建任务并返回 202
+func (ph *PrivacyHandler) submit(w http.ResponseWriter, r *http.Request, jobType string) {
+	// 配置了哈希 user_id 时，存储的是哈希后的ID
+	userID := ph.ServiceManager.GetPIIScrubber().HashUserID(mux.Vars(r)["userId"])
+	projectID := services.ProjectIDFromContext(r.Context())
+
+	job, err := ph.PrivacyService.Submit(r.Context(), projectID, userID, jobType, ph.actor(r))
+	if err != nil {
+		writePrivacyError(w, err)
+		return
+	}
+	ph.writeJob(w, http.StatusAccepted, job)
+}
+
+// writeJob 输出任务状态（导出文件可用时附带下载地址）
+func (ph *PrivacyHandler) writeJob(w http.ResponseWriter, status int, job *models.PrivacyJob) {
+	if job.JobType == models.PrivacyJobExport && job.Status == models.PrivacyJobCompleted && job.FilePath != "" {
+		job.DownloadURL = "/api/privacy/jobs/" + job.JobID + "/download"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(job)
+}
+
+// actor 审计日志中的操作来源
+func (ph *PrivacyHandler) actor(r *http.Request) string {
+	return "ip=" + ph.ClientIPResolver.Resolve(r) + " request_id=" + r.Header.Get("X-Request-ID")
+}
+
+// writePrivacyError 输出删除/导出相关错误
+func writePrivacyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidIdentity):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrPrivacyJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrExportNotReady):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("用户数据删除/导出失败: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+
+	"insightflow/services"
+
+	"github.com/gorilla/mux"
+)
+
+// projectIDPattern 项目ID格式：小写字母、数字、下划线和中划线
+var projectIDPattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// ProjectHandler 项目管理处理器
+type ProjectHandler struct {
+	ProjectService *services.ProjectService
+}
+
+// NewProjectHandler 创建项目管理处理器
+func NewProjectHandler(projectService *services.ProjectService) *ProjectHandler {
+	return &ProjectHandler{
+		ProjectService: projectService,
+	}
+}
+
+// createProjectRequest 创建项目请求
+type createProjectRequest struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	MonthlyEventQuota int64  `json:"monthly_event_quota"`
+}
+
+// HandleCreateProject 创建项目并返回写入密钥
+func (ph *ProjectHandler) HandleCreateProject(w http.ResponseWriter, r *http.Request) {
+	var req createProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !projectIDPattern.MatchString(req.ID) || req.Name == "" || req.MonthlyEventQuota < 0 {
+		http.Error(w, "项目ID格式错误、名称为空或配额为负数", http.StatusBadRequest)
+		return
+	}
+
+	project, err := ph.ProjectService.CreateProject(req.ID, req.Name, req.MonthlyEventQuota)
+	if err != nil {
+		log.Printf("创建项目失败: %v", err)
+		http.Error(w, "Failed to create project", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(project)
+}
+
+// HandleGetProject 查询项目信息
+func (ph *ProjectHandler) HandleGetProject(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["projectId"]
+
+	project, err := ph.ProjectService.GetByID(projectID)
+	if err != nil {
+		writeProjectError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(project)
+}
+
+// HandleRotateWriteKey 轮换项目写入密钥
+func (ph *ProjectHandler) HandleRotateWriteKey(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["projectId"]
+
+	project, err := ph.ProjectService.RotateWriteKey(projectID)
+	if err != nil {
+		writeProjectError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(project)
+}
+
+// HandleRotateSecretKey 轮换项目服务端密钥
+func (ph *ProjectHandler) HandleRotateSecretKey(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["projectId"]
+
+	project, err := ph.ProjectService.RotateSecretKey(projectID)
+	if err != nil {
+		writeProjectError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(project)
+}
+
+// HandleRotateReadKey 轮换项目读取密钥
+func (ph *ProjectHandler) HandleRotateReadKey(w http.ResponseWriter, r *http.Request) {
+	projectID := mux.Vars(r)["projectId"]
+
+	project, err := ph.ProjectService.RotateReadKey(projectID)
+	if err != nil {
+		writeProjectError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(project)
+}
+
+// writeProjectError 输出项目相关错误
+func writeProjectError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrProjectNotFound) {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("项目操作失败: %v", err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
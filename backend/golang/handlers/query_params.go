@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"insightflow/models"
+	"insightflow/services"
+)
+
+// userFilterPrefix 用户属性过滤的简写参数前缀（user.plan=pro 等同于 filter=user.plan = "pro"）
+const userFilterPrefix = "user."
+
+// queryUntil 查询的结束时间（未指定 to 时为当前时间）
+func queryUntil(q services.EventQuery) time.Time {
+	if q.Until.IsZero() {
+		return time.Now()
+	}
+	return q.Until
+}
+
+// parseEventQuery 解析明细统计的查询参数
+//   - from / to：时间范围（毫秒时间戳，默认最近 defaultWindow）
+//   - filter：过滤表达式，可重复，如 extra_data.plan = "pro"、element_class contains "cta"
+//   - user.<name>：用户属性等值过滤
+//   - group_by：分组字段，如 extra_data.category；group_limit：最多返回的分组数
+func parseEventQuery(r *http.Request, defaultWindow time.Duration) (services.EventQuery, error) {
+	query := r.URL.Query()
+	q := services.EventQuery{
+		ProjectID:  services.ProjectIDFromContext(r.Context()),
+		Since:      time.Now().Add(-defaultWindow),
+		GroupBy:    query.Get("group_by"),
+		GroupLimit: services.DefaultGroupLimit,
+	}
+
+	if from := query.Get("from"); from != "" {
+		ms, err := strconv.ParseInt(from, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("from 必须是毫秒时间戳")
+		}
+		q.Since = time.UnixMilli(ms)
+	}
+	if to := query.Get("to"); to != "" {
+		ms, err := strconv.ParseInt(to, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("to 必须是毫秒时间戳")
+		}
+		q.Until = time.UnixMilli(ms)
+	}
+	if !q.Since.Before(queryUntil(q)) {
+		return q, fmt.Errorf("from 必须早于 to")
+	}
+
+	if limit := query.Get("group_limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > services.MaxGroupLimit {
+			return q, fmt.Errorf("group_limit 取值范围为 1-%d", services.MaxGroupLimit)
+		}
+		q.GroupLimit = n
+	}
+
+	for _, expr := range query["filter"] {
+		filter, err := services.ParseEventFilter(expr)
+		if err != nil {
+			return q, err
+		}
+		q.Filters = append(q.Filters, filter)
+	}
+
+	// 用户属性简写参数按属性名排序，保证相同条件生成相同的SQL
+	var userKeys []string
+	for key := range query {
+		if strings.HasPrefix(key, userFilterPrefix) {
+			userKeys = append(userKeys, key)
+		}
+	}
+	sort.Strings(userKeys)
+	for _, key := range userKeys {
+		q.Filters = append(q.Filters, models.EventFilter{Field: key, Op: models.FilterOpEq, Value: query.Get(key)})
+	}
+
+	return q, q.Validate()
+}
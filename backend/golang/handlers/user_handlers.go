@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"insightflow/services"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// UserHandler 用户画像处理器
+type UserHandler struct {
+	ProfileService *services.UserProfileService
+	ServiceManager *services.ServiceManager
+	Redis          *redis.Client
+}
+
+// NewUserHandler 创建用户画像处理器
+func NewUserHandler(profileService *services.UserProfileService, serviceManager *services.ServiceManager, redis *redis.Client) *UserHandler {
+	return &UserHandler{
+		ProfileService: profileService,
+		ServiceManager: serviceManager,
+		Redis:          redis,
+	}
+}
+
+// HandleUpdateProperties 更新用户属性（请求体与 user_properties 事件的 extra_data 格式相同）
+// 只允许服务端签名调用；属性值与事件一样脱敏，已删除的用户在墓碑有效期内拒绝更新
+func (uh *UserHandler) HandleUpdateProperties(w http.ResponseWriter, r *http.Request) {
+	scrubber := uh.ServiceManager.GetPIIScrubber()
+	userID := scrubber.HashUserID(mux.Vars(r)["userId"])
+	projectID := services.ProjectIDFromContext(r.Context())
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if services.IsUserErased(r.Context(), uh.Redis, projectID, userID) {
+		writeUserError(w, services.ErrUserErased)
+		return
+	}
+
+	scrubber.RecordRedactions(r.Context(), projectID, scrubber.ScrubProperties(body))
+	update, err := services.ParsePropertyUpdate(body)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	properties, err := uh.ProfileService.UpdateProperties(r.Context(), projectID, userID, update)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"project_id": projectID,
+		"user_id":    userID,
+		"properties": properties,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleGetProfile 查询用户画像（访问统计及自定义属性）
+func (uh *UserHandler) HandleGetProfile(w http.ResponseWriter, r *http.Request) {
+	userID := uh.ServiceManager.GetPIIScrubber().HashUserID(mux.Vars(r)["userId"])
+	projectID := services.ProjectIDFromContext(r.Context())
+
+	user, err := uh.ProfileService.GetProfile(r.Context(), projectID, userID)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// writeUserError 输出用户画像相关错误
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrUserErased):
+		http.Error(w, "User has been erased", http.StatusGone)
+	case errors.Is(err, services.ErrInvalidPropertyUpdate), errors.Is(err, services.ErrInvalidIdentity):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("用户属性操作失败: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
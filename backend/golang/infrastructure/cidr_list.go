@@ -0,0 +1,29 @@
+package infrastructure
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// LoadCIDRList 读取本地IP网段列表文件（每行一个CIDR或单个IP，忽略空行和#开头的注释）
+func LoadCIDRList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cidrs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			cidrs = append(cidrs, line)
+		}
+	}
+	return cidrs, scanner.Err()
+}
@@ -0,0 +1,102 @@
+package infrastructure
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// consumerJob 分发给worker的单条消息
+type consumerJob struct {
+	session sarama.ConsumerGroupSession
+	message *sarama.ConsumerMessage
+	tracker *offsetTracker
+}
+
+// consumerPool 按消息key分片的固定大小工作池
+// 生产者按用户ID设置消息key，相同key的消息总由同一个worker按到达顺序处理，保证同一用户的事件顺序；
+// 每个worker的队列有界，队列满时由分发方暂停拉取（背压）
+type consumerPool struct {
+	queues []chan consumerJob
+	wg     sync.WaitGroup
+}
+
+// newConsumerPool 创建并启动工作池（workers 个worker，每个队列容量 queueSize）
+func newConsumerPool(workers, queueSize int, process func(consumerJob)) *consumerPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+
+	pool := &consumerPool{queues: make([]chan consumerJob, workers)}
+	for i := range pool.queues {
+		queue := make(chan consumerJob, queueSize)
+		pool.queues[i] = queue
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for job := range queue {
+				process(job)
+			}
+		}()
+	}
+	return pool
+}
+
+// queueFor 消息key对应的worker队列（没有key的消息按位移分散）
+func (p *consumerPool) queueFor(message *sarama.ConsumerMessage) chan<- consumerJob {
+	if len(message.Key) == 0 {
+		return p.queues[int(message.Offset%int64(len(p.queues)))]
+	}
+	hash := fnv.New32a()
+	hash.Write(message.Key)
+	return p.queues[hash.Sum32()%uint32(len(p.queues))]
+}
+
+// close 关闭所有队列并等待worker退出
+func (p *consumerPool) close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// offsetTracker 单个分区的位移跟踪
+// 同一分区的消息由多个worker并行处理、完成顺序不确定，只有某条消息之前的消息全部完成后才能提交它的位移
+type offsetTracker struct {
+	mu       sync.Mutex
+	pending  []int64        // 已分发、尚未连续完成的位移（递增）
+	done     map[int64]bool // 已完成但前面还有未完成消息的位移
+	inflight sync.WaitGroup
+}
+
+// newOffsetTracker 创建位移跟踪
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{done: make(map[int64]bool)}
+}
+
+// add 记录已分发的消息（分发前调用）
+func (t *offsetTracker) add(offset int64) {
+	t.mu.Lock()
+	t.pending = append(t.pending, offset)
+	t.mu.Unlock()
+	t.inflight.Add(1)
+}
+
+// complete 记录消息处理完成，返回可以提交的最大已完成位移（之前的消息都已完成）
+func (t *offsetTracker) complete(offset int64) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.done[offset] = true
+	committed, ok := int64(0), false
+	for len(t.pending) > 0 && t.done[t.pending[0]] {
+		committed, ok = t.pending[0], true
+		delete(t.done, t.pending[0])
+		t.pending = t.pending[1:]
+	}
+	return committed, ok
+}
@@ -0,0 +1,82 @@
+package infrastructure
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// TestOffsetTrackerOutOfOrder 乱序完成时，只有前面的消息全部完成后才推进位移
+func TestOffsetTrackerOutOfOrder(t *testing.T) {
+	tracker := newOffsetTracker()
+	for _, offset := range []int64{10, 11, 12, 13} {
+		tracker.add(offset)
+	}
+
+	steps := []struct {
+		complete  int64
+		committed int64
+		advanced  bool
+	}{
+		{complete: 12, advanced: false},
+		{complete: 11, advanced: false},
+		{complete: 10, committed: 12, advanced: true},
+		{complete: 13, committed: 13, advanced: true},
+	}
+	for _, step := range steps {
+		committed, advanced := tracker.complete(step.complete)
+		if advanced != step.advanced || (advanced && committed != step.committed) {
+			t.Fatalf("complete(%d) = (%d, %v), want (%d, %v)", step.complete, committed, advanced, step.committed, step.advanced)
+		}
+	}
+	if len(tracker.pending) != 0 || len(tracker.done) != 0 {
+		t.Fatalf("tracker not drained: pending=%v, done=%v", tracker.pending, tracker.done)
+	}
+}
+
+// TestOffsetTrackerGaps 位移不连续（压缩topic、事务标记）时按分发顺序推进
+func TestOffsetTrackerGaps(t *testing.T) {
+	tracker := newOffsetTracker()
+	for _, offset := range []int64{5, 9, 20} {
+		tracker.add(offset)
+	}
+
+	if _, advanced := tracker.complete(20); advanced {
+		t.Fatal("complete(20) advanced before 5 and 9 completed")
+	}
+	if committed, advanced := tracker.complete(5); !advanced || committed != 5 {
+		t.Fatalf("complete(5) = (%d, %v), want (5, true)", committed, advanced)
+	}
+	if committed, advanced := tracker.complete(9); !advanced || committed != 20 {
+		t.Fatalf("complete(9) = (%d, %v), want (20, true)", committed, advanced)
+	}
+}
+
+// TestConsumerPoolQueueForKey 相同key的消息总是分到同一个worker，没有key的消息按位移分散
+func TestConsumerPoolQueueForKey(t *testing.T) {
+	pool := newConsumerPool(8, 1, func(consumerJob) {})
+	defer pool.close()
+
+	used := make(map[chan<- consumerJob]bool)
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("default:user_%d", i))
+		queue := pool.queueFor(&sarama.ConsumerMessage{Key: key, Offset: int64(i)})
+		for offset := int64(1000); offset < 1010; offset++ {
+			if got := pool.queueFor(&sarama.ConsumerMessage{Key: key, Offset: offset}); got != queue {
+				t.Fatalf("key %s mapped to different workers", key)
+			}
+		}
+		used[queue] = true
+	}
+	if len(used) < 2 {
+		t.Fatalf("100 keys mapped to %d worker(s), want them spread across workers", len(used))
+	}
+
+	for offset := int64(0); offset < 8; offset++ {
+		want := pool.queues[offset]
+		if got := pool.queueFor(&sarama.ConsumerMessage{Offset: offset}); got != want {
+			t.Fatalf("message without key at offset %d not mapped to worker %d", offset, offset)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 死信消息头
+const (
+	DeadLetterHeaderError     = "x-error"            // 最后一次处理失败的原因
+	DeadLetterHeaderTopic     = "x-source-topic"     // 原始topic
+	DeadLetterHeaderPartition = "x-source-partition" // 原始分区
+	DeadLetterHeaderOffset    = "x-source-offset"    // 原始位移
+	DeadLetterHeaderAttempts  = "x-attempts"         // 处理次数
+	DeadLetterHeaderFailedAt  = "x-failed-at"        // 进入死信队列的时间（RFC3339）
+)
+
+// deadLetterParseError 无法解析的消息的失败原因前缀（重新投递也无法处理，默认不重新投递）
+const deadLetterParseError = "解析消息失败"
+
+// deadLetterRedriveGroup 记录重新投递进度的消费者组（只提交位移，不参与消费）
+const deadLetterRedriveGroup = "insightflow-dlq-redrive"
+
+// deadLetterReadTimeout 读取死信消息时等待单条消息的最长时间
+const deadLetterReadTimeout = 5 * time.Second
+
+// DeadLetterQueue 死信队列：保存解析失败或多次处理失败的消息，支持查看和重新投递到主topic
+// 重新投递的进度以位移形式提交到Kafka，已投递的消息不会重复投递
+type DeadLetterQueue struct {
+	client    sarama.Client
+	producer  sarama.SyncProducer
+	topic     string
+	mainTopic string
+
+	mu sync.Mutex // 查看和重新投递串行执行（同一分区的位移管理器只能有一个）
+}
+
+// DeadLetterMessage 死信消息
+type DeadLetterMessage struct {
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       string            `json:"key,omitempty"`
+	Value     interface{}       `json:"value"` // 合法JSON原样输出，否则为字符串
+	Headers   map[string]string `json:"headers"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// DeadLetterList 待重新投递的死信消息
+type DeadLetterList struct {
+	Topic    string              `json:"topic"`
+	Pending  int64               `json:"pending"` // 尚未重新投递的消息数
+	Messages []DeadLetterMessage `json:"messages"`
+}
+
+// NewDeadLetterQueue 创建死信队列（topic 为死信topic，mainTopic 为重新投递的目标topic）
+func NewDeadLetterQueue(brokers []string, topic, mainTopic string) (*DeadLetterQueue, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	config.Producer.Retry.Max = 3
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &DeadLetterQueue{
+		client:    client,
+		producer:  producer,
+		topic:     topic,
+		mainTopic: mainTopic,
+	}, nil
+}
+
+// Publish 发送处理失败的消息到死信topic（保留原始key、内容和消息头，附加失败原因和来源位置）
+// 重新投递后再次失败的消息，处理次数在上次的基础上累加，其余死信消息头以本次失败为准
+func (dlq *DeadLetterQueue) Publish(message *sarama.ConsumerMessage, cause error, attempts int) error {
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+6)
+	for _, header := range message.Headers {
+		if header == nil {
+			continue
+		}
+		switch string(header.Key) {
+		case DeadLetterHeaderAttempts:
+			if previous, err := strconv.Atoi(string(header.Value)); err == nil {
+				attempts += previous
+			}
+		case DeadLetterHeaderError, DeadLetterHeaderTopic, DeadLetterHeaderPartition, DeadLetterHeaderOffset, DeadLetterHeaderFailedAt:
+		default:
+			headers = append(headers, *header)
+		}
+	}
+	for name, value := range map[string]string{
+		DeadLetterHeaderError:     cause.Error(),
+		DeadLetterHeaderTopic:     message.Topic,
+		DeadLetterHeaderPartition: strconv.FormatInt(int64(message.Partition), 10),
+		DeadLetterHeaderOffset:    strconv.FormatInt(message.Offset, 10),
+		DeadLetterHeaderAttempts:  strconv.Itoa(attempts),
+		DeadLetterHeaderFailedAt:  time.Now().Format(time.RFC3339),
+	} {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(name), Value: []byte(value)})
+	}
+
+	_, _, err := dlq.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   dlq.topic,
+		Key:     byteEncoderOrNil(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	})
+	return err
+}
+
+// List 查看尚未重新投递的死信消息（按分区、位移顺序，最多 limit 条）
+func (dlq *DeadLetterQueue) List(limit int) (*DeadLetterList, error) {
+	list := &DeadLetterList{Topic: dlq.topic, Messages: make([]DeadLetterMessage, 0)}
+	err := dlq.forEachPending(limit, func(message *sarama.ConsumerMessage, _ sarama.PartitionOffsetManager) error {
+		list.Messages = append(list.Messages, toDeadLetterMessage(message))
+		return nil
+	}, &list.Pending)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Redrive 将尚未重新投递的死信消息（最多 limit 条）按原始key和消息头重新发送到主topic，返回投递和跳过的消息数
+// 无法解析的消息重新投递也无法处理，includeParseErrors 为 false 时跳过（推进位移，不再投递）；
+// 每条投递成功后才推进位移；中途失败时已投递的部分会提交，下次从失败的消息继续
+func (dlq *DeadLetterQueue) Redrive(limit int, includeParseErrors bool) (redriven, skipped int, err error) {
+	err = dlq.forEachPending(limit, func(message *sarama.ConsumerMessage, pom sarama.PartitionOffsetManager) error {
+		headers := make([]sarama.RecordHeader, 0, len(message.Headers))
+		parseError := false
+		for _, header := range message.Headers {
+			if header == nil {
+				continue
+			}
+			if string(header.Key) == DeadLetterHeaderError && strings.HasPrefix(string(header.Value), deadLetterParseError) {
+				parseError = true
+			}
+			headers = append(headers, *header)
+		}
+		if parseError && !includeParseErrors {
+			pom.MarkOffset(message.Offset+1, "")
+			skipped++
+			return nil
+		}
+
+		_, _, err := dlq.producer.SendMessage(&sarama.ProducerMessage{
+			Topic:   dlq.mainTopic,
+			Key:     byteEncoderOrNil(message.Key),
+			Value:   sarama.ByteEncoder(message.Value),
+			Headers: headers,
+		})
+		if err != nil {
+			return fmt.Errorf("重新投递死信消息失败: partition=%d, offset=%d, %w", message.Partition, message.Offset, err)
+		}
+		pom.MarkOffset(message.Offset+1, "")
+		redriven++
+		return nil
+	}, nil)
+	return redriven, skipped, err
+}
+
+// Close 关闭死信队列
+func (dlq *DeadLetterQueue) Close() error {
+	dlq.producer.Close()
+	return dlq.client.Close()
+}
+
+// forEachPending 依次处理各分区中尚未重新投递的消息（从重新投递进度到当前最新位移），
+// pending 不为空时累加各分区待投递的消息数
+func (dlq *DeadLetterQueue) forEachPending(limit int, fn func(*sarama.ConsumerMessage, sarama.PartitionOffsetManager) error, pending *int64) error {
+	dlq.mu.Lock()
+	defer dlq.mu.Unlock()
+
+	partitions, err := dlq.client.Partitions(dlq.topic)
+	if err != nil {
+		return fmt.Errorf("获取死信topic分区失败: %w", err)
+	}
+
+	offsetManager, err := sarama.NewOffsetManagerFromClient(deadLetterRedriveGroup, dlq.client)
+	if err != nil {
+		return fmt.Errorf("创建死信位移管理器失败: %w", err)
+	}
+	defer offsetManager.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(dlq.client)
+	if err != nil {
+		return fmt.Errorf("创建死信消费者失败: %w", err)
+	}
+	defer consumer.Close()
+
+	remaining := limit
+	for _, partition := range partitions {
+		pom, err := offsetManager.ManagePartition(dlq.topic, partition)
+		if err != nil {
+			return fmt.Errorf("获取死信分区 %d 位移失败: %w", partition, err)
+		}
+		err = dlq.forEachInPartition(consumer, pom, partition, &remaining, fn, pending)
+		pom.Close()
+		if err != nil {
+			offsetManager.Commit()
+			return err
+		}
+	}
+	offsetManager.Commit()
+	return nil
+}
+
+// forEachInPartition 处理单个分区中待投递的消息
+func (dlq *DeadLetterQueue) forEachInPartition(consumer sarama.Consumer, pom sarama.PartitionOffsetManager, partition int32,
+	remaining *int, fn func(*sarama.ConsumerMessage, sarama.PartitionOffsetManager) error, pending *int64) error {
+	oldest, err := dlq.client.GetOffset(dlq.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+	newest, err := dlq.client.GetOffset(dlq.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+
+	// 没有提交过进度，或消息已超过保留时间被删除时，从最早的消息开始
+	start, _ := pom.NextOffset()
+	if start < oldest {
+		start = oldest
+	}
+	if start >= newest {
+		return nil
+	}
+	if pending != nil {
+		*pending += newest - start
+	}
+	if *remaining <= 0 {
+		return nil
+	}
+
+	partitionConsumer, err := consumer.ConsumePartition(dlq.topic, partition, start)
+	if err != nil {
+		return err
+	}
+	defer partitionConsumer.Close()
+
+	for *remaining > 0 {
+		select {
+		case message := <-partitionConsumer.Messages():
+			if err := fn(message, pom); err != nil {
+				return err
+			}
+			*remaining--
+			if message.Offset >= newest-1 {
+				return nil
+			}
+		case err := <-partitionConsumer.Errors():
+			return err
+		case <-time.After(deadLetterReadTimeout):
+			return fmt.Errorf("读取死信分区 %d 超时", partition)
+		}
+	}
+	return nil
+}
+
+// toDeadLetterMessage 转换为可输出的死信消息
+func toDeadLetterMessage(message *sarama.ConsumerMessage) DeadLetterMessage {
+	headers := make(map[string]string, len(message.Headers))
+	for _, header := range message.Headers {
+		if header != nil {
+			headers[string(header.Key)] = string(header.Value)
+		}
+	}
+
+	var value interface{} = string(message.Value)
+	if json.Valid(message.Value) {
+		value = json.RawMessage(message.Value)
+	}
+
+	return DeadLetterMessage{
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       string(message.Key),
+		Value:     value,
+		Headers:   headers,
+		Timestamp: message.Timestamp,
+	}
+}
+
+// byteEncoderOrNil 空key不设置（按轮询分区）
+func byteEncoderOrNil(data []byte) sarama.Encoder {
+	if len(data) == 0 {
+		return nil
+	}
+	return sarama.ByteEncoder(data)
+}
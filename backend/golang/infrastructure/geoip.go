@@ -0,0 +1,50 @@
+package infrastructure
+
+import (
+	"net"
+
+	"insightflow/models"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoIPReader 本地MaxMind格式（GeoLite2/GeoIP2 City）数据库读取器
+type GeoIPReader struct {
+	reader *geoip2.Reader
+}
+
+// OpenGeoIP 打开本地GeoIP数据库文件
+func OpenGeoIP(path string) (*GeoIPReader, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIPReader{reader: reader}, nil
+}
+
+// Lookup 查询IP对应的国家、地区和城市
+func (g *GeoIPReader) Lookup(ip string) (models.GeoLocation, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return models.GeoLocation{}, false
+	}
+
+	record, err := g.reader.City(parsed)
+	if err != nil || record.Country.IsoCode == "" {
+		return models.GeoLocation{}, false
+	}
+
+	location := models.GeoLocation{
+		Country: record.Country.IsoCode,
+		City:    record.City.Names["en"],
+	}
+	if len(record.Subdivisions) > 0 {
+		location.Region = record.Subdivisions[0].Names["en"]
+	}
+	return location, true
+}
+
+// Close 关闭数据库文件
+func (g *GeoIPReader) Close() error {
+	return g.reader.Close()
+}
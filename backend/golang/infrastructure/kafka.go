@@ -1,8 +1,16 @@
 package infrastructure
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"insightflow/models"
 
@@ -15,8 +23,44 @@ import (
 // - Partition是物理概念，类似数据库表的"分片"
 // - 一个Topic可以包含多个Partition，用于并行处理和负载分布
 type KafkaProducer struct {
-	producer sarama.SyncProducer
-	topic    string
+	syncProducer  sarama.SyncProducer
+	asyncProducer sarama.AsyncProducer
+	topic         string
+	sendTimeout   time.Duration
+
+	mu      sync.RWMutex
+	closed  bool
+	closing chan struct{} // 关闭时关闭，唤醒阻塞在入队上的发送方
+	drainWG sync.WaitGroup
+	failing atomic.Bool // 最近一次发送有消息投递失败
+}
+
+// KafkaProducerOptions Kafka生产者选项
+type KafkaProducerOptions struct {
+	Async       bool          // 异步模式：使用AsyncProducer，并发请求的消息合并成批发送
+	Linger      time.Duration // 消息在本地攒批的最长等待时间
+	BatchSize   int           // 达到该消息数立即发送一批
+	Compression string        // 压缩算法：none, gzip, snappy, lz4, zstd
+	SendTimeout time.Duration // 等待一批消息投递结果的最长时间
+}
+
+// DeliveryReport 一批事件的投递结果
+type DeliveryReport struct {
+	Sent   int   // 投递成功的事件数
+	Failed []int // 投递失败的事件下标（对应传入的事件切片）
+	Err    error // 最后一个投递错误
+}
+
+// pendingDelivery 异步模式下挂在消息Metadata上的投递回调
+type pendingDelivery struct {
+	index int
+	done  chan<- deliveryResult
+}
+
+// deliveryResult 单条消息的投递结果
+type deliveryResult struct {
+	index int
+	err   error
 }
 
 // NewKafkaProducer 创建Kafka生产者
@@ -24,132 +68,551 @@ type KafkaProducer struct {
 // 分区说明：
 // - 如果topic只有1个分区，所有消息串行处理，保证全局顺序
 // - 如果topic有多个分区，相同key的消息会到同一分区，保证局部顺序
-func NewKafkaProducer(brokers []string, topic string) (*KafkaProducer, error) {
+func NewKafkaProducer(brokers []string, topic string, opts KafkaProducerOptions) (*KafkaProducer, error) {
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
 	config.Producer.Retry.Max = 3
 	config.Producer.RequiredAcks = sarama.WaitForAll
 
+	// 攒批参数：任一条件满足即发送一批
+	config.Producer.Flush.Frequency = opts.Linger
+	config.Producer.Flush.Messages = opts.BatchSize
+
+	codec, err := parseCompression(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.Compression = codec
+	if codec == sarama.CompressionZSTD {
+		// zstd 需要 Kafka 2.1 及以上的协议版本
+		config.Version = sarama.V2_1_0_0
+	}
+
 	// 分区策略：默认是Hash分区器
 	// - 有key时：相同key的消息总是发到同一分区（保证用户事件顺序）
 	// - 无key时：轮询发送到各个分区（负载均衡）
 
-	producer, err := sarama.NewSyncProducer(brokers, config)
+	kp := &KafkaProducer{
+		topic:       topic,
+		sendTimeout: opts.SendTimeout,
+		closing:     make(chan struct{}),
+	}
+	if kp.sendTimeout <= 0 {
+		kp.sendTimeout = 10 * time.Second
+	}
+
+	if !opts.Async {
+		kp.syncProducer, err = sarama.NewSyncProducer(brokers, config)
+		if err != nil {
+			return nil, err
+		}
+		return kp, nil
+	}
+
+	kp.asyncProducer, err = sarama.NewAsyncProducer(brokers, config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &KafkaProducer{
-		producer: producer,
-		topic:    topic,
-	}, nil
+	// 持续读取投递结果并回调给等待中的批次（不读取会阻塞生产者）
+	kp.drainWG.Add(2)
+	go func() {
+		defer kp.drainWG.Done()
+		for message := range kp.asyncProducer.Successes() {
+			notifyDelivery(message, nil)
+		}
+	}()
+	go func() {
+		defer kp.drainWG.Done()
+		for producerErr := range kp.asyncProducer.Errors() {
+			notifyDelivery(producerErr.Msg, producerErr.Err)
+		}
+	}()
+
+	return kp, nil
 }
 
-// SendEvent 发送事件到Kafka
-// 使用UserID作为分区key，确保同一用户的事件按顺序处理
+// SendEvent 发送单个事件到Kafka
 func (kp *KafkaProducer) SendEvent(event models.UserEvent) error {
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		return err
+	return kp.SendEvents([]models.UserEvent{event}).Err
+}
+
+// SendEvents 批量发送事件到Kafka，返回本批的投递结果
+// 使用 项目ID:UserID 作为分区key，确保同一项目下同一用户的事件按顺序处理
+func (kp *KafkaProducer) SendEvents(events []models.UserEvent) DeliveryReport {
+	var report DeliveryReport
+	if len(events) == 0 {
+		return report
 	}
 
-	message := &sarama.ProducerMessage{
-		Topic: kp.topic,
-		Key:   sarama.StringEncoder(event.UserID), // 分区key：同一用户到同一分区
-		Value: sarama.ByteEncoder(eventJSON),
+	messages := make([]*sarama.ProducerMessage, 0, len(events))
+	for i, event := range events {
+		eventJSON, err := json.Marshal(event)
+		if err != nil {
+			report.Failed = append(report.Failed, i)
+			report.Err = err
+			continue
+		}
+
+		// 分区key：同一用户到同一分区；匿名/丢弃模式的事件没有用户ID，按事件ID分散
+		partitionKey := event.UserID
+		if partitionKey == "" {
+			partitionKey = event.EventID
+		}
+
+		messages = append(messages, &sarama.ProducerMessage{
+			Topic:    kp.topic,
+			Key:      sarama.StringEncoder(event.ProjectID + ":" + partitionKey),
+			Value:    sarama.ByteEncoder(eventJSON),
+			Metadata: i,
+		})
 	}
 
-	partition, offset, err := kp.producer.SendMessage(message)
-	if err != nil {
-		return err
+	encodeFailed := len(report.Failed)
+	if kp.asyncProducer != nil {
+		kp.sendAsync(messages, &report)
+	} else {
+		kp.sendSync(messages, &report)
 	}
+	kp.failing.Store(len(report.Failed) > encodeFailed)
 
-	// 打印分区信息
-	log.Printf("事件发送成功: Topic=%s, Partition=%d, Offset=%d, UserID=%s",
-		kp.topic, partition, offset, event.UserID)
+	log.Printf("事件批次发送完成: Topic=%s, 成功=%d, 失败=%d", kp.topic, report.Sent, len(report.Failed))
+	return report
+}
 
-	return nil
+// Healthy 最近一次发送是否全部投递成功（失败后由下一次成功的发送或spool回放恢复；未创建生产者时为 false）
+func (kp *KafkaProducer) Healthy() bool {
+	return kp != nil && !kp.failing.Load()
+}
+
+// sendSync 同步模式：一次 SendMessages 调用发送整批消息
+func (kp *KafkaProducer) sendSync(messages []*sarama.ProducerMessage, report *DeliveryReport) {
+	err := kp.syncProducer.SendMessages(messages)
+	if err == nil {
+		report.Sent += len(messages)
+		return
+	}
+
+	producerErrs, ok := err.(sarama.ProducerErrors)
+	if !ok {
+		// 非单条消息错误（如生产者已关闭），整批视为失败
+		for _, message := range messages {
+			report.Failed = append(report.Failed, message.Metadata.(int))
+		}
+		report.Err = err
+		return
+	}
+
+	for _, producerErr := range producerErrs {
+		report.Failed = append(report.Failed, producerErr.Msg.Metadata.(int))
+		report.Err = producerErr.Err
+	}
+	report.Sent += len(messages) - len(producerErrs)
+}
+
+// sendAsync 异步模式：消息交给AsyncProducer攒批发送，等待本批所有消息的投递回调
+// 超时未确认的消息按失败处理；若之后实际投递成功，由事件ID去重避免重复统计
+func (kp *KafkaProducer) sendAsync(messages []*sarama.ProducerMessage, report *DeliveryReport) {
+	results := make(chan deliveryResult, len(messages))
+	pending := make(map[int]bool, len(messages))
+
+	// 超时从入队前开始计算：本地缓冲已满时入队也会阻塞，不能无限等待
+	timer := time.NewTimer(kp.sendTimeout)
+	defer timer.Stop()
+
+	kp.mu.RLock()
+	if kp.closed {
+		kp.mu.RUnlock()
+		for _, message := range messages {
+			report.Failed = append(report.Failed, message.Metadata.(int))
+		}
+		report.Err = sarama.ErrClosedClient
+		return
+	}
+	for i, message := range messages {
+		index := message.Metadata.(int)
+		message.Metadata = &pendingDelivery{index: index, done: results}
+		select {
+		case kp.asyncProducer.Input() <- message:
+			pending[index] = true
+			continue
+		case <-timer.C:
+			report.Err = fmt.Errorf("Kafka发送缓冲已满，等待入队超时（%s）", kp.sendTimeout)
+		case <-kp.closing:
+			report.Err = sarama.ErrClosedClient
+		}
+
+		// 未能入队的消息直接计为失败；已入队的消息仍等待投递结果（超时或关闭时同样计为失败）
+		kp.mu.RUnlock()
+		for _, rest := range messages[i:] {
+			if delivery, ok := rest.Metadata.(*pendingDelivery); ok {
+				report.Failed = append(report.Failed, delivery.index)
+			} else {
+				report.Failed = append(report.Failed, rest.Metadata.(int))
+			}
+		}
+		for index := range pending {
+			report.Failed = append(report.Failed, index)
+		}
+		sort.Ints(report.Failed)
+		return
+	}
+	kp.mu.RUnlock()
+
+	for len(pending) > 0 {
+		select {
+		case result := <-results:
+			delete(pending, result.index)
+			if result.err != nil {
+				report.Failed = append(report.Failed, result.index)
+				report.Err = result.err
+			} else {
+				report.Sent++
+			}
+		case <-timer.C:
+			for index := range pending {
+				report.Failed = append(report.Failed, index)
+			}
+			report.Err = fmt.Errorf("等待Kafka投递结果超时（%s），%d条消息未确认", kp.sendTimeout, len(pending))
+			sort.Ints(report.Failed)
+			return
+		}
+	}
+	sort.Ints(report.Failed)
+}
+
+// notifyDelivery 回调消息的投递结果（结果通道带缓冲，等待方超时后也不会阻塞）
+func notifyDelivery(message *sarama.ProducerMessage, err error) {
+	if message == nil {
+		return
+	}
+	if delivery, ok := message.Metadata.(*pendingDelivery); ok {
+		delivery.done <- deliveryResult{index: delivery.index, err: err}
+	}
 }
 
-// Close 关闭生产者
+// parseCompression 解析压缩算法配置
+func parseCompression(name string) (sarama.CompressionCodec, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return sarama.CompressionNone, nil
+	case "gzip":
+		return sarama.CompressionGZIP, nil
+	case "snappy":
+		return sarama.CompressionSnappy, nil
+	case "lz4":
+		return sarama.CompressionLZ4, nil
+	case "zstd":
+		return sarama.CompressionZSTD, nil
+	default:
+		return sarama.CompressionNone, fmt.Errorf("不支持的Kafka压缩算法: %s", name)
+	}
+}
+
+// Close 关闭生产者（异步模式下会先发送完缓冲中的消息）
 func (kp *KafkaProducer) Close() error {
-	return kp.producer.Close()
+	if kp.syncProducer != nil {
+		return kp.syncProducer.Close()
+	}
+
+	// 先唤醒阻塞在入队上的发送方，使其释放读锁，再标记关闭
+	close(kp.closing)
+	kp.mu.Lock()
+	kp.closed = true
+	kp.mu.Unlock()
+
+	err := kp.asyncProducer.Close()
+	kp.drainWG.Wait()
+	return err
 }
 
-// KafkaConsumer Kafka消费者包装
-// Consumer自动消费所有分区的消息
+// KafkaConsumer Kafka消费者组包装
+// 同一消费者组的多个副本分摊topic的分区，分区在副本增减时自动重新分配；
+// 事件处理完成（已持久化）后才标记位移，重启或重新分配后从已提交的位移继续消费（至少一次）；
+// 无法解析或多次处理失败的消息发送到死信队列后再标记；
+// 消息由按用户ID分片的固定大小工作池处理，worker队列满时暂停拉取对应分区
 type KafkaConsumer struct {
-	consumer sarama.Consumer
-	topic    string
+	group        sarama.ConsumerGroup
+	topic        string
+	retryBackoff time.Duration
+	maxAttempts  int
+	workers      int
+	queueSize    int
+	pool         *consumerPool
+
+	DeadLetter *DeadLetterQueue // 死信队列（为空则失败的消息一直重试，无法解析的消息记录日志后跳过）
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
-// NewKafkaConsumer 创建Kafka消费者
-func NewKafkaConsumer(brokers []string, topic string) (*KafkaConsumer, error) {
+// KafkaConsumerOptions Kafka消费者组选项
+type KafkaConsumerOptions struct {
+	GroupID           string        // 消费者组ID
+	InitialOffset     string        // 消费者组没有已提交位移时的起始位置：newest 或 oldest
+	RebalanceStrategy string        // 分区分配策略：range, roundrobin, sticky
+	RetryBackoff      time.Duration // 事件处理失败后的重试间隔（逐次翻倍，最长1分钟）
+	MaxAttempts       int           // 单条消息最多处理次数，超过后发送到死信队列
+	Workers           int           // 处理消息的worker数（同一用户的消息由同一个worker处理）
+	QueueSize         int           // 每个worker的队列容量，队列满时暂停拉取
+}
+
+// NewKafkaConsumer 创建Kafka消费者组
+func NewKafkaConsumer(brokers []string, topic string, opts KafkaConsumerOptions) (*KafkaConsumer, error) {
 	config := sarama.NewConfig()
 	config.Consumer.Return.Errors = true
-	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
 
-	consumer, err := sarama.NewConsumer(brokers, config)
+	strategy, err := parseBalanceStrategy(opts.RebalanceStrategy)
 	if err != nil {
 		return nil, err
 	}
+	config.Consumer.Group.Rebalance.Strategy = strategy
 
-	return &KafkaConsumer{
-		consumer: consumer,
-		topic:    topic,
-	}, nil
-}
+	switch opts.InitialOffset {
+	case "", "newest":
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	case "oldest":
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	default:
+		return nil, fmt.Errorf("不支持的起始位移: %s（可选 newest, oldest）", opts.InitialOffset)
+	}
 
-// Start 启动消费者
-// 自动检测分区数量并并行消费所有分区
-func (kc *KafkaConsumer) Start(eventHandler func(models.UserEvent)) error {
-	// 获取topic的所有分区
-	partitions, err := kc.consumer.Partitions(kc.topic)
+	group, err := sarama.NewConsumerGroup(brokers, opts.GroupID, config)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.Printf("🎯 Kafka消费者已启动: Topic=%s, 分区数量=%d", kc.topic, len(partitions))
+	kc := &KafkaConsumer{
+		group:        group,
+		topic:        topic,
+		retryBackoff: opts.RetryBackoff,
+		maxAttempts:  opts.MaxAttempts,
+		workers:      opts.Workers,
+		queueSize:    opts.QueueSize,
+		done:         make(chan struct{}),
+	}
+	if kc.retryBackoff <= 0 {
+		kc.retryBackoff = time.Second
+	}
+	if kc.maxAttempts <= 0 {
+		kc.maxAttempts = 1
+	}
+	kc.ctx, kc.cancel = context.WithCancel(context.Background())
+	return kc, nil
+}
 
-	// 为每个分区启动一个goroutine并行消费
-	for _, partition := range partitions {
-		go func(partitionID int32) {
-			partitionConsumer, err := kc.consumer.ConsumePartition(kc.topic, partitionID, sarama.OffsetNewest)
-			if err != nil {
-				log.Printf("启动分区 %d 消费者失败: %v", partitionID, err)
-				return
-			}
-			defer partitionConsumer.Close()
+// parseBalanceStrategy 解析分区分配策略名称
+func parseBalanceStrategy(name string) (sarama.BalanceStrategy, error) {
+	switch strings.ToLower(name) {
+	case "", "range":
+		return sarama.BalanceStrategyRange, nil
+	case "roundrobin":
+		return sarama.BalanceStrategyRoundRobin, nil
+	case "sticky":
+		return sarama.BalanceStrategySticky, nil
+	default:
+		return nil, fmt.Errorf("不支持的分区分配策略: %s", name)
+	}
+}
 
-			log.Printf("开始消费分区: %d", partitionID)
+// Start 启动消费者（阻塞直到 Close）
+// 每次分区重新分配后重新加入消费者组；eventHandler 返回错误时按退避间隔重试同一条消息，
+// 达到最多处理次数后发送到死信队列
+func (kc *KafkaConsumer) Start(eventHandler func(models.UserEvent) error) error {
+	defer close(kc.done)
 
-			// 处理该分区的消息
-			for {
-				select {
-				case message := <-partitionConsumer.Messages():
-					var event models.UserEvent
-					if err := json.Unmarshal(message.Value, &event); err != nil {
-						log.Printf("解析Kafka消息失败: %v", err)
-						continue
-					}
+	go func() {
+		for err := range kc.group.Errors() {
+			log.Printf("Kafka消费者组错误: %v", err)
+		}
+	}()
 
-					log.Printf("接收消息: Partition=%d, Offset=%d, UserID=%s",
-						message.Partition, message.Offset, event.UserID)
+	handler := &consumerGroupHandler{consumer: kc, eventHandler: eventHandler}
+	kc.pool = newConsumerPool(kc.workers, kc.queueSize, handler.process)
+	defer kc.pool.close()
 
-					// 处理事件
-					go eventHandler(event)
+	log.Printf("🎯 Kafka消费者已启动: Topic=%s, worker数量=%d", kc.topic, len(kc.pool.queues))
 
-				case err := <-partitionConsumer.Errors():
-					log.Printf("分区 %d 消费错误: %v", partitionID, err)
-				}
+	for {
+		if err := kc.group.Consume(kc.ctx, []string{kc.topic}, handler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return nil
 			}
-		}(partition)
+			log.Printf("Kafka消费失败，稍后重新加入消费者组: %v", err)
+			select {
+			case <-kc.ctx.Done():
+			case <-time.After(kc.retryBackoff):
+			}
+		}
+		if kc.ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// Close 停止消费并关闭消费者组（等待处理中的消息完成，提交已标记的位移）
+func (kc *KafkaConsumer) Close() error {
+	kc.cancel()
+	err := kc.group.Close()
+	select {
+	case <-kc.done:
+	case <-time.After(30 * time.Second):
+		log.Printf("等待Kafka消费者退出超时")
 	}
+	return err
+}
+
+// consumerGroupHandler 消费者组会话处理器（每个分配到的分区调用一次 ConsumeClaim）
+type consumerGroupHandler struct {
+	consumer     *KafkaConsumer
+	eventHandler func(models.UserEvent) error
+}
 
+// Setup 分区分配完成、开始消费之前调用
+func (h *consumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	log.Printf("Kafka消费者组分配分区: %v (generation=%d)", session.Claims(), session.GenerationID())
 	return nil
 }
 
-// Close 关闭消费者
-func (kc *KafkaConsumer) Close() error {
-	return kc.consumer.Close()
+// Cleanup 分区重新分配或停止消费时调用（之后 sarama 提交已标记的位移）
+func (h *consumerGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	log.Printf("Kafka消费者组释放分区: %v (generation=%d)", session.Claims(), session.GenerationID())
+	return nil
+}
+
+// ConsumeClaim 将一个分区的消息按key分发到工作池，消息及之前的消息都处理完成后才标记位移
+func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	log.Printf("开始消费分区: %d (起始位移=%d)", claim.Partition(), claim.InitialOffset())
+
+	// 退出前等待已分发的消息处理完成（会话结束后worker不再处理剩余消息），之后 sarama 提交已标记的位移
+	tracker := newOffsetTracker()
+	defer tracker.inflight.Wait()
+
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if !h.dispatch(session, tracker, message) {
+				return nil
+			}
+
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// dispatch 将消息放入对应worker的队列；队列已满时暂停拉取该分区，等待worker空出位置（会话结束时返回 false）
+func (h *consumerGroupHandler) dispatch(session sarama.ConsumerGroupSession, tracker *offsetTracker, message *sarama.ConsumerMessage) bool {
+	queue := h.consumer.pool.queueFor(message)
+	job := consumerJob{session: session, message: message, tracker: tracker}
+	tracker.add(message.Offset)
+
+	select {
+	case queue <- job:
+		return true
+	default:
+	}
+
+	partitions := map[string][]int32{message.Topic: {message.Partition}}
+	h.consumer.group.Pause(partitions)
+	defer h.consumer.group.Resume(partitions)
+
+	select {
+	case queue <- job:
+		return true
+	case <-session.Context().Done():
+		tracker.inflight.Done()
+		return false
+	}
+}
+
+// process worker处理单条消息，完成后推进所在分区的位移
+// 会话已结束（分区重新分配或停止）的消息不处理也不标记，由下一个持有该分区的消费者重新处理
+func (h *consumerGroupHandler) process(job consumerJob) {
+	defer job.tracker.inflight.Done()
+	if job.session.Context().Err() != nil {
+		return
+	}
+
+	message := job.message
+	var ok bool
+
+	// 无法解析的消息重试也不会成功，直接发送到死信队列
+	var event models.UserEvent
+	if err := json.Unmarshal(message.Value, &event); err != nil {
+		log.Printf("解析Kafka消息失败: Partition=%d, Offset=%d, %v", message.Partition, message.Offset, err)
+		ok = h.deadLetter(job.session, message, fmt.Errorf(deadLetterParseError+": %w", err), 1)
+	} else {
+		ok = h.handle(job.session, message, event)
+	}
+	if !ok {
+		return
+	}
+
+	if offset, advanced := job.tracker.complete(message.Offset); advanced {
+		job.session.MarkOffset(message.Topic, message.Partition, offset+1, "")
+	}
+}
+
+// handle 处理单条消息，失败时按退避间隔重试，达到最多处理次数后发送到死信队列；
+// 消息已处理或已进入死信队列时返回 true，会话结束时返回 false
+func (h *consumerGroupHandler) handle(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage, event models.UserEvent) bool {
+	backoff := h.consumer.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := h.eventHandler(event)
+		if err == nil {
+			return true
+		}
+		if h.consumer.DeadLetter != nil && attempt >= h.consumer.maxAttempts {
+			log.Printf("处理Kafka消息失败%d次，发送到死信队列: Partition=%d, Offset=%d, %v",
+				attempt, message.Partition, message.Offset, err)
+			return h.deadLetter(session, message, err, attempt)
+		}
+		log.Printf("处理Kafka消息失败，%v后重试: Partition=%d, Offset=%d, 第%d次, %v",
+			backoff, message.Partition, message.Offset, attempt, err)
+
+		if !sleepBackoff(session, &backoff) {
+			return false
+		}
+	}
+}
+
+// deadLetter 发送消息到死信队列，发送失败时按退避间隔重试（不丢弃消息）；
+// 未配置死信队列时记录日志后跳过；会话结束时返回 false
+func (h *consumerGroupHandler) deadLetter(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage, cause error, attempts int) bool {
+	if h.consumer.DeadLetter == nil {
+		log.Printf("未配置死信队列，跳过消息: Partition=%d, Offset=%d, %v", message.Partition, message.Offset, cause)
+		return true
+	}
+
+	backoff := h.consumer.retryBackoff
+	for {
+		err := h.consumer.DeadLetter.Publish(message, cause, attempts)
+		if err == nil {
+			return true
+		}
+		log.Printf("发送到死信队列失败，%v后重试: Partition=%d, Offset=%d, %v", backoff, message.Partition, message.Offset, err)
+
+		if !sleepBackoff(session, &backoff) {
+			return false
+		}
+	}
+}
+
+// sleepBackoff 等待退避间隔并将间隔翻倍（最长1分钟）；会话结束时返回 false
+func sleepBackoff(session sarama.ConsumerGroupSession, backoff *time.Duration) bool {
+	select {
+	case <-session.Context().Done():
+		return false
+	case <-time.After(*backoff):
+	}
+	if *backoff *= 2; *backoff > time.Minute {
+		*backoff = time.Minute
+	}
+	return true
 }
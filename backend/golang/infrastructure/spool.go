@@ -0,0 +1,472 @@
+package infrastructure
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"insightflow/models"
+)
+
+// Spool fsync 策略
+const (
+	SpoolFsyncAlways   = "always"   // 每次写入后立即落盘
+	SpoolFsyncInterval = "interval" // 按时间间隔落盘
+	SpoolFsyncNever    = "never"    // 交给操作系统
+)
+
+// spool 记录格式：4字节长度 + 4字节CRC32 + 事件JSON
+const (
+	spoolRecordHeaderSize = 8
+	spoolMaxRecordBytes   = 16 * 1024 * 1024
+	spoolSegmentSuffix    = ".seg"
+)
+
+// ErrSpoolFull spool 已达到容量上限
+var ErrSpoolFull = errors.New("本地spool已满")
+
+// SpoolOptions 本地spool选项
+type SpoolOptions struct {
+	Dir           string        // spool 目录
+	SegmentBytes  int64         // 单个段文件的最大字节数，超过后切换新段
+	MaxBytes      int64         // spool 总容量上限
+	FsyncPolicy   string        // fsync 策略：always, interval, never
+	FsyncInterval time.Duration // interval 策略下的落盘间隔
+}
+
+// spoolSegment spool 段文件
+type spoolSegment struct {
+	seq     int64
+	path    string
+	size    int64
+	records int64
+}
+
+// Spool Kafka不可用时的本地预写日志
+// 事件按写入顺序追加到段文件，Kafka恢复后从最旧的段开始按顺序回放，回放完的段文件删除
+type Spool struct {
+	opts SpoolOptions
+
+	mu         sync.Mutex
+	segments   []*spoolSegment // 按序号排列，最后一个可能是正在写入的段
+	active     *os.File        // 正在写入的段（属于 segments 的最后一个）
+	totalBytes int64
+	depth      int64
+	lastSync   time.Time
+
+	// 回放进度（只由回放协程访问）
+	replayMu    sync.Mutex
+	headOffset  int64 // 最旧段中已回放的字节数
+	headRecords int64 // 最旧段中已回放的记录数
+}
+
+// OpenSpool 打开（或创建）本地spool，并恢复目录中已有的段文件
+func OpenSpool(opts SpoolOptions) (*Spool, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 16 * 1024 * 1024
+	}
+	if opts.FsyncPolicy == "" {
+		opts.FsyncPolicy = SpoolFsyncInterval
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{opts: opts, lastSync: time.Now()}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, spoolSegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, spoolSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		segment, err := recoverSegment(seq, filepath.Join(opts.Dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if segment.records == 0 {
+			os.Remove(segment.path)
+			continue
+		}
+		s.segments = append(s.segments, segment)
+		s.totalBytes += segment.size
+		s.depth += segment.records
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	if s.depth > 0 {
+		log.Printf("📦 恢复本地spool: 段=%d, 待回放事件=%d", len(s.segments), s.depth)
+	}
+	return s, nil
+}
+
+// recoverSegment 扫描段文件，统计完整记录数并截断末尾不完整的记录（进程崩溃时可能写了一半）
+func recoverSegment(seq int64, path string) (*spoolSegment, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset, records int64
+	for {
+		_, n, err := readSpoolRecord(reader)
+		if err != nil {
+			break
+		}
+		offset += n
+		records++
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != offset {
+		log.Printf("spool段 %s 末尾有不完整记录，截断 %d 字节", path, info.Size()-offset)
+		if err := file.Truncate(offset); err != nil {
+			return nil, err
+		}
+	}
+
+	return &spoolSegment{seq: seq, path: path, size: offset, records: records}, nil
+}
+
+// Append 按顺序追加一批事件，整批写入或整批失败
+func (s *Spool) Append(events []models.UserEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var buf []byte
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		var header [spoolRecordHeaderSize]byte
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+		buf = append(buf, header[:]...)
+		buf = append(buf, payload...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opts.MaxBytes > 0 && s.totalBytes+int64(len(buf)) > s.opts.MaxBytes {
+		return ErrSpoolFull
+	}
+
+	if s.active == nil || s.segments[len(s.segments)-1].size >= s.opts.SegmentBytes {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	segment := s.segments[len(s.segments)-1]
+	n, err := s.active.Write(buf)
+	if err != nil {
+		// 写入失败时回退到写入前的位置，避免留下半条记录
+		s.active.Truncate(segment.size)
+		s.active.Seek(segment.size, io.SeekStart)
+		return err
+	}
+
+	segment.size += int64(n)
+	segment.records += int64(len(events))
+	s.totalBytes += int64(n)
+	s.depth += int64(len(events))
+
+	return s.syncLocked(false)
+}
+
+// Sync 将正在写入的段落盘
+func (s *Spool) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncLocked(true)
+}
+
+// Depth 待回放的事件数
+func (s *Spool) Depth() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.depth
+}
+
+// Stats 获取spool状态
+func (s *Spool) Stats() models.SpoolStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return models.SpoolStatus{Depth: s.depth, Bytes: s.totalBytes, Segments: len(s.segments)}
+}
+
+// Replay 从最旧的段开始按顺序回放事件，每批最多 batchSize 条
+// send 返回错误时停止回放，下次从失败的批次重新开始；崩溃重启后会从段开头重放，重复事件由事件ID去重
+func (s *Spool) Replay(batchSize int, send func([]models.UserEvent) error) (int, error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	replayed := 0
+	for {
+		segment, err := s.sealedHead()
+		if err != nil || segment == nil {
+			return replayed, err
+		}
+
+		done, n, err := s.replaySegment(segment, batchSize, send)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+		if !done {
+			return replayed, nil
+		}
+
+		// 段回放完毕，删除段文件
+		s.mu.Lock()
+		s.segments = s.segments[1:]
+		s.totalBytes -= segment.size
+		s.mu.Unlock()
+		s.headOffset = 0
+		s.headRecords = 0
+		if err := os.Remove(segment.path); err != nil {
+			log.Printf("删除spool段失败: %v", err)
+		}
+	}
+}
+
+// StartReplay 启动后台回放，ctx 取消时停止
+func (s *Spool) StartReplay(ctx context.Context, interval time.Duration, batchSize int, send func([]models.UserEvent) error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if s.opts.FsyncPolicy == SpoolFsyncInterval {
+					if err := s.Sync(); err != nil {
+						log.Printf("spool落盘失败: %v", err)
+					}
+				}
+				if s.Depth() == 0 {
+					continue
+				}
+				replayed, err := s.Replay(batchSize, send)
+				if replayed > 0 {
+					log.Printf("📦 spool回放事件: %d, 剩余: %d", replayed, s.Depth())
+				}
+				if err != nil {
+					log.Printf("spool回放暂停: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Close 关闭spool（落盘正在写入的段）
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Sync()
+	if closeErr := s.active.Close(); err == nil {
+		err = closeErr
+	}
+	s.active = nil
+	return err
+}
+
+// sealedHead 获取最旧的段；如果它正在写入，先切换新段，使其不再被追加
+func (s *Spool) sealedHead() (*spoolSegment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 {
+		return nil, nil
+	}
+	if len(s.segments) == 1 && s.active != nil {
+		if s.segments[0].records == 0 {
+			return nil, nil
+		}
+		if err := s.sealLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return s.segments[0], nil
+}
+
+// replaySegment 回放单个段，返回该段是否已全部回放
+func (s *Spool) replaySegment(segment *spoolSegment, batchSize int, send func([]models.UserEvent) error) (bool, int, error) {
+	file, err := os.Open(segment.path)
+	if err != nil {
+		return false, 0, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(s.headOffset, io.SeekStart); err != nil {
+		return false, 0, err
+	}
+	reader := bufio.NewReader(file)
+
+	replayed := 0
+	for {
+		batch := make([]models.UserEvent, 0, batchSize)
+		var batchBytes, batchRecords int64
+		var readErr error
+		for len(batch) < batchSize {
+			payload, n, err := readSpoolRecord(reader)
+			if err != nil {
+				readErr = err
+				break
+			}
+			batchBytes += n
+			batchRecords++
+
+			var event models.UserEvent
+			if err := json.Unmarshal(payload, &event); err != nil {
+				log.Printf("spool记录解析失败，跳过: %v", err)
+				continue
+			}
+			batch = append(batch, event)
+		}
+
+		if len(batch) > 0 {
+			if err := send(batch); err != nil {
+				return false, replayed, err
+			}
+			replayed += len(batch)
+		}
+
+		s.headOffset += batchBytes
+		s.headRecords += batchRecords
+		s.mu.Lock()
+		s.depth -= batchRecords
+		s.mu.Unlock()
+
+		if readErr != nil {
+			if readErr != io.EOF {
+				log.Printf("spool段 %s 存在损坏记录，丢弃剩余部分: %v", segment.path, readErr)
+				s.mu.Lock()
+				s.depth -= segment.records - s.headRecords
+				s.mu.Unlock()
+			}
+			return true, replayed, nil
+		}
+	}
+}
+
+// rotateLocked 关闭当前段并创建新段（调用方持有锁）
+func (s *Spool) rotateLocked() error {
+	if err := s.sealLocked(); err != nil {
+		return err
+	}
+
+	var seq int64 = 1
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+	path := filepath.Join(s.opts.Dir, fmt.Sprintf("%020d%s", seq, spoolSegmentSuffix))
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.active = file
+	s.segments = append(s.segments, &spoolSegment{seq: seq, path: path})
+	return nil
+}
+
+// sealLocked 落盘并关闭正在写入的段（调用方持有锁）
+func (s *Spool) sealLocked() error {
+	if s.active == nil {
+		return nil
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	err := s.active.Close()
+	s.active = nil
+	return err
+}
+
+// syncLocked 按fsync策略落盘（调用方持有锁）
+func (s *Spool) syncLocked(force bool) error {
+	if s.active == nil {
+		return nil
+	}
+
+	switch {
+	case s.opts.FsyncPolicy == SpoolFsyncAlways,
+		force && s.opts.FsyncPolicy != SpoolFsyncNever,
+		s.opts.FsyncPolicy == SpoolFsyncInterval && time.Since(s.lastSync) >= s.opts.FsyncInterval:
+		s.lastSync = time.Now()
+		return s.active.Sync()
+	}
+	return nil
+}
+
+// readSpoolRecord 读取一条记录，返回事件JSON和记录占用的字节数
+func readSpoolRecord(reader *bufio.Reader) ([]byte, int64, error) {
+	var header [spoolRecordHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, fmt.Errorf("记录头不完整: %w", err)
+		}
+		return nil, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+
+	if length > spoolMaxRecordBytes {
+		return nil, 0, fmt.Errorf("记录长度异常: %d", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, fmt.Errorf("记录内容不完整: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, 0, errors.New("记录校验失败")
+	}
+
+	return payload, int64(spoolRecordHeaderSize + len(payload)), nil
+}
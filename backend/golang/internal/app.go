@@ -1,7 +1,9 @@
 package internal
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
 
@@ -9,6 +11,7 @@ import (
 	"insightflow/handlers"
 	"insightflow/infrastructure"
 	"insightflow/middleware"
+	"insightflow/models"
 	"insightflow/services"
 
 	"github.com/go-redis/redis/v8"
@@ -17,19 +20,35 @@ import (
 
 // App 应用程序主结构
 type App struct {
-	Config         *config.Config
-	DB             *sql.DB
-	Redis          *redis.Client
-	KafkaProducer  *infrastructure.KafkaProducer
-	KafkaConsumer  *infrastructure.KafkaConsumer
-	EventProcessor *services.EventProcessor
-	ServiceManager *services.ServiceManager
-	EventHandler   *handlers.EventHandler
+	Config          *config.Config
+	DB              *sql.DB
+	Redis           *redis.Client
+	KafkaProducer   *infrastructure.KafkaProducer
+	KafkaConsumer   *infrastructure.KafkaConsumer
+	DeadLetter      *infrastructure.DeadLetterQueue
+	EventProcessor  *services.EventProcessor
+	ServiceManager  *services.ServiceManager
+	EventHandler    *handlers.EventHandler
+	ProjectHandler  *handlers.ProjectHandler
+	IdentityHandler *handlers.IdentityHandler
+	UserHandler     *handlers.UserHandler
+	PrivacyHandler  *handlers.PrivacyHandler
+	DLQHandler      *handlers.DeadLetterHandler
+	ProjectService  *services.ProjectService
+	PrivacyService  *services.PrivacyService
+	SchemaRegistry  *services.SchemaRegistry
+	GeoIP           *infrastructure.GeoIPReader
+	Spool           *infrastructure.Spool
+
+	// 后台任务生命周期控制
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewApp 初始化应用
 func NewApp(cfg *config.Config) (*App, error) {
 	app := &App{Config: cfg}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
 
 	// 初始化MySQL
 	db, err := infrastructure.InitMySQL(cfg.MySQLDSN)
@@ -46,31 +65,166 @@ func NewApp(cfg *config.Config) (*App, error) {
 	app.Redis = rdb
 
 	// 初始化Kafka Producer
-	kafkaProducer, err := infrastructure.NewKafkaProducer(cfg.KafkaBrokers, cfg.GetMainTopic())
+	kafkaProducer, err := infrastructure.NewKafkaProducer(cfg.KafkaBrokers, cfg.GetMainTopic(), infrastructure.KafkaProducerOptions{
+		Async:       cfg.KafkaProducer.Mode != "sync",
+		Linger:      cfg.KafkaProducer.Linger,
+		BatchSize:   cfg.KafkaProducer.BatchSize,
+		Compression: cfg.KafkaProducer.Compression,
+		SendTimeout: cfg.KafkaProducer.SendTimeout,
+	})
 	if err != nil {
 		return nil, err
 	}
 	app.KafkaProducer = kafkaProducer
 
-	// 初始化Kafka Consumer
-	kafkaConsumer, err := infrastructure.NewKafkaConsumer(cfg.KafkaBrokers, cfg.GetMainTopic())
+	// 初始化Kafka Consumer（消费者组，多个副本分摊分区，从已提交的位移继续消费）
+	kafkaConsumer, err := infrastructure.NewKafkaConsumer(cfg.KafkaBrokers, cfg.GetMainTopic(), infrastructure.KafkaConsumerOptions{
+		GroupID:           cfg.KafkaConsumer.GroupID,
+		InitialOffset:     cfg.KafkaConsumer.InitialOffset,
+		RebalanceStrategy: cfg.KafkaConsumer.RebalanceStrategy,
+		RetryBackoff:      cfg.KafkaConsumer.RetryBackoff,
+		MaxAttempts:       cfg.KafkaConsumer.MaxAttempts,
+		Workers:           cfg.KafkaConsumer.Workers,
+		QueueSize:         cfg.KafkaConsumer.QueueSize,
+	})
 	if err != nil {
 		return nil, err
 	}
 	app.KafkaConsumer = kafkaConsumer
 
+	// 初始化死信队列（可选，无法解析或多次处理失败的消息不再丢失）
+	if cfg.KafkaTopics.DeadLetter != "" {
+		deadLetter, err := infrastructure.NewDeadLetterQueue(cfg.KafkaBrokers, cfg.KafkaTopics.DeadLetter, cfg.GetMainTopic())
+		if err != nil {
+			return nil, err
+		}
+		app.DeadLetter = deadLetter
+		app.KafkaConsumer.DeadLetter = deadLetter
+	}
+
 	// 初始化服务管理器
 	app.ServiceManager = services.NewServiceManagerWithRedis(app.Redis)
 
+	// 初始化事件Schema注册表（加载失败时使用内置事件类型）
+	app.SchemaRegistry = services.NewSchemaRegistry(app.DB, cfg.Schema.FilePath)
+	if err := app.SchemaRegistry.Reload(); err != nil {
+		log.Printf("加载事件Schema失败，使用内置事件类型: %v", err)
+	}
+	app.ServiceManager.SetSchemaRegistry(app.SchemaRegistry)
+	app.SchemaRegistry.StartAutoReload(app.ctx, cfg.Schema.ReloadInterval)
+
+	// 初始化GeoIP（可选，打开失败时不解析地理位置）
+	if cfg.Ingestion.GeoIPDatabasePath != "" {
+		geoIP, err := infrastructure.OpenGeoIP(cfg.Ingestion.GeoIPDatabasePath)
+		if err != nil {
+			log.Printf("打开GeoIP数据库失败，跳过地理位置解析: %v", err)
+		} else {
+			app.GeoIP = geoIP
+			app.ServiceManager.SetGeoLocator(geoIP)
+		}
+	}
+
+	// 初始化个人敏感信息脱敏（规则无效时启动失败，避免敏感数据未脱敏就进入Kafka）
+	scrubber, err := newPIIScrubber(cfg, app.Redis)
+	if err != nil {
+		return nil, err
+	}
+	app.ServiceManager.SetPIIScrubber(scrubber)
+
+	for name, mode := range map[string]string{
+		"CONSENT_DEFAULT_MODE": cfg.Privacy.ConsentDefaultMode,
+		"CONSENT_OPT_OUT_MODE": cfg.Privacy.ConsentOptOutMode,
+	} {
+		if !services.IsValidTrackingMode(mode) {
+			return nil, fmt.Errorf("%s 无效: %q（可选 full, anonymous, drop）", name, mode)
+		}
+	}
+
+	// 初始化机器人识别（接收时按请求特征判定，处理时按行为特征判定）
+	bots := newBotDetector(cfg, app.Redis)
+	app.ServiceManager.SetBotDetector(bots)
+
 	// 初始化事件处理器
 	app.EventProcessor = services.NewEventProcessor(app.DB, app.Redis)
+	app.EventProcessor.DedupWindow = cfg.Ingestion.DedupWindow
+	app.EventProcessor.Bots = bots
+
+	// 初始化项目服务
+	app.ProjectService = services.NewProjectService(app.DB)
+
+	// 初始化用户数据删除与导出服务（后台异步处理任务）
+	app.PrivacyService = services.NewPrivacyService(app.DB, app.Redis, app.EventProcessor.Identity, app.EventProcessor.Profiles, app.ServiceManager)
+	app.PrivacyService.ExportDir = cfg.Privacy.ExportDir
+	app.PrivacyService.ExportTTL = cfg.Privacy.ExportTTL
+	app.PrivacyService.ErasureTombstone = cfg.Privacy.ErasureTombstone
+	app.PrivacyService.Start(app.ctx, cfg.Privacy.JobPollInterval)
 
 	// 初始化HTTP处理器
-	app.EventHandler = handlers.NewEventHandler(app.KafkaProducer, app.EventProcessor, app.Redis, app.ServiceManager)
+	app.EventHandler = handlers.NewEventHandler(cfg, app.KafkaProducer, app.EventProcessor, app.Redis, app.ServiceManager)
+	app.ProjectHandler = handlers.NewProjectHandler(app.ProjectService)
+	app.IdentityHandler = handlers.NewIdentityHandler(app.EventProcessor.Identity, app.Redis, app.ServiceManager)
+	app.UserHandler = handlers.NewUserHandler(app.EventProcessor.Profiles, app.ServiceManager, app.Redis)
+	app.PrivacyHandler = handlers.NewPrivacyHandler(app.PrivacyService, app.ServiceManager, app.EventHandler.ClientIPResolver)
+	app.DLQHandler = handlers.NewDeadLetterHandler(app.DeadLetter)
+
+	// 初始化本地spool（可选，Kafka不可用时暂存事件，恢复后按顺序回放）
+	if cfg.Spool.Dir != "" {
+		spool, err := infrastructure.OpenSpool(infrastructure.SpoolOptions{
+			Dir:           cfg.Spool.Dir,
+			SegmentBytes:  cfg.Spool.SegmentBytes,
+			MaxBytes:      cfg.Spool.MaxBytes,
+			FsyncPolicy:   cfg.Spool.FsyncPolicy,
+			FsyncInterval: cfg.Spool.FsyncInterval,
+		})
+		if err != nil {
+			return nil, err
+		}
+		app.Spool = spool
+		app.EventHandler.Spool = spool
+		spool.StartReplay(app.ctx, cfg.Spool.ReplayInterval, cfg.Spool.ReplayBatchSize, app.replaySpooledEvents)
+	}
 
 	return app, nil
 }
 
+// newPIIScrubber 按配置创建脱敏器（未启用时返回nil）
+func newPIIScrubber(cfg *config.Config, rdb *redis.Client) (*services.PIIScrubber, error) {
+	if !cfg.Privacy.ScrubEnabled {
+		return nil, nil
+	}
+	return services.NewPIIScrubber(services.ScrubRules{
+		Patterns:           cfg.Privacy.RedactPatterns,
+		QueryParamDenyList: cfg.Privacy.QueryParamDenyList,
+		TruncateIP:         cfg.Privacy.TruncateIP,
+		HashFields:         cfg.Privacy.HashFields,
+		HashSalt:           cfg.Privacy.HashSalt,
+	}, rdb)
+}
+
+// newBotDetector 按配置创建机器人识别器（未启用时返回nil；数据中心网段文件读取失败时只按其他规则判定）
+func newBotDetector(cfg *config.Config, rdb *redis.Client) *services.BotDetector {
+	if !cfg.Bot.Enabled {
+		return nil
+	}
+
+	var datacenterCIDRs []string
+	if cfg.Bot.DatacenterCIDRFile != "" {
+		cidrs, err := infrastructure.LoadCIDRList(cfg.Bot.DatacenterCIDRFile)
+		if err != nil {
+			log.Printf("读取数据中心网段列表失败，跳过按IP判定: %v", err)
+		}
+		datacenterCIDRs = cidrs
+	}
+
+	return services.NewBotDetector(services.BotRules{
+		UserAgentSignatures:  cfg.Bot.UserAgentSignatures,
+		DatacenterCIDRs:      datacenterCIDRs,
+		MaxEventsPerMinute:   cfg.Bot.MaxEventsPerMinute,
+		PassiveViewThreshold: cfg.Bot.PassiveViewThreshold,
+		FlagTTL:              cfg.Bot.FlagTTL,
+	}, rdb)
+}
+
 // SetupRoutes 设置路由
 func (app *App) SetupRoutes() http.Handler {
 	router := mux.NewRouter()
@@ -87,19 +241,68 @@ func (app *App) SetupRoutes() http.Handler {
 	// API路由
 	api := router.PathPrefix("/api").Subrouter()
 
-	// 事件接收接口
-	api.HandleFunc("/events", app.EventHandler.HandleEvents).Methods("POST", "OPTIONS")
+	// 事件接收接口（写入密钥确定项目）
+	ingest := api.NewRoute().Subrouter()
+	ingest.Use(middleware.ProjectWriteKey(app.ProjectService, app.Config.Ingestion.RequireWriteKey))
+	ingest.HandleFunc("/events", app.EventHandler.HandleEvents).Methods("POST", "OPTIONS")
+	ingest.HandleFunc("/collect", app.EventHandler.HandleCollect).Methods("GET")
+
+	// 服务端上报接口（项目服务端密钥签名）
+	track := api.NewRoute().Subrouter()
+	track.Use(middleware.ProjectSignature(app.ProjectService, app.Config.Ingestion.MaxPayloadBytes, app.Config.Ingestion.SignatureTolerance))
+	track.HandleFunc("/track", app.EventHandler.HandleTrack).Methods("POST")
+	// 关联、合并用户ID不可撤销，只允许服务端签名调用（写入密钥随页面公开，浏览器通过 identify 事件关联匿名ID）
+	track.HandleFunc("/identify", app.IdentityHandler.HandleIdentify).Methods("POST")
+	track.HandleFunc("/alias", app.IdentityHandler.HandleAlias).Methods("POST")
+	// 直接修改用户属性同样只允许服务端调用（浏览器通过 user_properties 事件设置属性）
+	track.HandleFunc("/user/{userId}/properties", app.UserHandler.HandleUpdateProperties).Methods("POST")
+
+	// 查询接口（按 X-Project-ID / project_id 限定项目范围）
+	query := api.NewRoute().Subrouter()
+	query.Use(middleware.ProjectScope(app.ProjectService, app.Config.AdminToken))
 
 	// 统计查询接口（供BFF调用）
-	api.HandleFunc("/stats/online", app.EventHandler.HandleOnlineUsers).Methods("GET")
-	api.HandleFunc("/stats/hot-pages", app.EventHandler.HandleHotPages).Methods("GET")
-	api.HandleFunc("/stats/events", app.EventHandler.HandleEventStats).Methods("GET")
-	api.HandleFunc("/stats/conversion", app.EventHandler.HandleConversionRate).Methods("GET")
-	api.HandleFunc("/stats/dashboard", app.EventHandler.HandleDashboard).Methods("GET")
+	query.HandleFunc("/stats/online", app.EventHandler.HandleOnlineUsers).Methods("GET")
+	query.HandleFunc("/stats/hot-pages", app.EventHandler.HandleHotPages).Methods("GET")
+	query.HandleFunc("/stats/events", app.EventHandler.HandleEventStats).Methods("GET")
+	query.HandleFunc("/stats/conversion", app.EventHandler.HandleConversionRate).Methods("GET")
+	query.HandleFunc("/stats/dashboard", app.EventHandler.HandleDashboard).Methods("GET")
+	query.HandleFunc("/stats/breakdown", app.EventHandler.HandleBreakdown).Methods("GET")
+	query.HandleFunc("/stats/timeseries", app.EventHandler.HandleTimeSeries).Methods("GET")
+	query.HandleFunc("/stats/ingestion", app.EventHandler.HandleIngestionStats).Methods("GET")
+	query.HandleFunc("/stats/consent", app.EventHandler.HandleConsentStats).Methods("GET")
+	query.HandleFunc("/stats/bots", app.EventHandler.HandleBotStats).Methods("GET")
 
 	// 用户行为查询
-	api.HandleFunc("/user/{userId}/events", app.EventHandler.HandleUserEvents).Methods("GET")
-	api.HandleFunc("/funnel/{funnelId}/analysis", app.EventHandler.HandleFunnelAnalysis).Methods("GET")
+	query.HandleFunc("/user/{userId}/events", app.EventHandler.HandleUserEvents).Methods("GET")
+	query.HandleFunc("/user/{userId}/identity", app.IdentityHandler.HandleGetIdentity).Methods("GET")
+	query.HandleFunc("/user/{userId}/profile", app.UserHandler.HandleGetProfile).Methods("GET")
+	query.HandleFunc("/funnel/{funnelId}/analysis", app.EventHandler.HandleFunnelAnalysis).Methods("GET")
+
+	// 项目管理接口（管理令牌鉴权）
+	admin := api.NewRoute().Subrouter()
+	admin.Use(middleware.AdminAuth(app.Config.AdminToken))
+	admin.HandleFunc("/projects", app.ProjectHandler.HandleCreateProject).Methods("POST")
+	admin.HandleFunc("/projects/{projectId}", app.ProjectHandler.HandleGetProject).Methods("GET")
+	admin.HandleFunc("/projects/{projectId}/rotate-key", app.ProjectHandler.HandleRotateWriteKey).Methods("POST")
+	admin.HandleFunc("/projects/{projectId}/rotate-secret", app.ProjectHandler.HandleRotateSecretKey).Methods("POST")
+	admin.HandleFunc("/projects/{projectId}/rotate-read-key", app.ProjectHandler.HandleRotateReadKey).Methods("POST")
+
+	// 死信队列查看与重新投递（管理令牌鉴权）
+	admin.HandleFunc("/dlq", app.DLQHandler.HandleListDeadLetters).Methods("GET")
+	admin.HandleFunc("/dlq/redrive", app.DLQHandler.HandleRedriveDeadLetters).Methods("POST")
+
+	// 用户数据删除与导出（管理令牌鉴权，按 X-Project-ID / project_id 限定项目范围）
+	privacy := api.NewRoute().Subrouter()
+	privacy.Use(middleware.AdminAuth(app.Config.AdminToken), middleware.ProjectScope(app.ProjectService, app.Config.AdminToken))
+	privacy.HandleFunc("/user/{userId}", app.PrivacyHandler.HandleEraseUser).Methods("DELETE")
+	privacy.HandleFunc("/user/{userId}/export", app.PrivacyHandler.HandleExportUser).Methods("GET")
+	privacy.HandleFunc("/privacy/jobs/{jobId}", app.PrivacyHandler.HandleGetJob).Methods("GET")
+	privacy.HandleFunc("/privacy/jobs/{jobId}/download", app.PrivacyHandler.HandleDownloadExport).Methods("GET")
+
+	// 事件Schema注册表（重新加载会替换全局校验规则，需管理令牌）
+	api.HandleFunc("/schemas", app.EventHandler.HandleListSchemas).Methods("GET")
+	admin.HandleFunc("/schemas/reload", app.EventHandler.HandleReloadSchemas).Methods("POST")
 
 	// 健康检查
 	router.HandleFunc("/health", app.EventHandler.HandleHealth).Methods("GET")
@@ -113,23 +316,45 @@ func (app *App) StartKafkaConsumer() {
 	go func() {
 		err := app.KafkaConsumer.Start(app.EventProcessor.ProcessEvent)
 		if err != nil {
-			log.Printf("Kafka消费者启动失败: %v", err)
+			log.Printf("Kafka消费者异常退出: %v", err)
 		}
 	}()
 }
 
+// replaySpooledEvents 将spool中的事件回放到Kafka，任一事件失败则整批稍后重试
+func (app *App) replaySpooledEvents(events []models.UserEvent) error {
+	report := app.KafkaProducer.SendEvents(events)
+	if len(report.Failed) > 0 {
+		return report.Err
+	}
+	return nil
+}
+
 // Close 关闭资源
 func (app *App) Close() {
+	// 先停止消费，等待处理中的事件写入数据库并提交位移
+	if app.KafkaConsumer != nil {
+		app.KafkaConsumer.Close()
+	}
+	if app.DeadLetter != nil {
+		app.DeadLetter.Close()
+	}
+	if app.cancel != nil {
+		app.cancel()
+	}
 	if app.DB != nil {
 		app.DB.Close()
 	}
 	if app.Redis != nil {
 		app.Redis.Close()
 	}
+	if app.Spool != nil {
+		app.Spool.Close()
+	}
 	if app.KafkaProducer != nil {
 		app.KafkaProducer.Close()
 	}
-	if app.KafkaConsumer != nil {
-		app.KafkaConsumer.Close()
+	if app.GeoIP != nil {
+		app.GeoIP.Close()
 	}
 }
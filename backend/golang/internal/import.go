@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"insightflow/config"
+	"insightflow/infrastructure"
+	"insightflow/models"
+	"insightflow/services"
+)
+
+// RunImport 执行历史数据导入子命令
+// 用法：insightflow import [-project id] [-format csv|ndjson] [-batch 1000] [-resume] [-state-dir dir] 文件...
+func RunImport(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	projectID := flags.String("project", models.DefaultProjectID, "事件归属的项目ID")
+	format := flags.String("format", "", "文件格式：csv 或 ndjson（默认按扩展名判断）")
+	batchSize := flags.Int("batch", 1000, "每批写入的事件数")
+	resume := flags.Bool("resume", false, "从上次中断的位置继续导入")
+	stateDir := flags.String("state-dir", "", "断点文件目录（默认与导入文件同目录）")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("缺少导入文件，用法: insightflow import [选项] 文件...")
+	}
+	if *batchSize <= 0 || *batchSize > services.MaxImportBatchSize {
+		return fmt.Errorf("-batch 取值范围为 1-%d", services.MaxImportBatchSize)
+	}
+
+	// 初始化MySQL和Redis
+	db, err := infrastructure.InitMySQL(cfg.MySQLDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rdb, err := infrastructure.InitRedis(cfg.RedisAddr)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	// 非默认项目必须已存在
+	if *projectID != models.DefaultProjectID {
+		if _, err := services.NewProjectService(db).GetByID(*projectID); err != nil {
+			return fmt.Errorf("项目 %s 不可用: %w", *projectID, err)
+		}
+	}
+
+	// 与在线接收使用同一套验证和增强逻辑
+	serviceManager := services.NewServiceManagerWithRedis(rdb)
+	schemaRegistry := services.NewSchemaRegistry(db, cfg.Schema.FilePath)
+	if err := schemaRegistry.Reload(); err != nil {
+		log.Printf("加载事件Schema失败，使用内置事件类型: %v", err)
+	}
+	serviceManager.SetSchemaRegistry(schemaRegistry)
+
+	if cfg.Ingestion.GeoIPDatabasePath != "" {
+		geoIP, err := infrastructure.OpenGeoIP(cfg.Ingestion.GeoIPDatabasePath)
+		if err != nil {
+			log.Printf("打开GeoIP数据库失败，跳过地理位置解析: %v", err)
+		} else {
+			defer geoIP.Close()
+			serviceManager.SetGeoLocator(geoIP)
+		}
+	}
+
+	scrubber, err := newPIIScrubber(cfg, rdb)
+	if err != nil {
+		return err
+	}
+	serviceManager.SetPIIScrubber(scrubber)
+	bots := newBotDetector(cfg, rdb)
+	serviceManager.SetBotDetector(bots)
+
+	// 事件处理器与在线处理相同的去重窗口和机器人识别
+	processor := services.NewEventProcessor(db, rdb)
+	processor.DedupWindow = cfg.Ingestion.DedupWindow
+	processor.Bots = bots
+
+	importer := services.NewEventImporter(processor, serviceManager)
+	importer.ConsentPolicy = services.ConsentPolicy{
+		DefaultMode: cfg.Privacy.ConsentDefaultMode,
+		OptOutMode:  cfg.Privacy.ConsentOptOutMode,
+	}
+
+	// 收到中断信号时在当前批次结束后停止，下次使用 -resume 继续
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	for _, path := range flags.Args() {
+		statePath := path + ".import-state.json"
+		if *stateDir != "" {
+			statePath = filepath.Join(*stateDir, filepath.Base(path)+".import-state.json")
+		}
+
+		log.Printf("📥 开始导入: %s (项目: %s)", path, *projectID)
+		result, err := importer.ImportFile(ctx, services.ImportOptions{
+			Path:      path,
+			Format:    *format,
+			ProjectID: *projectID,
+			BatchSize: *batchSize,
+			StatePath: statePath,
+			Resume:    *resume,
+		})
+		log.Printf("导入结果: %s, 读取=%d, 写入=%d, 重复=%d, 拒绝=%d, 未同意追踪=%d",
+			path, result.Read, result.Imported, result.Duplicates, result.Rejected, result.Withheld)
+		if err != nil {
+			return fmt.Errorf("导入 %s 失败（可使用 -resume 继续）: %w", path, err)
+		}
+	}
+
+	return nil
+}
@@ -17,6 +17,14 @@ func main() {
 	// 加载配置
 	cfg := config.Load()
 
+	// 子命令：历史数据导入
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := internal.RunImport(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("导入失败: %v", err)
+		}
+		return
+	}
+
 	// 初始化应用
 	app, err := internal.NewApp(cfg)
 	if err != nil {
@@ -11,7 +11,7 @@ func CORS() func(http.Handler) http.Handler {
 	return handlers.CORS(
 		handlers.AllowedOrigins([]string{"*"}),
 		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Content-Encoding", "Authorization", "X-Write-Key", "X-Project-ID", "X-Read-Key"}),
 	)
 }
 
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"insightflow/models"
+	"insightflow/services"
+)
+
+// ProjectWriteKey 事件上报鉴权中间件：根据写入密钥确定事件所属项目
+// 密钥来源（按优先级）：X-Write-Key 请求头、HTTP Basic用户名、write_key 查询参数（sendBeacon无法设置请求头）
+// requireWriteKey 为 false 时，未携带密钥的请求归属默认项目；携带了无效密钥的请求始终拒绝
+func ProjectWriteKey(projectService *services.ProjectService, requireWriteKey bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeKey := extractWriteKey(r)
+			if writeKey == "" {
+				if requireWriteKey {
+					http.Error(w, "Missing write key", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			project, err := projectService.GetByWriteKey(writeKey)
+			if err != nil {
+				log.Printf("写入密钥验证失败: %v", err)
+				http.Error(w, "Invalid write key", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(services.WithProject(r.Context(), project)))
+		})
+	}
+}
+
+// ProjectScope 查询接口的项目范围中间件：根据 X-Project-ID 请求头或 project_id 查询参数确定项目
+// 携带管理令牌（Authorization: Bearer <token>）时可查询任意项目，否则必须携带该项目的读取密钥（X-Read-Key）；
+// 管理令牌为空时不做校验，便于本地开发
+func ProjectScope(projectService *services.ProjectService, adminToken string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			projectID := r.Header.Get("X-Project-ID")
+			if projectID == "" {
+				projectID = r.URL.Query().Get("project_id")
+			}
+			if projectID == "" {
+				projectID = models.DefaultProjectID
+			}
+
+			isAdmin := adminToken == "" || validAdminToken(r, adminToken)
+			if projectID == models.DefaultProjectID && isAdmin {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			project, err := projectService.GetByID(projectID)
+			if err != nil && isAdmin {
+				http.Error(w, "Project not found", http.StatusNotFound)
+				return
+			}
+			// 未携带管理令牌时不区分项目是否存在，避免被用来探测项目ID
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !isAdmin {
+				readKey := r.Header.Get("X-Read-Key")
+				if project.ReadKey == "" || subtle.ConstantTimeCompare([]byte(readKey), []byte(project.ReadKey)) != 1 {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(services.WithProject(r.Context(), project)))
+		})
+	}
+}
+
+// ProjectSignature 服务端上报鉴权中间件：使用项目服务端密钥校验请求体的HMAC签名
+// 请求头：X-Project-ID 项目ID、X-Signature-Timestamp Unix秒级时间戳、
+// X-Signature sha256=hex(HMAC-SHA256(secret_key, 时间戳 + "." + 原始请求体))
+// 时间戳与服务器时间相差超过 tolerance 的请求拒绝，防止签名被重放
+func ProjectSignature(projectService *services.ProjectService, maxBodyBytes int64, tolerance time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			projectID := r.Header.Get("X-Project-ID")
+			timestamp := r.Header.Get("X-Signature-Timestamp")
+			signature := strings.TrimPrefix(r.Header.Get("X-Signature"), "sha256=")
+			if projectID == "" || timestamp == "" || signature == "" {
+				http.Error(w, "Missing signature headers", http.StatusUnauthorized)
+				return
+			}
+
+			signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil || time.Since(time.Unix(signedAt, 0)).Abs() > tolerance {
+				http.Error(w, "Signature timestamp out of range", http.StatusUnauthorized)
+				return
+			}
+
+			project, err := projectService.GetByID(projectID)
+			if err != nil || project.SecretKey == "" {
+				http.Error(w, "Invalid signature", http.StatusUnauthorized)
+				return
+			}
+
+			// 读取原始请求体（签名覆盖压缩前的原始字节），校验后放回供处理器解析
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+			if err != nil || int64(len(body)) > maxBodyBytes {
+				http.Error(w, "Invalid payload", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			mac := hmac.New(sha256.New, []byte(project.SecretKey))
+			mac.Write([]byte(timestamp + "."))
+			mac.Write(body)
+			expected := hex.EncodeToString(mac.Sum(nil))
+			if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+				log.Printf("服务端上报签名校验失败: 项目=%s", projectID)
+				http.Error(w, "Invalid signature", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(services.WithProject(r.Context(), project)))
+		})
+	}
+}
+
+// AdminAuth 管理接口鉴权中间件（Authorization: Bearer <token>）
+// token 为空时不做校验，便于本地开发
+func AdminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token != "" && !validAdminToken(r, token) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// validAdminToken 请求是否携带了正确的管理令牌
+func validAdminToken(r *http.Request, token string) bool {
+	provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// extractWriteKey 从请求中提取写入密钥
+func extractWriteKey(r *http.Request) string {
+	if key := r.Header.Get("X-Write-Key"); key != "" {
+		return key
+	}
+	if username, _, ok := r.BasicAuth(); ok && username != "" {
+		return username
+	}
+	return r.URL.Query().Get("write_key")
+}
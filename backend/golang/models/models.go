@@ -12,6 +12,48 @@ const (
 	EventTypeLoad             = "load"
 	EventTypeExit             = "exit"
 	EventTypeVisibilityChange = "visibility_change"
+	EventTypeIdentify         = "identify"        // 登录时关联匿名ID（extra_data.anonymous_id）
+	EventTypeUserProperties   = "user_properties" // 设置用户属性（extra_data 为属性操作）
+)
+
+// 事件属性类型常量（事件Schema中使用）
+const (
+	PropertyTypeString  = "string"
+	PropertyTypeNumber  = "number"
+	PropertyTypeBoolean = "boolean"
+	PropertyTypeObject  = "object"
+	PropertyTypeArray   = "array"
+)
+
+// DefaultProjectID 默认项目ID（未携带写入密钥的请求及历史数据归属此项目）
+const DefaultProjectID = "default"
+
+// 事件来源常量
+const (
+	EventSourceClient = "client" // 浏览器/客户端SDK上报
+	EventSourceServer = "server" // 服务端通过签名接口上报
+)
+
+// 访客同意状态常量（事件 consent 字段）
+const (
+	ConsentGranted   = "granted"   // 同意完整追踪
+	ConsentAnonymous = "anonymous" // 只允许匿名统计
+	ConsentDenied    = "denied"    // 拒绝追踪
+)
+
+// 事件处理模式常量（接收时根据同意状态和 DNT/Sec-GPC 请求头确定）
+const (
+	TrackingModeFull      = "full"      // 完整追踪：统计、持久化明细和用户信息
+	TrackingModeAnonymous = "anonymous" // 匿名模式：只更新Redis聚合计数，不持久化用户ID，不写 users
+	TrackingModeDrop      = "drop"      // 丢弃：只计入处理模式统计
+)
+
+// 机器人判定原因常量
+const (
+	BotReasonUserAgent     = "user_agent"     // User-Agent 命中爬虫/监控特征
+	BotReasonDatacenterIP  = "datacenter_ip"  // IP 属于数据中心网段
+	BotReasonEventRate     = "event_rate"     // 事件频率超出人工操作的可能
+	BotReasonNoInteraction = "no_interaction" // 大量浏览但没有任何滚动、可见性变化事件
 )
 
 // 设备类型常量
@@ -21,6 +63,39 @@ const (
 	DeviceTypeTablet  = "tablet"
 )
 
+// 事件拒绝错误码常量
+const (
+	RejectCodeMissingField     = "missing_field"      // 必填字段缺失
+	RejectCodeInvalidTimestamp = "invalid_timestamp"  // 时间戳非法
+	RejectCodeInvalidEventID   = "invalid_event_id"   // 事件ID非法
+	RejectCodeInvalidEventType = "invalid_event_type" // 不支持的事件类型
+	RejectCodeEventExpired     = "event_expired"      // 事件已过期
+	RejectCodeInvalidEvent     = "invalid_event"      // 其他验证错误
+	RejectCodeMissingProperty  = "missing_property"   // extra_data缺少必填属性
+	RejectCodeInvalidProperty  = "invalid_property"   // extra_data属性类型或取值非法
+	RejectCodeInvalidConsent   = "invalid_consent"    // 同意状态取值非法
+)
+
+// 事件接收状态常量
+const (
+	EventStatusSuccess        = "success"         // 全部接收
+	EventStatusPartialSuccess = "partial_success" // 部分接收
+	EventStatusRejected       = "rejected"        // 全部拒绝
+	EventStatusRateLimited    = "rate_limited"    // 触发限流或超出配额
+	EventStatusUnavailable    = "unavailable"     // Kafka和本地spool都不可用，需稍后重试
+)
+
+// 统计维度常量（按维度拆分统计）
+const (
+	DimensionDeviceType = "device_type"
+	DimensionBrowser    = "browser"
+	DimensionOS         = "os"
+	DimensionCountry    = "country"
+	DimensionRegion     = "region"
+	DimensionCity       = "city"
+	DimensionSource     = "source"
+)
+
 // 缓存过期时间常量
 const (
 	CacheExpireShort  = 5 * time.Minute // 5分钟
@@ -30,36 +105,105 @@ const (
 
 // UserEvent 用户事件结构
 type UserEvent struct {
-	ID           int64       `json:"id,omitempty" db:"id"`                     // 数据库主键
-	UserID       string      `json:"user_id" db:"user_id"`                     // 用户ID
-	SessionID    string      `json:"session_id" db:"session_id"`               // 会话ID
-	EventType    string      `json:"event_type" db:"event_type"`               // 事件类型
-	PageURL      string      `json:"page_url" db:"page_url"`                   // 页面URL
-	PageTitle    string      `json:"page_title,omitempty"`                     // 页面标题(应用层字段)
-	Element      string      `json:"element,omitempty" db:"element"`           // 元素标识
-	ElementID    string      `json:"element_id,omitempty"`                     // 元素ID(应用层字段)
-	ElementClass string      `json:"element_class,omitempty"`                  // 元素Class(应用层字段)
-	ElementText  string      `json:"element_text,omitempty" db:"element_text"` // 元素文本
-	PositionX    *int        `json:"position_x,omitempty" db:"position_x"`     // 点击X坐标
-	PositionY    *int        `json:"position_y,omitempty" db:"position_y"`     // 点击Y坐标
-	UserAgent    string      `json:"user_agent,omitempty" db:"user_agent"`     // 用户代理
-	IPAddress    string      `json:"ip_address,omitempty" db:"ip_address"`     // IP地址
-	Timestamp    int64       `json:"timestamp" db:"timestamp"`                 // 事件时间戳
-	CreatedAt    *string     `json:"created_at,omitempty" db:"created_at"`     // 创建时间
-	ExtraData    interface{} `json:"extra_data,omitempty"`                     // 扩展数据(应用层字段)
+	ID             int64       `json:"id,omitempty" db:"id"`                           // 数据库主键
+	ProjectID      string      `json:"project_id,omitempty" db:"project_id"`           // 项目ID(由写入密钥确定)
+	EventID        string      `json:"event_id,omitempty" db:"event_id"`               // 客户端事件ID(用于去重)
+	UserID         string      `json:"user_id" db:"user_id"`                           // 用户ID
+	SessionID      string      `json:"session_id" db:"session_id"`                     // 会话ID
+	EventType      string      `json:"event_type" db:"event_type"`                     // 事件类型
+	PageURL        string      `json:"page_url" db:"page_url"`                         // 页面URL
+	PageTitle      string      `json:"page_title,omitempty"`                           // 页面标题(应用层字段)
+	Element        string      `json:"element,omitempty" db:"element"`                 // 元素标识
+	ElementID      string      `json:"element_id,omitempty"`                           // 元素ID(应用层字段)
+	ElementClass   string      `json:"element_class,omitempty"`                        // 元素Class(应用层字段)
+	ElementText    string      `json:"element_text,omitempty" db:"element_text"`       // 元素文本
+	PositionX      *int        `json:"position_x,omitempty" db:"position_x"`           // 点击X坐标
+	PositionY      *int        `json:"position_y,omitempty" db:"position_y"`           // 点击Y坐标
+	UserAgent      string      `json:"user_agent,omitempty" db:"user_agent"`           // 用户代理
+	IPAddress      string      `json:"ip_address,omitempty" db:"ip_address"`           // IP地址
+	DeviceType     string      `json:"device_type,omitempty" db:"device_type"`         // 设备类型(由user_agent解析)
+	Browser        string      `json:"browser,omitempty" db:"browser"`                 // 浏览器(由user_agent解析)
+	BrowserVersion string      `json:"browser_version,omitempty" db:"browser_version"` // 浏览器版本(由user_agent解析)
+	OS             string      `json:"os,omitempty" db:"os"`                           // 操作系统(由user_agent解析)
+	OSVersion      string      `json:"os_version,omitempty" db:"os_version"`           // 操作系统版本(由user_agent解析)
+	Country        string      `json:"country,omitempty" db:"country"`                 // 国家ISO代码(由IP解析)
+	Region         string      `json:"region,omitempty" db:"region"`                   // 地区(由IP解析)
+	City           string      `json:"city,omitempty" db:"city"`                       // 城市(由IP解析)
+	Timestamp      int64       `json:"timestamp" db:"timestamp"`                       // 事件时间戳
+	Source         string      `json:"source,omitempty" db:"source"`                   // 事件来源：client, server
+	CreatedAt      *string     `json:"created_at,omitempty" db:"created_at"`           // 创建时间
+	ExtraData      interface{} `json:"extra_data,omitempty"`                           // 扩展数据(应用层字段)
+	IsLate         bool        `json:"is_late,omitempty"`                              // 迟到事件，走回填路径(应用层字段)
+	Consent        string      `json:"consent,omitempty"`                              // 访客同意状态：granted, anonymous, denied(应用层字段)
+	TrackingMode   string      `json:"tracking_mode,omitempty"`                        // 处理模式，由服务端确定(应用层字段)
+	IsBot          bool        `json:"is_bot,omitempty" db:"is_bot"`                   // 机器人流量，由服务端判定
+	BotReason      string      `json:"bot_reason,omitempty" db:"bot_reason"`           // 判定为机器人的原因
+}
+
+// GeoLocation IP地理位置
+type GeoLocation struct {
+	Country string `json:"country"` // 国家ISO代码
+	Region  string `json:"region"`  // 地区/省份
+	City    string `json:"city"`    // 城市
+}
+
+// Project 项目（租户）信息
+type Project struct {
+	ID                string `json:"id" db:"id"`                                   // 项目ID
+	Name              string `json:"name" db:"name"`                               // 项目名称
+	WriteKey          string `json:"write_key" db:"write_key"`                     // 写入密钥（用于事件上报）
+	SecretKey         string `json:"secret_key,omitempty" db:"secret_key"`         // 服务端密钥（用于签名上报，仅管理接口返回）
+	ReadKey           string `json:"read_key,omitempty" db:"read_key"`             // 读取密钥（用于查询接口，仅管理接口返回）
+	MonthlyEventQuota int64  `json:"monthly_event_quota" db:"monthly_event_quota"` // 每月事件配额（0表示不限制）
+	IsActive          bool   `json:"is_active" db:"is_active"`                     // 是否启用
+	CreatedAt         string `json:"created_at" db:"created_at"`                   // 创建时间
 }
 
 // User 用户信息结构
 type User struct {
-	UserID        string  `json:"user_id" db:"user_id"`                   // 用户ID
-	FirstVisit    string  `json:"first_visit" db:"first_visit"`           // 首次访问时间
-	LastVisit     string  `json:"last_visit" db:"last_visit"`             // 最后访问时间
-	TotalEvents   int     `json:"total_events" db:"total_events"`         // 总事件数
-	TotalSessions int     `json:"total_sessions" db:"total_sessions"`     // 总会话数
-	DeviceType    *string `json:"device_type,omitempty" db:"device_type"` // 设备类型
-	Browser       *string `json:"browser,omitempty" db:"browser"`         // 浏览器
-	CreatedAt     string  `json:"created_at" db:"created_at"`             // 创建时间
-	UpdatedAt     string  `json:"updated_at" db:"updated_at"`             // 更新时间
+	ProjectID     string                 `json:"project_id" db:"project_id"`             // 项目ID
+	UserID        string                 `json:"user_id" db:"user_id"`                   // 用户ID
+	FirstVisit    string                 `json:"first_visit" db:"first_visit"`           // 首次访问时间
+	LastVisit     string                 `json:"last_visit" db:"last_visit"`             // 最后访问时间
+	TotalEvents   int                    `json:"total_events" db:"total_events"`         // 总事件数
+	TotalSessions int                    `json:"total_sessions" db:"total_sessions"`     // 总会话数
+	DeviceType    *string                `json:"device_type,omitempty" db:"device_type"` // 设备类型
+	Browser       *string                `json:"browser,omitempty" db:"browser"`         // 浏览器
+	OS            *string                `json:"os,omitempty" db:"os"`                   // 操作系统
+	Properties    map[string]interface{} `json:"properties" db:"properties"`             // 用户自定义属性
+	CreatedAt     string                 `json:"created_at" db:"created_at"`             // 创建时间
+	UpdatedAt     string                 `json:"updated_at" db:"updated_at"`             // 更新时间
+}
+
+// UserPropertyUpdate 用户属性操作（user_properties 事件的 extra_data 或属性接口请求体）
+// 同一请求中按 $set、$set_once、$increment、$append、$unset 的顺序执行
+type UserPropertyUpdate struct {
+	Set       map[string]interface{} `json:"$set,omitempty"`       // 设置属性（覆盖）
+	SetOnce   map[string]interface{} `json:"$set_once,omitempty"`  // 仅在属性不存在时设置
+	Increment map[string]float64     `json:"$increment,omitempty"` // 数值累加（属性不存在时从0开始）
+	Append    map[string]interface{} `json:"$append,omitempty"`    // 追加到列表属性
+	Unset     []string               `json:"$unset,omitempty"`     // 删除属性
+}
+
+// 查询过滤操作符
+const (
+	FilterOpEq          = "="
+	FilterOpNeq         = "!="
+	FilterOpGt          = ">"
+	FilterOpGte         = ">="
+	FilterOpLt          = "<"
+	FilterOpLte         = "<="
+	FilterOpContains    = "contains"
+	FilterOpNotContains = "not_contains"
+	FilterOpExists      = "exists"
+)
+
+// EventFilter 事件查询过滤条件
+// 字段可以是事件列（如 element_class）、extra_data.<属性> 或 user.<用户属性>
+type EventFilter struct {
+	Field string      `json:"field"` // 过滤字段
+	Op    string      `json:"op"`    // 操作符
+	Value interface{} `json:"value"` // 比较值（字符串或数值，exists 时为空）
 }
 
 // AnalysisCache 分析结果缓存结构
@@ -73,6 +217,7 @@ type AnalysisCache struct {
 // FunnelConfig 漏斗配置结构
 type FunnelConfig struct {
 	ID         int         `json:"id" db:"id"`                   // 配置ID
+	ProjectID  string      `json:"project_id" db:"project_id"`   // 项目ID
 	FunnelName string      `json:"funnel_name" db:"funnel_name"` // 漏斗名称
 	Steps      interface{} `json:"steps" db:"steps"`             // 漏斗步骤配置(JSON)
 	IsActive   bool        `json:"is_active" db:"is_active"`     // 是否启用
@@ -80,11 +225,58 @@ type FunnelConfig struct {
 	UpdatedAt  string      `json:"updated_at" db:"updated_at"`   // 更新时间
 }
 
+// EventSchema 事件Schema定义（事件注册表条目）
+type EventSchema struct {
+	EventType   string           `json:"event_type" db:"event_type"`   // 事件类型
+	Description string           `json:"description" db:"description"` // 描述
+	Properties  []PropertySchema `json:"properties" db:"properties"`   // extra_data属性定义(JSON)
+	IsActive    bool             `json:"is_active" db:"is_active"`     // 是否启用
+}
+
+// PropertySchema 事件extra_data属性定义
+type PropertySchema struct {
+	Name          string        `json:"name"`                     // 属性名
+	Type          string        `json:"type"`                     // 属性类型：string, number, boolean, object, array
+	Required      bool          `json:"required"`                 // 是否必填
+	AllowedValues []interface{} `json:"allowed_values,omitempty"` // 允许的取值（为空表示不限制）
+}
+
+// EventSchemaFile 事件Schema配置文件结构
+type EventSchemaFile struct {
+	Schemas []EventSchema `json:"schemas"`
+}
+
 // EventBatchRequest 批量事件请求
 type EventBatchRequest struct {
 	Events []UserEvent `json:"events"`
 }
 
+// ConsentStats 处理模式统计（同意率 = 完整追踪事件数 / 全部事件数 * 100）
+type ConsentStats struct {
+	Days        []ConsentDay `json:"days"`
+	Full        int64        `json:"full"`
+	Anonymous   int64        `json:"anonymous"`
+	Dropped     int64        `json:"dropped"`
+	ConsentRate float64      `json:"consent_rate"`
+}
+
+// ConsentDay 单日处理模式统计
+type ConsentDay struct {
+	Date        string  `json:"date"` // YYYY-MM-DD
+	Full        int64   `json:"full"`
+	Anonymous   int64   `json:"anonymous"`
+	Dropped     int64   `json:"dropped"`
+	ConsentRate float64 `json:"consent_rate"`
+}
+
+// BotStats 机器人流量统计（不计入默认统计）
+type BotStats struct {
+	TotalEvents int64            `json:"total_events"`
+	ByReason    map[string]int64 `json:"by_reason"`     // 判定原因 -> 事件数
+	ByEventType map[string]int64 `json:"by_event_type"` // 事件类型 -> 事件数
+	TopPages    []PageStat       `json:"top_pages"`     // 机器人访问最多的页面
+}
+
 // StatsResponse 统计响应结构
 type StatsResponse struct {
 	OnlineUsers    int64            `json:"online_users"`
@@ -98,10 +290,28 @@ type StatsResponse struct {
 type PageStat struct {
 	PageURL string `json:"page_url"`
 	Views   int64  `json:"views"`
+	Group   string `json:"group,omitempty"` // 分组取值（指定 group_by 时）
+}
+
+// TimeSeriesPoint 时间序列数据点
+type TimeSeriesPoint struct {
+	Time   int64  `json:"time"`            // 时间桶起点（毫秒时间戳）
+	Group  string `json:"group,omitempty"` // 分组取值（指定 group_by 时）
+	Events int64  `json:"events"`          // 事件数
+	Users  int64  `json:"users"`           // 规范用户数
 }
 
 // FunnelResult 漏斗分析结果
 type FunnelResult struct {
+	Steps          []FunnelStep  `json:"steps"`
+	TotalUsers     int64         `json:"total_users"`
+	ConversionRate float64       `json:"conversion_rate"`
+	Groups         []FunnelGroup `json:"groups,omitempty"` // 按分组字段拆分的漏斗（指定 group_by 时）
+}
+
+// FunnelGroup 分组漏斗
+type FunnelGroup struct {
+	Value          string       `json:"value"`
 	Steps          []FunnelStep `json:"steps"`
 	TotalUsers     int64        `json:"total_users"`
 	ConversionRate float64      `json:"conversion_rate"`
@@ -116,14 +326,63 @@ type FunnelStep struct {
 
 // HealthResponse 健康检查响应
 type HealthResponse struct {
-	Status    string `json:"status"`
-	Timestamp string `json:"timestamp"`
-	Service   string `json:"service"`
+	Status    string       `json:"status"`
+	Timestamp string       `json:"timestamp"`
+	Service   string       `json:"service"`
+	Spool     *SpoolStatus `json:"spool,omitempty"` // 本地spool状态（未启用时为空）
+}
+
+// SpoolStatus 本地spool状态
+type SpoolStatus struct {
+	Depth    int64 `json:"depth"`    // 待回放事件数
+	Bytes    int64 `json:"bytes"`    // 占用字节数
+	Segments int   `json:"segments"` // 段文件数
 }
 
 // EventResponse 事件接收响应
 type EventResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
-	Count   int    `json:"count"`
+	Status     string           `json:"status"`
+	Message    string           `json:"message"`
+	Count      int              `json:"count"`                // 接收的事件数
+	Rejected   int              `json:"rejected"`             // 拒绝的事件数
+	Rejections []EventRejection `json:"rejections,omitempty"` // 被拒绝事件明细
+}
+
+// EventRejection 单个被拒绝事件的说明
+type EventRejection struct {
+	Index   int    `json:"index"`           // 事件在批次中的下标
+	Field   string `json:"field,omitempty"` // 相关字段
+	Rule    string `json:"rule"`            // 违反的规则
+	Code    string `json:"code"`            // 机器可读错误码
+	Message string `json:"message"`         // 错误描述
+}
+
+// 用户数据删除与导出任务类型
+const (
+	PrivacyJobErase  = "erase"  // 删除用户数据
+	PrivacyJobExport = "export" // 导出用户数据
+)
+
+// 用户数据删除与导出任务状态
+const (
+	PrivacyJobPending   = "pending"
+	PrivacyJobRunning   = "running"
+	PrivacyJobCompleted = "completed"
+	PrivacyJobFailed    = "failed"
+)
+
+// PrivacyJob 用户数据删除/导出任务（GDPR）
+type PrivacyJob struct {
+	JobID       string           `json:"job_id"`
+	ProjectID   string           `json:"project_id"`
+	UserID      string           `json:"user_id"`
+	JobType     string           `json:"job_type"`               // erase, export
+	Status      string           `json:"status"`                 // pending, running, completed, failed
+	Result      map[string]int64 `json:"result,omitempty"`       // 各数据源删除/导出的记录数
+	Error       string           `json:"error,omitempty"`        // 失败原因
+	DownloadURL string           `json:"download_url,omitempty"` // 导出文件下载地址（导出完成且未过期时）
+	CreatedAt   string           `json:"created_at"`
+	StartedAt   string           `json:"started_at,omitempty"`
+	CompletedAt string           `json:"completed_at,omitempty"`
+	FilePath    string           `json:"-"` // 导出文件路径
 }
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"insightflow/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// BotRules 机器人/爬虫流量识别规则
+type BotRules struct {
+	UserAgentSignatures  []string      // User-Agent 特征（不区分大小写的子串匹配）
+	DatacenterCIDRs      []string      // 数据中心IP网段（CIDR或单个IP）
+	MaxEventsPerMinute   int           // 单个用户每分钟事件数上限（0表示不检查）
+	PassiveViewThreshold int           // 会话浏览数达到该值且没有滚动、可见性变化事件时判定为机器人（0表示不检查）
+	FlagTTL              time.Duration // 用户被判定为机器人后，后续事件沿用判定结果的时间
+}
+
+// BotDetector 机器人/爬虫流量识别器
+// 请求特征（User-Agent、数据中心IP）在接收时判定，此时IP尚未截断或哈希；
+// 行为特征（事件频率、没有任何交互）在处理时按用户和会话累计判定
+type BotDetector struct {
+	Redis *redis.Client // 行为特征计数（为空则只按请求特征判定）
+
+	signatures     []string
+	datacenterNets []*net.IPNet
+	maxPerMinute   int64
+	passiveViews   int64
+	flagTTL        time.Duration
+}
+
+// NewBotDetector 创建机器人识别器（忽略无效的网段配置）
+func NewBotDetector(rules BotRules, redis *redis.Client) *BotDetector {
+	detector := &BotDetector{
+		Redis:        redis,
+		maxPerMinute: int64(rules.MaxEventsPerMinute),
+		passiveViews: int64(rules.PassiveViewThreshold),
+		flagTTL:      rules.FlagTTL,
+	}
+	for _, signature := range rules.UserAgentSignatures {
+		if signature = strings.ToLower(strings.TrimSpace(signature)); signature != "" {
+			detector.signatures = append(detector.signatures, signature)
+		}
+	}
+	for _, cidr := range rules.DatacenterCIDRs {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("忽略无效的数据中心网段: %s", cidr)
+			continue
+		}
+		detector.datacenterNets = append(detector.datacenterNets, ipNet)
+	}
+	if detector.flagTTL <= 0 {
+		detector.flagTTL = 24 * time.Hour
+	}
+	return detector
+}
+
+// ClassifyRequest 按 User-Agent 和IP判定事件是否为机器人流量（在脱敏和处理模式精简之前调用）
+// 机器人标记只由服务端判定，客户端自带的取值会被清除；服务端上报的事件不做判定
+func (bd *BotDetector) ClassifyRequest(event *models.UserEvent) {
+	event.IsBot = false
+	event.BotReason = ""
+	if bd == nil || event.Source == models.EventSourceServer {
+		return
+	}
+
+	if bd.matchUserAgent(event.UserAgent) {
+		markBot(event, models.BotReasonUserAgent)
+		return
+	}
+	if bd.inDatacenter(event.IPAddress) {
+		markBot(event, models.BotReasonDatacenterIP)
+	}
+}
+
+// ClassifyBehaviour 按用户和会话的行为判定事件是否为机器人流量（处理完整追踪的事件时调用）
+// 用户被判定为机器人后，在 FlagTTL 内的后续事件都按机器人处理；判定之前已计入统计的事件不做修正
+func (bd *BotDetector) ClassifyBehaviour(ctx context.Context, event *models.UserEvent) {
+	if bd == nil || bd.Redis == nil || event.IsBot || event.UserID == "" || event.Source == models.EventSourceServer {
+		return
+	}
+
+	flagKey := ProjectKey(event.ProjectID, "bot:user:"+event.UserID)
+	if reason, err := bd.Redis.Get(ctx, flagKey).Result(); err == nil {
+		markBot(event, reason)
+		return
+	}
+
+	reason := bd.behaviourReason(ctx, *event)
+	if reason == "" {
+		return
+	}
+	markBot(event, reason)
+	if err := bd.Redis.Set(ctx, flagKey, reason, bd.flagTTL).Err(); err != nil {
+		log.Printf("记录机器人用户失败: [%s] %s, %v", event.ProjectID, event.UserID, err)
+	}
+	log.Printf("判定为机器人流量: [%s] %s, 原因=%s", event.ProjectID, event.UserID, reason)
+}
+
+// behaviourReason 累计用户每分钟事件数和会话浏览/交互数，返回命中的判定原因（未命中为空）
+// Redis不可用时不判定，宁可多计也不误伤真实用户
+func (bd *BotDetector) behaviourReason(ctx context.Context, event models.UserEvent) string {
+	pipe := bd.Redis.Pipeline()
+
+	var rateCmd *redis.IntCmd
+	if bd.maxPerMinute > 0 {
+		minute := time.UnixMilli(event.Timestamp).Unix() / 60
+		rateKey := ProjectKey(event.ProjectID, "bot:rate:"+event.UserID+":"+strconv.FormatInt(minute, 10))
+		rateCmd = pipe.Incr(ctx, rateKey)
+		pipe.Expire(ctx, rateKey, 2*time.Minute)
+	}
+
+	var sessionCmd *redis.StringStringMapCmd
+	if bd.passiveViews > 0 && event.SessionID != "" {
+		sessionKey := ProjectKey(event.ProjectID, "bot:session:"+event.SessionID)
+		switch event.EventType {
+		case models.EventTypeView:
+			pipe.HIncrBy(ctx, sessionKey, "views", 1)
+		case models.EventTypeScroll, models.EventTypeVisibilityChange:
+			pipe.HIncrBy(ctx, sessionKey, "interactions", 1)
+		}
+		pipe.Expire(ctx, sessionKey, 30*time.Minute)
+		sessionCmd = pipe.HGetAll(ctx, sessionKey)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		log.Printf("更新机器人行为计数失败: %v", err)
+		return ""
+	}
+
+	if rateCmd != nil && rateCmd.Val() > bd.maxPerMinute {
+		return models.BotReasonEventRate
+	}
+	if sessionCmd != nil {
+		session := sessionCmd.Val()
+		views, _ := strconv.ParseInt(session["views"], 10, 64)
+		if views >= bd.passiveViews && session["interactions"] == "" {
+			return models.BotReasonNoInteraction
+		}
+	}
+	return ""
+}
+
+// matchUserAgent User-Agent 是否命中爬虫/监控特征
+func (bd *BotDetector) matchUserAgent(userAgent string) bool {
+	if userAgent == "" {
+		return false
+	}
+	userAgent = strings.ToLower(userAgent)
+	for _, signature := range bd.signatures {
+		if strings.Contains(userAgent, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// inDatacenter IP是否属于数据中心网段
+func (bd *BotDetector) inDatacenter(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range bd.datacenterNets {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// markBot 标记事件为机器人流量
+func markBot(event *models.UserEvent, reason string) {
+	event.IsBot = true
+	event.BotReason = reason
+}
+
+// incrBotStats 更新机器人流量统计（与默认统计分开累计）
+func incrBotStats(ctx context.Context, pipe redis.Pipeliner, event models.UserEvent) {
+	key := func(name string) string { return ProjectKey(event.ProjectID, name) }
+
+	pipe.Incr(ctx, key("bot:total_events"))
+	pipe.HIncrBy(ctx, key("bot:reasons"), event.BotReason, 1)
+	pipe.HIncrBy(ctx, key("bot:events"), event.EventType, 1)
+	if event.EventType == models.EventTypeView || event.EventType == models.EventTypeClick {
+		pipe.ZIncrBy(ctx, key("bot:hot_pages"), 1, event.PageURL)
+	}
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPResolver 客户端真实IP解析器
+// 只有直接连接方是受信任代理时，才会使用 X-Forwarded-For / X-Real-IP 请求头，
+// 防止客户端伪造请求头篡改IP
+type ClientIPResolver struct {
+	trustedProxies []*net.IPNet
+}
+
+// NewClientIPResolver 创建客户端IP解析器（trustedProxies为CIDR或单个IP）
+func NewClientIPResolver(trustedProxies []string) *ClientIPResolver {
+	resolver := &ClientIPResolver{}
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Printf("忽略无效的受信任代理配置: %s", proxy)
+			continue
+		}
+		resolver.trustedProxies = append(resolver.trustedProxies, ipNet)
+	}
+	return resolver
+}
+
+// Resolve 解析请求的客户端IP
+func (cr *ClientIPResolver) Resolve(r *http.Request) string {
+	remoteIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remoteIP = host
+	}
+	if !cr.isTrusted(remoteIP) {
+		return remoteIP
+	}
+
+	// 从右向左遍历X-Forwarded-For，第一个非受信任代理的地址即为客户端IP
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			if !cr.isTrusted(hop) || i == 0 {
+				return hop
+			}
+		}
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+
+	return remoteIP
+}
+
+// isTrusted 检查IP是否属于受信任代理
+func (cr *ClientIPResolver) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range cr.trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
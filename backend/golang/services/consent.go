@@ -0,0 +1,81 @@
+package services
+
+import (
+	"net/http"
+
+	"insightflow/models"
+)
+
+// trackingModeLevel 处理模式的限制程度（数值越大越严格）
+var trackingModeLevel = map[string]int{
+	models.TrackingModeFull:      0,
+	models.TrackingModeAnonymous: 1,
+	models.TrackingModeDrop:      2,
+}
+
+// IsValidTrackingMode 检查处理模式是否支持
+func IsValidTrackingMode(mode string) bool {
+	_, ok := trackingModeLevel[mode]
+	return ok
+}
+
+// ConsentPolicy 事件处理模式策略
+type ConsentPolicy struct {
+	DefaultMode string // 事件未携带同意状态时的处理模式
+	OptOutMode  string // 请求携带 DNT: 1 或 Sec-GPC: 1 时允许的最宽松模式
+}
+
+// Mode 根据事件的同意状态和请求的退出信号确定处理模式（两者取更严格的一方）
+func (cp ConsentPolicy) Mode(consent string, optOut bool) string {
+	var mode string
+	switch consent {
+	case models.ConsentGranted:
+		mode = models.TrackingModeFull
+	case models.ConsentAnonymous:
+		mode = models.TrackingModeAnonymous
+	case models.ConsentDenied:
+		mode = models.TrackingModeDrop
+	default:
+		mode = cp.DefaultMode
+	}
+	if !IsValidTrackingMode(mode) {
+		mode = models.TrackingModeFull
+	}
+
+	if optOut && IsValidTrackingMode(cp.OptOutMode) && trackingModeLevel[cp.OptOutMode] > trackingModeLevel[mode] {
+		mode = cp.OptOutMode
+	}
+	return mode
+}
+
+// HasOptOutSignal 请求是否携带退出追踪信号（DNT: 1 或 Sec-GPC: 1）
+func HasOptOutSignal(header http.Header) bool {
+	return header.Get("DNT") == "1" || header.Get("Sec-GPC") == "1"
+}
+
+// ApplyTrackingMode 按处理模式精简发送到Kafka的事件
+// 匿名模式去掉用户、会话和可识别个人的字段，只保留聚合统计需要的字段（设备、地理位置已在增强时解析）；
+// 丢弃模式只保留计数所需的字段
+func ApplyTrackingMode(event *models.UserEvent, mode string) {
+	event.TrackingMode = mode
+
+	switch mode {
+	case models.TrackingModeAnonymous:
+		event.UserID = ""
+		event.SessionID = ""
+		event.IPAddress = ""
+		event.UserAgent = ""
+		event.ElementText = ""
+		event.ExtraData = nil
+	case models.TrackingModeDrop:
+		*event = models.UserEvent{
+			ProjectID:    event.ProjectID,
+			EventID:      event.EventID,
+			EventType:    event.EventType,
+			Timestamp:    event.Timestamp,
+			Source:       event.Source,
+			Consent:      event.Consent,
+			TrackingMode: mode,
+		}
+	}
+}
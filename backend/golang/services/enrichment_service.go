@@ -0,0 +1,99 @@
+package services
+
+import (
+	"strconv"
+	"time"
+
+	"insightflow/models"
+)
+
+// RequestContext 事件所属HTTP请求的上下文信息（用于补全事件中缺失的字段）
+type RequestContext struct {
+	UserAgent string // 请求头中的User-Agent
+	ClientIP  string // 客户端真实IP（已处理受信任代理）
+}
+
+// GeoLocator IP地理位置查询接口
+type GeoLocator interface {
+	Lookup(ip string) (models.GeoLocation, bool)
+}
+
+// EventEnricher 事件增强服务
+// 在事件进入Kafka之前补充服务端可推导的信息（设备类型、浏览器、操作系统等）
+type EventEnricher struct {
+	userAgentParser *UserAgentParser
+	geoLocator      GeoLocator
+}
+
+// NewEventEnricher 创建事件增强服务
+func NewEventEnricher() *EventEnricher {
+	return &EventEnricher{
+		userAgentParser: NewUserAgentParser(),
+	}
+}
+
+// SetGeoLocator 设置IP地理位置查询（未设置时不解析地理位置）
+func (ee *EventEnricher) SetGeoLocator(locator GeoLocator) {
+	ee.geoLocator = locator
+}
+
+// Enrich 增强单个事件
+func (ee *EventEnricher) Enrich(event *models.UserEvent, reqCtx RequestContext) {
+	// 没有event_id的事件由服务端生成，保证Kafka重投递时也能去重
+	if event.EventID == "" {
+		event.EventID = newEventID()
+	}
+
+	// SDK把user_agent、页面标题和元素信息放在extra_data中，顶层字段为空时从extra_data中读取
+	if props, ok := event.ExtraData.(map[string]interface{}); ok {
+		fillFromProps(&event.UserAgent, props, "user_agent")
+		fillFromProps(&event.PageTitle, props, "page_title")
+		fillFromProps(&event.ElementID, props, "element_id")
+		fillFromProps(&event.ElementClass, props, "element_class")
+	}
+	if event.UserAgent == "" {
+		event.UserAgent = reqCtx.UserAgent
+	}
+
+	// 设备、浏览器和操作系统以服务端解析User-Agent的结果为准，不信任事件自带的取值
+	if event.UserAgent != "" {
+		info := ee.userAgentParser.Parse(event.UserAgent)
+		event.DeviceType = info.DeviceType
+		event.Browser = info.Browser
+		event.BrowserVersion = info.BrowserVersion
+		event.OS = info.OS
+		event.OSVersion = info.OSVersion
+	}
+
+	// 客户端IP以服务端解析结果为准，不信任事件自带的ip_address
+	if reqCtx.ClientIP != "" {
+		event.IPAddress = reqCtx.ClientIP
+	}
+
+	if ee.geoLocator != nil && event.IPAddress != "" {
+		if location, ok := ee.geoLocator.Lookup(event.IPAddress); ok {
+			event.Country = location.Country
+			event.Region = location.Region
+			event.City = location.City
+		}
+	}
+}
+
+// fillFromProps 字段为空时使用extra_data中的同名字符串属性
+func fillFromProps(field *string, props map[string]interface{}, name string) {
+	if *field != "" {
+		return
+	}
+	if value, ok := props[name].(string); ok {
+		*field = value
+	}
+}
+
+// newEventID 生成服务端事件ID
+func newEventID() string {
+	id, err := generateKey("evt_")
+	if err != nil {
+		return "evt_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return id
+}
@@ -0,0 +1,659 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"insightflow/models"
+)
+
+// 导入文件格式
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+)
+
+// errImportRecord 单条记录无法解析（跳过该记录，继续导入）
+var errImportRecord = errors.New("无法解析的记录")
+
+// MaxImportBatchSize 每批写入的最大事件数（单条INSERT的占位符不能超过MySQL的65535个）
+const MaxImportBatchSize = 65535 / eventInsertArgCount
+
+// ImportOptions 历史数据导入选项
+type ImportOptions struct {
+	Path      string // 导入文件路径
+	Format    string // 文件格式：csv 或 ndjson（为空时按扩展名判断）
+	ProjectID string // 事件归属的项目
+	BatchSize int    // 每批写入的事件数（最多 MaxImportBatchSize）
+	StatePath string // 断点文件路径（为空则不记录断点）
+	Resume    bool   // 是否从断点继续
+}
+
+// ImportResult 导入结果统计
+type ImportResult struct {
+	Read       int64 `json:"read"`       // 读取的记录数
+	Imported   int64 `json:"imported"`   // 新写入的事件数
+	Duplicates int64 `json:"duplicates"` // 已存在而跳过的事件数
+	Rejected   int64 `json:"rejected"`   // 解析或验证失败的记录数
+	Withheld   int64 `json:"withheld"`   // 未同意完整追踪（匿名、丢弃模式）而不写入明细的事件数
+}
+
+// importState 导入断点（每批写入成功后保存）
+type importState struct {
+	Path      string       `json:"path"`
+	Format    string       `json:"format"`
+	ProjectID string       `json:"project_id"`
+	Offset    int64        `json:"offset"`           // 下一条记录在文件中的字节偏移
+	Line      int64        `json:"line"`             // 已读取的记录序号（用于生成事件ID）
+	Header    []string     `json:"header,omitempty"` // CSV表头
+	Days      []string     `json:"days"`             // 涉及的日期（导入完成后重算每日汇总）
+	Result    ImportResult `json:"result"`
+	Completed bool         `json:"completed"`
+}
+
+// EventImporter 历史事件批量导入服务
+// 流式读取CSV/NDJSON文件，经过验证和增强后批量写入 user_events，
+// 重建涉及用户的 users 记录，并更新累计统计和每日汇总
+type EventImporter struct {
+	Processor      *EventProcessor
+	ServiceManager *ServiceManager
+	ConsentPolicy  ConsentPolicy // 按记录自带的同意状态确定处理模式（与在线接收相同）
+}
+
+// NewEventImporter 创建历史事件导入服务
+func NewEventImporter(processor *EventProcessor, serviceManager *ServiceManager) *EventImporter {
+	return &EventImporter{
+		Processor:      processor,
+		ServiceManager: serviceManager,
+	}
+}
+
+// ImportFile 导入单个文件
+// 事件ID缺失时按 项目+文件名+记录序号 生成确定性ID，重复导入或中断后重跑都不会产生重复数据
+func (ei *EventImporter) ImportFile(ctx context.Context, opts ImportOptions) (ImportResult, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.BatchSize > MaxImportBatchSize {
+		opts.BatchSize = MaxImportBatchSize
+	}
+	if opts.ProjectID == "" {
+		opts.ProjectID = models.DefaultProjectID
+	}
+	if opts.Format == "" {
+		opts.Format = detectImportFormat(opts.Path)
+	}
+	if opts.Format != ImportFormatCSV && opts.Format != ImportFormatNDJSON {
+		return ImportResult{}, fmt.Errorf("不支持的导入格式: %s", opts.Format)
+	}
+
+	state := &importState{Path: opts.Path, Format: opts.Format, ProjectID: opts.ProjectID}
+	if opts.Resume && opts.StatePath != "" {
+		loaded, err := loadImportState(opts.StatePath)
+		if err != nil {
+			return ImportResult{}, err
+		}
+		if loaded != nil {
+			if loaded.Path != opts.Path || loaded.ProjectID != opts.ProjectID {
+				return ImportResult{}, fmt.Errorf("断点文件 %s 不属于本次导入", opts.StatePath)
+			}
+			if loaded.Completed {
+				log.Printf("文件已导入完成，跳过: %s", opts.Path)
+				return loaded.Result, nil
+			}
+			state = loaded
+			log.Printf("从断点继续导入: %s, 偏移=%d, 已读取=%d", opts.Path, state.Offset, state.Line)
+		}
+	}
+
+	file, err := os.Open(opts.Path)
+	if err != nil {
+		return state.Result, err
+	}
+	defer file.Close()
+
+	reader, err := newImportReader(file, state)
+	if err != nil {
+		return state.Result, err
+	}
+
+	days := make(map[string]bool, len(state.Days))
+	for _, day := range state.Days {
+		days[day] = true
+	}
+
+	batch := make([]models.UserEvent, 0, opts.BatchSize)
+	flush := func() error {
+		if err := ei.writeBatch(ctx, opts.ProjectID, batch, &state.Result); err != nil {
+			return err
+		}
+		for _, event := range batch {
+			days[time.UnixMilli(event.Timestamp).Format("20060102")] = true
+		}
+		batch = batch[:0]
+
+		state.Offset = reader.Offset()
+		state.Line = reader.Line()
+		state.Days = sortedKeys(days)
+		return ei.saveState(opts.StatePath, state)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return state.Result, err
+		}
+
+		event, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		state.Result.Read++
+		if err != nil {
+			if !errors.Is(err, errImportRecord) {
+				return state.Result, err
+			}
+			log.Printf("跳过无法解析的记录: 第%d条, %v", reader.Line(), err)
+			state.Result.Rejected++
+			continue
+		}
+
+		if err := ei.prepareEvent(ctx, &event, opts, reader.Line()); err != nil {
+			log.Printf("跳过验证失败的记录: 第%d条, %v", reader.Line(), err)
+			state.Result.Rejected++
+			continue
+		}
+
+		// 匿名、丢弃模式的事件在线接收时也不写入明细；导入的历史事件不计入聚合统计，直接跳过
+		if event.TrackingMode != models.TrackingModeFull {
+			state.Result.Withheld++
+			continue
+		}
+
+		batch = append(batch, event)
+		if len(batch) >= opts.BatchSize {
+			if err := flush(); err != nil {
+				return state.Result, err
+			}
+			log.Printf("导入进度: %s, 已读取=%d, 已写入=%d", opts.Path, state.Result.Read, state.Result.Imported)
+		}
+	}
+
+	if err := flush(); err != nil {
+		return state.Result, err
+	}
+
+	// 按MySQL中的明细重算涉及日期的每日汇总
+	ei.rebuildDayRollups(ctx, opts.ProjectID, state.Days)
+
+	state.Completed = true
+	if err := ei.saveState(opts.StatePath, state); err != nil {
+		return state.Result, err
+	}
+	return state.Result, nil
+}
+
+// prepareEvent 补全并验证导入的事件（历史事件不做新鲜度检查）
+// 与在线接收相同，依次增强、识别机器人、脱敏，再按同意状态确定处理模式
+func (ei *EventImporter) prepareEvent(ctx context.Context, event *models.UserEvent, opts ImportOptions, line int64) error {
+	event.ProjectID = opts.ProjectID
+	if event.EventID == "" {
+		event.EventID = importEventID(opts.ProjectID, opts.Path, line)
+	}
+
+	if err := ei.ServiceManager.GetEventValidator().ValidateComplete(event); err != nil {
+		return err
+	}
+
+	// 导入数据没有请求上下文，只使用记录自带的User-Agent和IP
+	ei.ServiceManager.GetEventEnricher().Enrich(event, RequestContext{})
+	ei.ServiceManager.GetBotDetector().ClassifyRequest(event)
+
+	scrubber := ei.ServiceManager.GetPIIScrubber()
+	scrubber.RecordRedactions(ctx, opts.ProjectID, scrubber.Scrub(event))
+
+	// 导入数据没有DNT/Sec-GPC请求头，只看记录自带的同意状态
+	ApplyTrackingMode(event, ei.ConsentPolicy.Mode(event.Consent, false))
+	if event.TrackingMode == models.TrackingModeFull {
+		ei.Processor.Bots.ClassifyBehaviour(ctx, event)
+	}
+	return nil
+}
+
+// writeBatch 批量写入事件：跳过已存在的事件，写入新事件，更新累计统计并重建涉及的用户
+func (ei *EventImporter) writeBatch(ctx context.Context, projectID string, batch []models.UserEvent, result *ImportResult) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	existing, err := ei.existingEventIDs(projectID, batch)
+	if err != nil {
+		return err
+	}
+
+	fresh := make([]models.UserEvent, 0, len(batch))
+	for _, event := range batch {
+		if existing[event.EventID] {
+			result.Duplicates++
+			continue
+		}
+		existing[event.EventID] = true
+		fresh = append(fresh, event)
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(fresh))
+	args := make([]interface{}, 0, len(fresh)*eventInsertArgCount)
+	for i, event := range fresh {
+		placeholders[i] = eventInsertPlaceholders
+		args = append(args, eventInsertArgs(event)...)
+	}
+
+	query := `
+		INSERT INTO user_events (
+			` + eventInsertColumns + `
+		) VALUES ` + strings.Join(placeholders, ", ") + `
+		ON DUPLICATE KEY UPDATE id = id
+	`
+	if _, err := ei.Processor.DB.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("批量写入事件失败: %w", err)
+	}
+	result.Imported += int64(len(fresh))
+
+	ei.incrCounters(ctx, projectID, fresh)
+	return ei.rebuildUsers(ctx, projectID, fresh)
+}
+
+// existingEventIDs 查询本批中已存在的事件ID
+func (ei *EventImporter) existingEventIDs(projectID string, batch []models.UserEvent) (map[string]bool, error) {
+	placeholders := make([]string, len(batch))
+	args := make([]interface{}, 0, len(batch)+1)
+	args = append(args, projectID)
+	for i, event := range batch {
+		placeholders[i] = "?"
+		args = append(args, event.EventID)
+	}
+
+	rows, err := ei.Processor.DB.Query(`
+		SELECT event_id FROM user_events
+		WHERE project_id = ? AND event_id IN (`+strings.Join(placeholders, ", ")+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询已存在事件失败: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var eventID string
+		if err := rows.Scan(&eventID); err == nil {
+			existing[eventID] = true
+		}
+	}
+	return existing, rows.Err()
+}
+
+// incrCounters 更新累计统计（总事件数、事件类型计数、维度统计；机器人流量单独计数）
+// 小时桶和实时数据只反映近期流量，导入的历史事件不计入
+func (ei *EventImporter) incrCounters(ctx context.Context, projectID string, events []models.UserEvent) {
+	pipe := ei.Processor.Redis.Pipeline()
+	for _, event := range events {
+		if event.IsBot {
+			incrBotStats(ctx, pipe, event)
+			continue
+		}
+		pipe.Incr(ctx, ProjectKey(projectID, "total_events"))
+		pipe.Incr(ctx, ProjectKey(projectID, "events:"+event.EventType))
+		ei.Processor.incrBreakdowns(ctx, pipe, event)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("更新导入事件统计失败: %v", err)
+	}
+}
+
+// rebuildUsers 根据 user_events 明细重建涉及用户的 users 记录（按规范用户汇总）
+func (ei *EventImporter) rebuildUsers(ctx context.Context, projectID string, events []models.UserEvent) error {
+	canonicalIDs := make(map[string]bool)
+	for _, event := range events {
+		canonicalIDs[ei.Processor.Identity.Resolve(ctx, projectID, event.UserID)] = true
+	}
+
+	placeholders := make([]string, 0, len(canonicalIDs))
+	args := make([]interface{}, 0, len(canonicalIDs)+1)
+	args = append(args, projectID)
+	for canonicalID := range canonicalIDs {
+		placeholders = append(placeholders, "?")
+		args = append(args, canonicalID)
+	}
+
+	_, err := ei.Processor.DB.ExecContext(ctx, `
+		INSERT INTO users (project_id, user_id, first_visit, last_visit, total_events, total_sessions, device_type, browser, os)
+		SELECT e.project_id, `+canonicalUserExpr+` AS canonical_user,
+		       FROM_UNIXTIME(MIN(e.timestamp) / 1000), FROM_UNIXTIME(MAX(e.timestamp) / 1000),
+		       COUNT(*), COUNT(DISTINCT e.session_id),
+		       MAX(e.device_type), MAX(e.browser), MAX(e.os)
+		FROM user_events e `+identityJoin+`
+		WHERE e.project_id = ? AND e.is_bot = 0 AND `+canonicalUserExpr+` IN (`+strings.Join(placeholders, ", ")+`)
+		GROUP BY e.project_id, canonical_user
+		ON DUPLICATE KEY UPDATE
+		    first_visit = VALUES(first_visit), last_visit = VALUES(last_visit),
+		    total_events = VALUES(total_events), total_sessions = VALUES(total_sessions),
+		    device_type = COALESCE(users.device_type, VALUES(device_type)),
+		    browser = COALESCE(users.browser, VALUES(browser)),
+		    os = COALESCE(users.os, VALUES(os))
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("重建用户信息失败: %w", err)
+	}
+	return nil
+}
+
+// rebuildDayRollups 按 user_events 明细重算每日汇总（只处理保留期内的日期）
+func (ei *EventImporter) rebuildDayRollups(ctx context.Context, projectID string, days []string) {
+	for _, day := range days {
+		start, err := time.ParseInLocation("20060102", day, time.Local)
+		if err != nil || time.Since(start) >= dayRollupRetention {
+			continue
+		}
+		end := start.AddDate(0, 0, 1)
+
+		rows, err := ei.Processor.DB.QueryContext(ctx, `
+			SELECT event_type, COUNT(*) FROM user_events
+			WHERE project_id = ? AND is_bot = 0 AND timestamp >= ? AND timestamp < ?
+			GROUP BY event_type`, projectID, start.UnixMilli(), end.UnixMilli())
+		if err != nil {
+			log.Printf("重算每日汇总失败: %s, %v", day, err)
+			continue
+		}
+
+		counts := map[string]interface{}{}
+		var total int64
+		for rows.Next() {
+			var eventType string
+			var count int64
+			if err := rows.Scan(&eventType, &count); err == nil {
+				counts[eventType] = count
+				total += count
+			}
+		}
+		rows.Close()
+		counts["total"] = total
+
+		dayKey := ProjectKey(projectID, "events:day:"+day)
+		pipe := ei.Processor.Redis.TxPipeline()
+		pipe.Del(ctx, dayKey)
+		pipe.HSet(ctx, dayKey, counts)
+		pipe.ExpireAt(ctx, dayKey, start.Add(dayRollupRetention))
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("写入每日汇总失败: %s, %v", day, err)
+		}
+	}
+}
+
+// saveState 保存导入断点（先写临时文件再重命名，避免中断时写坏断点）
+func (ei *EventImporter) saveState(path string, state *importState) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadImportState 读取导入断点（文件不存在时返回nil）
+func loadImportState(path string) (*importState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state importState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("解析断点文件失败: %w", err)
+	}
+	return &state, nil
+}
+
+// importEventID 生成导入事件的确定性ID（按文件绝对路径和行号，同一文件重复导入得到相同ID，不同目录的同名文件不冲突）
+func importEventID(projectID, path string, line int64) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	sum := sha1.Sum([]byte(projectID + "|" + filepath.Clean(path) + "|" + strconv.FormatInt(line, 10)))
+	return "imp_" + hex.EncodeToString(sum[:16])
+}
+
+// detectImportFormat 按扩展名判断文件格式
+func detectImportFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ImportFormatCSV
+	case ".ndjson", ".jsonl", ".json":
+		return ImportFormatNDJSON
+	default:
+		return ""
+	}
+}
+
+// sortedKeys 返回排序后的集合元素
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// importReader 导入文件读取器
+type importReader interface {
+	Next() (models.UserEvent, error) // 读取下一条记录，结束时返回io.EOF
+	Offset() int64                   // 下一条记录的字节偏移
+	Line() int64                     // 已读取的记录序号
+}
+
+// newImportReader 根据格式创建读取器，并定位到断点位置
+func newImportReader(file *os.File, state *importState) (importReader, error) {
+	if _, err := file.Seek(state.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if state.Format == ImportFormatNDJSON {
+		return &ndjsonImportReader{reader: bufio.NewReader(file), offset: state.Offset, line: state.Line}, nil
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = false
+
+	csvReader := &csvImportReader{reader: reader, base: state.Offset, line: state.Line, header: state.Header}
+	if csvReader.header == nil {
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("读取CSV表头失败: %w", err)
+		}
+		for i := range header {
+			header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+		}
+		csvReader.header = header
+		state.Header = header
+	}
+	return csvReader, nil
+}
+
+// ndjsonImportReader NDJSON读取器：每行一个事件
+type ndjsonImportReader struct {
+	reader *bufio.Reader
+	offset int64
+	line   int64
+}
+
+func (r *ndjsonImportReader) Next() (models.UserEvent, error) {
+	for {
+		data, err := r.reader.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			return models.UserEvent{}, err
+		}
+		r.offset += int64(len(data))
+
+		data = []byte(strings.TrimSpace(string(data)))
+		if len(data) == 0 {
+			if err != nil {
+				return models.UserEvent{}, err
+			}
+			continue
+		}
+		r.line++
+
+		var event models.UserEvent
+		if jsonErr := json.Unmarshal(data, &event); jsonErr != nil {
+			return event, fmt.Errorf("%w: %v", errImportRecord, jsonErr)
+		}
+		return event, nil
+	}
+}
+
+func (r *ndjsonImportReader) Offset() int64 { return r.offset }
+func (r *ndjsonImportReader) Line() int64   { return r.line }
+
+// csvImportReader CSV读取器：首行为表头，列名与事件JSON字段同名，其他列写入extra_data
+type csvImportReader struct {
+	reader *csv.Reader
+	base   int64
+	line   int64
+	header []string
+}
+
+func (r *csvImportReader) Next() (models.UserEvent, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return models.UserEvent{}, err
+	}
+	r.line++
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return models.UserEvent{}, fmt.Errorf("%w: %v", errImportRecord, err)
+		}
+		return models.UserEvent{}, err
+	}
+
+	event, err := csvRecordToEvent(r.header, record)
+	if err != nil {
+		return event, fmt.Errorf("%w: %v", errImportRecord, err)
+	}
+	return event, nil
+}
+
+func (r *csvImportReader) Offset() int64 { return r.base + r.reader.InputOffset() }
+func (r *csvImportReader) Line() int64   { return r.line }
+
+// csvRecordToEvent 将CSV记录转换为事件
+func csvRecordToEvent(header, record []string) (models.UserEvent, error) {
+	var event models.UserEvent
+	extra := make(map[string]interface{})
+
+	for i, column := range header {
+		if i >= len(record) || record[i] == "" {
+			continue
+		}
+		value := record[i]
+
+		switch column {
+		case "event_id":
+			event.EventID = value
+		case "user_id":
+			event.UserID = value
+		case "session_id":
+			event.SessionID = value
+		case "event_type":
+			event.EventType = value
+		case "page_url":
+			event.PageURL = value
+		case "page_title":
+			event.PageTitle = value
+		case "element":
+			event.Element = value
+		case "element_id":
+			event.ElementID = value
+		case "element_class":
+			event.ElementClass = value
+		case "element_text":
+			event.ElementText = value
+		case "user_agent":
+			event.UserAgent = value
+		case "ip_address":
+			event.IPAddress = value
+		case "source":
+			event.Source = value
+		case "position_x", "position_y":
+			position, err := strconv.Atoi(value)
+			if err != nil {
+				return event, fmt.Errorf("%s格式错误: %s", column, value)
+			}
+			if column == "position_x" {
+				event.PositionX = &position
+			} else {
+				event.PositionY = &position
+			}
+		case "timestamp":
+			timestamp, err := parseImportTimestamp(value)
+			if err != nil {
+				return event, err
+			}
+			event.Timestamp = timestamp
+		case "extra_data":
+			if err := json.Unmarshal([]byte(value), &extra); err != nil {
+				return event, fmt.Errorf("extra_data不是JSON对象: %v", err)
+			}
+		default:
+			extra[column] = value
+		}
+	}
+
+	if len(extra) > 0 {
+		event.ExtraData = extra
+	}
+	return event, nil
+}
+
+// parseImportTimestamp 解析时间戳：毫秒/秒级Unix时间戳、RFC3339 或 "2006-01-02 15:04:05"
+func parseImportTimestamp(value string) (int64, error) {
+	if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if number < 1e12 {
+			return number * 1000, nil
+		}
+		return number, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UnixMilli(), nil
+	}
+	if parsed, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local); err == nil {
+		return parsed.UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("无法解析时间戳: %s", value)
+}
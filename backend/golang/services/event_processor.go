@@ -3,8 +3,12 @@ package services
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	"insightflow/models"
@@ -12,65 +16,255 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
+// 统计数据保留时间
+const (
+	hourBucketRetention = 25 * time.Hour      // 每小时事件统计保留一天多一点
+	dayRollupRetention  = 90 * 24 * time.Hour // 每日汇总保留90天
+)
+
 // EventProcessor 事件处理器
 type EventProcessor struct {
 	DB             *sql.DB
 	Redis          *redis.Client
 	ServiceManager *ServiceManager
+	Identity       *IdentityService
+	Profiles       *UserProfileService
+	Bots           *BotDetector // 机器人识别器（为空则只使用接收时的判定结果）
+
+	// DedupWindow 事件去重窗口：窗口内相同event_id的事件只计数一次
+	DedupWindow time.Duration
 }
 
 // NewEventProcessor 创建事件处理器
 func NewEventProcessor(db *sql.DB, redis *redis.Client) *EventProcessor {
+	identity := NewIdentityService(db, redis)
 	return &EventProcessor{
 		DB:             db,
 		Redis:          redis,
 		ServiceManager: NewServiceManager(),
+		Identity:       identity,
+		Profiles:       NewUserProfileService(db, identity),
+		DedupWindow:    24 * time.Hour,
 	}
 }
 
-// ProcessEvent 处理单个事件
-func (ep *EventProcessor) ProcessEvent(event models.UserEvent) {
+// ProcessEvent 处理单个事件，返回时事件已持久化（Kafka消费者据此提交位移）
+// 在调用方的goroutine中依次更新统计和持久化，并发度由调用方（消费者工作池）控制；
+// 持久化、关联用户身份或更新用户属性失败时返回错误，由调用方重试；重试时事件已登记去重，不会重复计入统计
+func (ep *EventProcessor) ProcessEvent(event models.UserEvent) error {
 	ctx := context.Background()
 
-	// 并行处理实时统计和数据持久化
-	go ep.updateRealTimeStats(ctx, event)
-	go ep.persistEvent(event)
+	// 多项目改造前产生的消息没有项目ID，归属默认项目
+	if event.ProjectID == "" {
+		event.ProjectID = models.DefaultProjectID
+	}
+
+	// 已删除用户在删除前产生、删除后才消费到的事件（Kafka积压、spool回放）直接丢弃
+	if IsErased(ctx, ep.Redis, event.ProjectID, event.UserID, event.Timestamp) {
+		log.Printf("用户数据已删除，丢弃事件: [%s] event_id=%s", event.ProjectID, event.EventID)
+		return nil
+	}
+
+	// 接入同意状态之前产生的消息没有处理模式，按完整追踪处理
+	if event.TrackingMode == "" {
+		event.TrackingMode = models.TrackingModeFull
+	}
+
+	// 重复事件（SDK重试、Kafka生产者重试、处理失败后的重试）不再更新统计，持久化由唯一约束保证幂等
+	if ep.isDuplicate(ctx, event) {
+		log.Printf("重复事件，跳过统计: [%s] event_id=%s", event.ProjectID, event.EventID)
+		if event.TrackingMode != models.TrackingModeFull {
+			return nil
+		}
+		if err := ep.retryUserOperation(ctx, event); err != nil {
+			return err
+		}
+		return ep.persistEvent(event)
+	}
+
+	ep.countTrackingMode(ctx, event)
+	if event.TrackingMode == models.TrackingModeDrop {
+		return nil
+	}
+
+	// 机器人流量只计入单独的统计，不计入默认统计、在线用户和用户信息；完整追踪的事件仍带标记持久化
+	if event.TrackingMode == models.TrackingModeFull {
+		ep.Bots.ClassifyBehaviour(ctx, &event)
+	}
+	if event.IsBot {
+		ep.updateBotStats(ctx, event)
+		if event.TrackingMode == models.TrackingModeFull {
+			return ep.persistEvent(event)
+		}
+		return nil
+	}
+
+	if event.TrackingMode == models.TrackingModeAnonymous {
+		// 匿名模式只更新聚合计数；identify、user_properties 是针对具体用户的操作，直接忽略
+		if event.EventType == models.EventTypeIdentify || event.EventType == models.EventTypeUserProperties {
+			return nil
+		}
+		ep.updateStats(ctx, event)
+		return nil
+	}
+
+	// identify 事件：将匿名ID关联到登录用户，只做持久化，不计入统计
+	if event.EventType == models.EventTypeIdentify {
+		if err := ep.handleIdentify(ctx, event); err != nil {
+			return err
+		}
+		return ep.persistEvent(event)
+	}
+
+	// user_properties 事件：更新用户属性，只做持久化，不计入统计
+	if event.EventType == models.EventTypeUserProperties {
+		if err := ep.handleUserProperties(ctx, event); err != nil {
+			return err
+		}
+		return ep.persistEvent(event)
+	}
+
+	log.Printf("处理事件: [%s] %s - %s - %s (迟到: %v)", event.ProjectID, event.UserID, event.EventType, event.PageURL, event.IsLate)
+
+	// 先更新统计再持久化：持久化失败重试时按重复事件只做持久化，统计不会丢失也不会重复
+	ep.updateStats(ctx, event)
+	return ep.persistEvent(event)
+}
+
+// updateStats 更新统计：迟到事件按原始时间回填，不计入当前小时和实时数据
+func (ep *EventProcessor) updateStats(ctx context.Context, event models.UserEvent) {
+	if event.IsLate {
+		ep.backfillStats(ctx, event)
+	} else {
+		ep.updateRealTimeStats(ctx, event)
+	}
+}
+
+// isDuplicate 检查事件是否在去重窗口内已处理过（首次出现时记录event_id）
+// Redis不可用时按非重复处理，宁可多计也不丢数据
+func (ep *EventProcessor) isDuplicate(ctx context.Context, event models.UserEvent) bool {
+	if event.EventID == "" || ep.DedupWindow <= 0 {
+		return false
+	}
+
+	dedupKey := ProjectKey(event.ProjectID, "dedup:"+event.EventID)
+	isNew, err := ep.Redis.SetNX(ctx, dedupKey, 1, ep.DedupWindow).Result()
+	if err != nil {
+		log.Printf("事件去重检查失败: %v", err)
+		return false
+	}
+	if !isNew {
+		ep.Redis.Incr(ctx, ProjectKey(event.ProjectID, "duplicate_events"))
+	}
+	return !isNew
+}
+
+// retryUserOperation 重新执行上次处理失败的 identify、user_properties 操作
+// 这两类事件在操作成功后才持久化，事件尚未持久化说明上次处理在操作或持久化时失败，需要重新执行
+func (ep *EventProcessor) retryUserOperation(ctx context.Context, event models.UserEvent) error {
+	if event.IsBot || (event.EventType != models.EventTypeIdentify && event.EventType != models.EventTypeUserProperties) {
+		return nil
+	}
+
+	var exists int
+	err := ep.DB.QueryRow(`SELECT 1 FROM user_events WHERE project_id = ? AND event_id = ? LIMIT 1`,
+		event.ProjectID, event.EventID).Scan(&exists)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("查询事件是否已持久化失败: [%s] event_id=%s, %w", event.ProjectID, event.EventID, err)
+	}
 
-	log.Printf("处理事件: %s - %s - %s", event.UserID, event.EventType, event.PageURL)
+	if event.EventType == models.EventTypeIdentify {
+		return ep.handleIdentify(ctx, event)
+	}
+	return ep.handleUserProperties(ctx, event)
+}
+
+// handleIdentify 处理 identify 事件（extra_data.anonymous_id 为登录前的匿名ID）
+// 存储失败时返回错误由调用方重试；ID冲突重试也不会成功，记录日志后照常持久化
+func (ep *EventProcessor) handleIdentify(ctx context.Context, event models.UserEvent) error {
+	props, _ := event.ExtraData.(map[string]interface{})
+	anonymousID, _ := props["anonymous_id"].(string)
+	if anonymousID == "" || anonymousID == event.UserID {
+		return nil
+	}
+
+	_, err := ep.Identity.Identify(ctx, event.ProjectID, anonymousID, event.UserID)
+	if errors.Is(err, ErrIdentityConflict) || errors.Is(err, ErrInvalidIdentity) {
+		log.Printf("关联用户身份失败，跳过: [%s] %s -> %s, %v", event.ProjectID, anonymousID, event.UserID, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("关联用户身份失败: [%s] %s -> %s, %w", event.ProjectID, anonymousID, event.UserID, err)
+	}
+	return nil
+}
+
+// handleUserProperties 处理 user_properties 事件（extra_data 为属性操作或直接设置的属性）
+// 存储失败时返回错误由调用方重试；属性操作无效时重试也不会成功，记录日志后照常持久化
+func (ep *EventProcessor) handleUserProperties(ctx context.Context, event models.UserEvent) error {
+	update, err := ParsePropertyUpdate(event.ExtraData)
+	if err == nil {
+		_, err = ep.Profiles.UpdateProperties(ctx, event.ProjectID, event.UserID, update)
+	}
+	if errors.Is(err, ErrInvalidPropertyUpdate) || errors.Is(err, ErrInvalidIdentity) {
+		log.Printf("更新用户属性失败，跳过: [%s] %s, %v", event.ProjectID, event.UserID, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("更新用户属性失败: [%s] %s, %w", event.ProjectID, event.UserID, err)
+	}
+	return nil
 }
 
 // updateRealTimeStats 更新实时统计数据
 func (ep *EventProcessor) updateRealTimeStats(ctx context.Context, event models.UserEvent) {
 	pipe := ep.Redis.Pipeline()
+	key := func(name string) string { return ProjectKey(event.ProjectID, name) }
+
+	// 匿名模式的事件没有用户和会话，不计入在线用户和会话数据
+	anonymous := event.TrackingMode == models.TrackingModeAnonymous
 
-	// 更新在线用户（使用SET，自动去重，5分钟过期）
-	pipe.SAdd(ctx, "online_users", event.UserID)
-	pipe.Expire(ctx, "online_users", 5*time.Minute)
+	// 更新在线用户（使用SET，自动去重，5分钟过期；按规范ID计数，同一个人只算一次）
+	if !anonymous {
+		pipe.SAdd(ctx, key("online_users"), ep.Identity.Resolve(ctx, event.ProjectID, event.UserID))
+		pipe.Expire(ctx, key("online_users"), 5*time.Minute)
+	}
 
 	// 总事件计数
-	pipe.Incr(ctx, "total_events")
+	pipe.Incr(ctx, key("total_events"))
 
 	// 按事件类型计数
-	pipe.Incr(ctx, "events:"+event.EventType)
+	pipe.Incr(ctx, key("events:"+event.EventType))
 
 	// 热门页面排行榜（使用ZSET）
 	if event.EventType == "view" || event.EventType == "click" {
-		pipe.ZIncrBy(ctx, "hot_pages", 1, event.PageURL)
+		pipe.ZIncrBy(ctx, key("hot_pages"), 1, event.PageURL)
 	}
 
 	// 用户会话数据
-	sessionKey := "session:" + event.SessionID
-	pipe.HSet(ctx, sessionKey, map[string]interface{}{
-		"user_id":       event.UserID,
-		"last_activity": time.Now().Unix(),
-		"page_url":      event.PageURL,
-	})
-	pipe.Expire(ctx, sessionKey, 30*time.Minute)
+	if !anonymous {
+		sessionKey := key("session:" + event.SessionID)
+		pipe.HSet(ctx, sessionKey, map[string]interface{}{
+			"user_id":       event.UserID,
+			"last_activity": time.Now().Unix(),
+			"page_url":      event.PageURL,
+		})
+		pipe.Expire(ctx, sessionKey, 30*time.Minute)
+	}
 
 	// 每小时事件统计
-	hourKey := "events:hour:" + time.Now().Format("2006010215")
+	hourKey := key("events:hour:" + time.Now().Format("2006010215"))
 	pipe.Incr(ctx, hourKey)
-	pipe.Expire(ctx, hourKey, 25*time.Hour) // 保留一天多一点
+	pipe.Expire(ctx, hourKey, hourBucketRetention)
+
+	// 每日汇总
+	ep.incrDayRollup(ctx, pipe, event, time.Now())
+
+	// 设备、浏览器、操作系统、地理位置维度统计
+	ep.incrBreakdowns(ctx, pipe, event)
 
 	// 执行管道
 	if _, err := pipe.Exec(ctx); err != nil {
@@ -78,16 +272,87 @@ func (ep *EventProcessor) updateRealTimeStats(ctx context.Context, event models.
 	}
 }
 
-// persistEvent 持久化事件到数据库
-func (ep *EventProcessor) persistEvent(event models.UserEvent) {
-	query := `
-		INSERT INTO user_events (
-			user_id, session_id, event_type, page_url, element, 
-			element_text, position_x, position_y, user_agent, timestamp
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
+// backfillStats 回填迟到事件的统计数据
+// 按事件原始时间修正对应的小时桶和每日汇总，不更新在线用户、会话和热门页面等实时数据
+func (ep *EventProcessor) backfillStats(ctx context.Context, event models.UserEvent) {
+	eventTime := time.UnixMilli(event.Timestamp)
+	pipe := ep.Redis.Pipeline()
+	key := func(name string) string { return ProjectKey(event.ProjectID, name) }
+
+	// 累计计数与事件时间无关，直接修正
+	pipe.Incr(ctx, key("total_events"))
+	pipe.Incr(ctx, key("events:"+event.EventType))
+	pipe.Incr(ctx, key("late_events"))
+
+	// 小时桶仍在保留期内时修正对应小时（过期时间与实时写入保持一致）
+	hourStart := eventTime.Truncate(time.Hour)
+	if time.Since(hourStart) < hourBucketRetention {
+		hourKey := key("events:hour:" + eventTime.Format("2006010215"))
+		pipe.Incr(ctx, hourKey)
+		pipe.ExpireAt(ctx, hourKey, hourStart.Add(time.Hour+hourBucketRetention))
+	}
 
-	// 处理可选字段
+	// 修正事件所在日期的每日汇总
+	ep.incrDayRollup(ctx, pipe, event, eventTime)
+
+	// 维度统计为累计值，直接修正
+	ep.incrBreakdowns(ctx, pipe, event)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("回填迟到事件统计失败: %v", err)
+	}
+}
+
+// updateBotStats 更新机器人流量统计
+func (ep *EventProcessor) updateBotStats(ctx context.Context, event models.UserEvent) {
+	pipe := ep.Redis.Pipeline()
+	incrBotStats(ctx, pipe, event)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("更新机器人流量统计失败: %v", err)
+	}
+}
+
+// countTrackingMode 按天统计各处理模式的事件数（Hash：处理模式 -> 事件数），用于计算同意率
+func (ep *EventProcessor) countTrackingMode(ctx context.Context, event models.UserEvent) {
+	now := time.Now()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	pipe := ep.Redis.Pipeline()
+	consentKey := ProjectKey(event.ProjectID, "consent:day:"+now.Format("20060102"))
+	pipe.HIncrBy(ctx, consentKey, event.TrackingMode, 1)
+	pipe.ExpireAt(ctx, consentKey, day.Add(dayRollupRetention))
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("更新处理模式统计失败: %v", err)
+	}
+}
+
+// incrDayRollup 更新每日汇总（Hash：total及各事件类型计数）
+func (ep *EventProcessor) incrDayRollup(ctx context.Context, pipe redis.Pipeliner, event models.UserEvent, at time.Time) {
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	if time.Since(day) >= dayRollupRetention {
+		return
+	}
+
+	dayKey := ProjectKey(event.ProjectID, "events:day:"+at.Format("20060102"))
+	pipe.HIncrBy(ctx, dayKey, "total", 1)
+	pipe.HIncrBy(ctx, dayKey, event.EventType, 1)
+	pipe.ExpireAt(ctx, dayKey, day.Add(dayRollupRetention))
+}
+
+// eventInsertColumns user_events 写入列（与 eventInsertArgs 顺序一致）
+const eventInsertColumns = `project_id, event_id, user_id, session_id, event_type, page_url, page_title, element,
+			element_text, element_id, element_class, position_x, position_y, user_agent, device_type,
+			browser, browser_version, os, os_version, ip_address,
+			country, region, city, timestamp, source, is_bot, bot_reason, extra_data`
+
+// eventInsertPlaceholders 单行写入占位符
+const eventInsertPlaceholders = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+// eventInsertArgCount 单行写入参数个数
+const eventInsertArgCount = 28
+
+// eventInsertArgs 事件写入参数（处理可选字段）
+func eventInsertArgs(event models.UserEvent) []interface{} {
 	var elementText sql.NullString
 	if event.ElementText != "" {
 		elementText = sql.NullString{String: event.ElementText, Valid: true}
@@ -101,37 +366,103 @@ func (ep *EventProcessor) persistEvent(event models.UserEvent) {
 		positionY = sql.NullInt64{Int64: int64(*event.PositionY), Valid: true}
 	}
 
-	_, err := ep.DB.Exec(query,
+	return []interface{}{
+		event.ProjectID,
+		nullString(event.EventID),
 		event.UserID,
 		event.SessionID,
 		event.EventType,
 		event.PageURL,
+		nullString(truncateRunes(event.PageTitle, 256)),
 		event.Element,
 		elementText,
+		nullString(truncateRunes(event.ElementID, 128)),
+		nullString(truncateRunes(event.ElementClass, 256)),
 		positionX,
 		positionY,
 		event.UserAgent,
+		nullString(event.DeviceType),
+		nullString(event.Browser),
+		nullString(event.BrowserVersion),
+		nullString(event.OS),
+		nullString(event.OSVersion),
+		nullString(event.IPAddress),
+		nullString(event.Country),
+		nullString(event.Region),
+		nullString(event.City),
 		event.Timestamp,
-	)
+		eventSource(event),
+		event.IsBot,
+		nullString(event.BotReason),
+		extraDataJSON(event),
+	}
+}
 
+// extraDataJSON 序列化 extra_data（为空或无法序列化时写入NULL）
+func extraDataJSON(event models.UserEvent) sql.NullString {
+	if event.ExtraData == nil {
+		return sql.NullString{}
+	}
+	data, err := json.Marshal(event.ExtraData)
 	if err != nil {
-		log.Printf("持久化事件失败: %v", err)
-		return
+		log.Printf("序列化extra_data失败: [%s] event_id=%s, %v", event.ProjectID, event.EventID, err)
+		return sql.NullString{}
 	}
+	return sql.NullString{String: string(data), Valid: true}
+}
 
-	// 更新用户信息表
-	ep.updateUserInfo(event)
+// persistEvent 持久化事件到数据库
+func (ep *EventProcessor) persistEvent(event models.UserEvent) error {
+	query := `
+		INSERT INTO user_events (
+			` + eventInsertColumns + `
+		) VALUES ` + eventInsertPlaceholders + `
+		ON DUPLICATE KEY UPDATE id = id
+	`
+
+	// 事件和用户信息在同一事务中写入，用户信息更新失败时事件也不写入，重试时一并重做
+	tx, err := ep.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("持久化事件失败: [%s] event_id=%s, %w", event.ProjectID, event.EventID, err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, eventInsertArgs(event)...)
+	if err != nil {
+		return fmt.Errorf("持久化事件失败: [%s] event_id=%s, %w", event.ProjectID, event.EventID, err)
+	}
+
+	// 相同(project_id, event_id)已存在，说明是重复事件，不再更新用户信息
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		log.Printf("事件已存在，跳过: [%s] event_id=%s", event.ProjectID, event.EventID)
+		return nil
+	}
+
+	// 机器人流量不计入用户信息
+	if !event.IsBot {
+		// 更新用户信息表（按规范ID汇总，已关联的匿名ID并入登录用户）
+		event.UserID = ep.Identity.Resolve(context.Background(), event.ProjectID, event.UserID)
+		if err := ep.updateUserInfo(tx, event); err != nil {
+			return fmt.Errorf("更新用户信息失败: [%s] %s, %w", event.ProjectID, event.UserID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("持久化事件失败: [%s] event_id=%s, %w", event.ProjectID, event.EventID, err)
+	}
+	return nil
 }
 
-// updateUserInfo 更新用户信息
-func (ep *EventProcessor) updateUserInfo(event models.UserEvent) {
+// updateUserInfo 更新用户信息（在持久化事件的事务中执行）
+func (ep *EventProcessor) updateUserInfo(tx *sql.Tx, event models.UserEvent) error {
 	// 检查用户是否存在
 	var existingUser models.User
-	err := ep.DB.QueryRow(`
-		SELECT user_id, first_visit, last_visit, total_events, total_sessions, 
+	err := tx.QueryRow(`
+		SELECT project_id, user_id, first_visit, last_visit, total_events, total_sessions, 
 		       COALESCE(device_type, '') as device_type, COALESCE(browser, '') as browser,
 		       created_at, updated_at 
-		FROM users WHERE user_id = ?`, event.UserID).Scan(
+		FROM users WHERE project_id = ? AND user_id = ?`, event.ProjectID, event.UserID).Scan(
+		&existingUser.ProjectID,
 		&existingUser.UserID,
 		&existingUser.FirstVisit,
 		&existingUser.LastVisit,
@@ -147,84 +478,144 @@ func (ep *EventProcessor) updateUserInfo(event models.UserEvent) {
 		// 新用户，使用UserService创建
 		newUser := ep.ServiceManager.GetUserService().InitializeNewUser(
 			event.UserID,
-			nil, // device_type 可以从 user_agent 解析
-			nil, // browser 可以从 user_agent 解析
+			optionalString(event.DeviceType), // 由 user_agent 解析
+			optionalString(event.Browser),    // 由 user_agent 解析
 		)
+		newUser.ProjectID = event.ProjectID
+		newUser.OS = optionalString(event.OS)
 
 		// 插入到数据库
-		_, err = ep.DB.Exec(`
-			INSERT INTO users (user_id, first_visit, last_visit, total_events, total_sessions, created_at, updated_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?)
-		`, newUser.UserID, newUser.FirstVisit, newUser.LastVisit,
-			newUser.TotalEvents, newUser.TotalSessions, newUser.CreatedAt, newUser.UpdatedAt)
-
-		if err != nil {
-			log.Printf("插入新用户失败: %v", err)
-		}
+		_, err = tx.Exec(`
+			INSERT INTO users (project_id, user_id, first_visit, last_visit, total_events, total_sessions, device_type, browser, os, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, newUser.ProjectID, newUser.UserID, newUser.FirstVisit, newUser.LastVisit,
+			newUser.TotalEvents, newUser.TotalSessions, newUser.DeviceType, newUser.Browser, newUser.OS,
+			newUser.CreatedAt, newUser.UpdatedAt)
+		return err
 	} else if err != nil {
-		log.Printf("查询用户信息失败: %v", err)
-		return
+		return err
 	} else {
 		// 现有用户，使用UserService更新
 		ep.ServiceManager.GetUserService().IncrementEvents(&existingUser)
 		ep.ServiceManager.GetUserService().UpdateLastVisit(&existingUser)
 
-		// 更新到数据库
-		_, err = ep.DB.Exec(`
+		// 更新到数据库（设备信息为空时用本次事件解析结果补全）
+		_, err = tx.Exec(`
 			UPDATE users 
-			SET last_visit = ?, total_events = ?, updated_at = ?
-			WHERE user_id = ?
-		`, existingUser.LastVisit, existingUser.TotalEvents, existingUser.UpdatedAt, existingUser.UserID)
+			SET last_visit = ?, total_events = ?, updated_at = ?,
+			    device_type = COALESCE(device_type, ?), browser = COALESCE(browser, ?), os = COALESCE(os, ?)
+			WHERE project_id = ? AND user_id = ?
+		`, existingUser.LastVisit, existingUser.TotalEvents, existingUser.UpdatedAt,
+			nullString(event.DeviceType), nullString(event.Browser), nullString(event.OS),
+			existingUser.ProjectID, existingUser.UserID)
+		return err
+	}
+}
 
-		if err != nil {
-			log.Printf("更新用户信息失败: %v", err)
+// 按规范用户统计时使用的身份关联（匿名ID与登录用户ID视为同一个人）
+const (
+	identityJoin      = "LEFT JOIN user_identities ui ON ui.project_id = e.project_id AND ui.user_id = e.user_id"
+	canonicalUserExpr = "COALESCE(ui.canonical_id, e.user_id)"
+)
+
+// funnelSteps 购买转化漏斗的步骤（事件类型及名称）
+var funnelSteps = []struct {
+	EventType string
+	Name      string
+}{
+	{"view", "页面访问"},
+	{"click", "商品点击"},
+	{"add_to_cart", "加入购物车"},
+	{"purchase", "完成购买"},
+}
+
+// CalculateFunnel 计算购买转化漏斗（可按事件属性、用户属性过滤，指定分组字段时按分组拆分）
+func (ep *EventProcessor) CalculateFunnel(q EventQuery) models.FunnelResult {
+	ctx := context.Background()
+
+	// 各步骤的用户数按规范用户去重
+	placeholders := make([]string, len(funnelSteps))
+	stepArgs := make([]interface{}, len(funnelSteps))
+	for i, step := range funnelSteps {
+		placeholders[i] = "?"
+		stepArgs[i] = step.EventType
+	}
+	rows, _, err := ep.aggregateEvents(ctx, q, aggregateSpec{
+		keyExpr:    "e.event_type",
+		extraWhere: "e.event_type IN (" + strings.Join(placeholders, ", ") + ")",
+		extraArgs:  stepArgs,
+		orderBy:    "2, 1",
+	})
+
+	// 查询失败时：无过滤条件退回事件计数，有条件则无法估算，各步骤为0
+	if err != nil {
+		log.Printf("查询漏斗用户数失败: %v", err)
+		stepUsers := make(map[string]int64, len(funnelSteps))
+		if !q.HasConditions() {
+			for _, step := range funnelSteps {
+				stepUsers[step.EventType] = ep.getEventCount(ctx, q.ProjectID, step.EventType)
+			}
+		}
+		return buildFunnel(stepUsers)
+	}
+
+	total := make(map[string]int64, len(funnelSteps))
+	grouped := make(map[string]map[string]int64)
+	var groupOrder []string
+	for _, row := range rows {
+		total[row.Key] += row.Users
+		if q.GroupBy == "" {
+			continue
 		}
+		if grouped[row.Group] == nil {
+			grouped[row.Group] = make(map[string]int64, len(funnelSteps))
+			groupOrder = append(groupOrder, row.Group)
+		}
+		grouped[row.Group][row.Key] = row.Users
 	}
+
+	// 分组时各分组用户可能重叠，总体漏斗只在不分组时精确
+	result := buildFunnel(total)
+	for _, group := range groupOrder {
+		groupFunnel := buildFunnel(grouped[group])
+		result.Groups = append(result.Groups, models.FunnelGroup{
+			Value:          group,
+			Steps:          groupFunnel.Steps,
+			TotalUsers:     groupFunnel.TotalUsers,
+			ConversionRate: groupFunnel.ConversionRate,
+		})
+	}
+	return result
 }
 
-// CalculateFunnel 计算购买转化漏斗
-func (ep *EventProcessor) CalculateFunnel() models.FunnelResult {
-	ctx := context.Background()
+// buildFunnel 根据各步骤用户数构建漏斗（转化率相对第一步）
+func buildFunnel(stepUsers map[string]int64) models.FunnelResult {
+	first := stepUsers[funnelSteps[0].EventType]
+	last := stepUsers[funnelSteps[len(funnelSteps)-1].EventType]
 
-	// 获取最近24小时的转化数据
-	views := ep.getEventCount(ctx, "view")
-	clicks := ep.getEventCount(ctx, "click")
-	addCarts := ep.getEventCount(ctx, "add_to_cart")
-	purchases := ep.getEventCount(ctx, "purchase")
-
-	steps := []models.FunnelStep{
-		{
-			Step:           "页面访问",
-			Users:          views,
-			ConversionRate: 100.0,
-		},
-		{
-			Step:           "商品点击",
-			Users:          clicks,
-			ConversionRate: calculateRate(clicks, views),
-		},
-		{
-			Step:           "加入购物车",
-			Users:          addCarts,
-			ConversionRate: calculateRate(addCarts, views),
-		},
-		{
-			Step:           "完成购买",
-			Users:          purchases,
-			ConversionRate: calculateRate(purchases, views),
-		},
+	steps := make([]models.FunnelStep, 0, len(funnelSteps))
+	for i, step := range funnelSteps {
+		rate := calculateRate(stepUsers[step.EventType], first)
+		if i == 0 {
+			rate = 100.0
+		}
+		steps = append(steps, models.FunnelStep{
+			Step:           step.Name,
+			Users:          stepUsers[step.EventType],
+			ConversionRate: rate,
+		})
 	}
 
 	return models.FunnelResult{
 		Steps:          steps,
-		TotalUsers:     views,
-		ConversionRate: calculateRate(purchases, views),
+		TotalUsers:     first,
+		ConversionRate: calculateRate(last, first),
 	}
 }
 
 // getEventCount 获取事件计数
-func (ep *EventProcessor) getEventCount(ctx context.Context, eventType string) int64 {
-	val, err := ep.Redis.Get(ctx, "events:"+eventType).Result()
+func (ep *EventProcessor) getEventCount(ctx context.Context, projectID, eventType string) int64 {
+	val, err := ep.Redis.Get(ctx, ProjectKey(projectID, "events:"+eventType)).Result()
 	if err != nil {
 		return 0
 	}
@@ -237,19 +628,19 @@ func (ep *EventProcessor) getEventCount(ctx context.Context, eventType string) i
 	return count
 }
 
-// CalculateRetention 计算用户留存率
-func (ep *EventProcessor) CalculateRetention(days int) map[string]float64 {
+// CalculateRetention 计算指定项目的用户留存率
+func (ep *EventProcessor) CalculateRetention(projectID string, days int) map[string]float64 {
 	query := `
 		SELECT 
-			DATE(created_at) as date,
-			COUNT(DISTINCT user_id) as daily_users
-		FROM user_events 
-		WHERE created_at >= DATE_SUB(NOW(), INTERVAL ? DAY)
-		GROUP BY DATE(created_at)
+			DATE(e.created_at) as date,
+			COUNT(DISTINCT ` + canonicalUserExpr + `) as daily_users
+		FROM user_events e ` + identityJoin + `
+		WHERE e.project_id = ? AND e.is_bot = 0 AND e.created_at >= DATE_SUB(NOW(), INTERVAL ? DAY)
+		GROUP BY DATE(e.created_at)
 		ORDER BY date
 	`
 
-	rows, err := ep.DB.Query(query, days)
+	rows, err := ep.DB.Query(query, projectID, days)
 	if err != nil {
 		log.Printf("查询留存数据失败: %v", err)
 		return nil
@@ -273,17 +664,22 @@ func (ep *EventProcessor) CalculateRetention(days int) map[string]float64 {
 	return retention
 }
 
-// GetUserPath 获取用户行为路径
-func (ep *EventProcessor) GetUserPath(userID string, limit int) []map[string]interface{} {
+// GetUserPath 获取用户行为路径（包含关联到同一规范用户的所有ID的事件）
+func (ep *EventProcessor) GetUserPath(projectID, userID string, limit int) []map[string]interface{} {
+	canonicalID := ep.Identity.Resolve(context.Background(), projectID, userID)
+
 	query := `
-		SELECT event_type, page_url, element, timestamp, created_at
+		SELECT user_id, event_type, page_url, COALESCE(page_title, ''), COALESCE(element, ''),
+		       COALESCE(element_id, ''), COALESCE(element_class, ''), extra_data, timestamp, source, created_at
 		FROM user_events 
-		WHERE user_id = ? 
+		WHERE project_id = ? AND (user_id = ? OR user_id IN (
+			SELECT user_id FROM user_identities WHERE project_id = ? AND canonical_id = ?
+		))
 		ORDER BY timestamp DESC 
 		LIMIT ?
 	`
 
-	rows, err := ep.DB.Query(query, userID, limit)
+	rows, err := ep.DB.Query(query, projectID, canonicalID, projectID, canonicalID, limit)
 	if err != nil {
 		log.Printf("查询用户路径失败: %v", err)
 		return nil
@@ -292,32 +688,44 @@ func (ep *EventProcessor) GetUserPath(userID string, limit int) []map[string]int
 
 	var path []map[string]interface{}
 	for rows.Next() {
-		var eventType, pageURL, element string
+		var eventUserID, eventType, pageURL, pageTitle, element, elementID, elementClass, source string
+		var extraData []byte
 		var timestamp int64
 		var createdAt time.Time
 
-		if err := rows.Scan(&eventType, &pageURL, &element, &timestamp, &createdAt); err != nil {
+		if err := rows.Scan(&eventUserID, &eventType, &pageURL, &pageTitle, &element,
+			&elementID, &elementClass, &extraData, &timestamp, &source, &createdAt); err != nil {
+			log.Printf("读取用户路径失败: %v", err)
 			continue
 		}
 
-		path = append(path, map[string]interface{}{
-			"event_type": eventType,
-			"page_url":   pageURL,
-			"element":    element,
-			"timestamp":  timestamp,
-			"created_at": createdAt,
-		})
+		entry := map[string]interface{}{
+			"user_id":       eventUserID,
+			"event_type":    eventType,
+			"page_url":      pageURL,
+			"page_title":    pageTitle,
+			"element":       element,
+			"element_id":    elementID,
+			"element_class": elementClass,
+			"timestamp":     timestamp,
+			"source":        source,
+			"created_at":    createdAt,
+		}
+		if len(extraData) > 0 {
+			entry["extra_data"] = json.RawMessage(extraData)
+		}
+		path = append(path, entry)
 	}
 
 	return path
 }
 
-// GetHotElements 获取热门元素
-func (ep *EventProcessor) GetHotElements(ctx context.Context, limit int64) []map[string]interface{} {
+// GetHotElements 获取指定项目的热门元素
+func (ep *EventProcessor) GetHotElements(ctx context.Context, projectID string, limit int64) []map[string]interface{} {
 	query := `
 		SELECT element, COUNT(*) as clicks
 		FROM user_events 
-		WHERE event_type = 'click' 
+		WHERE project_id = ? AND event_type = 'click' AND is_bot = 0
 		AND created_at >= DATE_SUB(NOW(), INTERVAL 24 HOUR)
 		AND element != '' 
 		GROUP BY element 
@@ -325,7 +733,7 @@ func (ep *EventProcessor) GetHotElements(ctx context.Context, limit int64) []map
 		LIMIT ?
 	`
 
-	rows, err := ep.DB.Query(query, limit)
+	rows, err := ep.DB.Query(query, projectID, limit)
 	if err != nil {
 		log.Printf("查询热门元素失败: %v", err)
 		return nil
@@ -364,6 +772,57 @@ func (ep *EventProcessor) CleanupExpiredData(ctx context.Context) {
 	log.Println("数据清理完成")
 }
 
+// incrBreakdowns 更新维度统计（Hash：维度取值 -> 事件数）
+func (ep *EventProcessor) incrBreakdowns(ctx context.Context, pipe redis.Pipeliner, event models.UserEvent) {
+	dimensions := map[string]string{
+		models.DimensionDeviceType: event.DeviceType,
+		models.DimensionBrowser:    event.Browser,
+		models.DimensionOS:         event.OS,
+		models.DimensionCountry:    event.Country,
+		models.DimensionRegion:     event.Region,
+		models.DimensionCity:       event.City,
+		models.DimensionSource:     eventSource(event),
+	}
+	for dimension, value := range dimensions {
+		if value != "" {
+			pipe.HIncrBy(ctx, ProjectKey(event.ProjectID, "breakdown:"+dimension), value, 1)
+		}
+	}
+}
+
+// eventSource 事件来源（改造前的消息没有来源字段，视为客户端上报）
+func eventSource(event models.UserEvent) string {
+	if event.Source == "" {
+		return models.EventSourceClient
+	}
+	return event.Source
+}
+
+// nullString 将空字符串转换为数据库NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// truncateRunes 按字符截断字符串（与数据库字段长度一致，避免写入失败）
+func truncateRunes(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
+
+// optionalString 将空字符串转换为nil指针
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
 // calculateRate 计算转化率
 func calculateRate(numerator, denominator int64) float64 {
 	if denominator == 0 {
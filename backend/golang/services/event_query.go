@@ -0,0 +1,523 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"insightflow/models"
+)
+
+// 分组查询的分组数限制（按事件数取前N个分组）
+const (
+	DefaultGroupLimit = 20
+	MaxGroupLimit     = 100
+)
+
+// ErrInvalidQuery 查询条件无效
+var ErrInvalidQuery = errors.New("查询条件无效")
+
+// 字段前缀：事件自定义属性和用户属性
+const (
+	extraDataFieldPrefix = "extra_data."
+	userFieldPrefix      = "user."
+)
+
+// eventColumnFields 可用于过滤和分组的事件列
+var eventColumnFields = map[string]string{
+	"event_type":    "e.event_type",
+	"page_url":      "e.page_url",
+	"page_title":    "e.page_title",
+	"element":       "e.element",
+	"element_text":  "e.element_text",
+	"element_id":    "e.element_id",
+	"element_class": "e.element_class",
+	"device_type":   "e.device_type",
+	"browser":       "e.browser",
+	"os":            "e.os",
+	"country":       "e.country",
+	"region":        "e.region",
+	"city":          "e.city",
+	"source":        "e.source",
+	"bot_reason":    "e.bot_reason",
+}
+
+// filterOperators 支持的过滤操作符（符号操作符按长度从长到短匹配）
+var filterOperators = []string{
+	models.FilterOpGte, models.FilterOpLte, models.FilterOpNeq,
+	models.FilterOpEq, models.FilterOpGt, models.FilterOpLt,
+}
+
+// EventQuery 基于 user_events 明细的统计查询条件
+type EventQuery struct {
+	ProjectID  string
+	Since      time.Time            // 起始时间（含）
+	Until      time.Time            // 截止时间（不含，为空表示当前时间）
+	Filters    []models.EventFilter // 过滤条件（全部满足）
+	GroupBy    string               // 分组字段（为空不分组）
+	GroupLimit int                  // 最多返回的分组数
+}
+
+// HasConditions 是否带有过滤或分组条件
+func (q EventQuery) HasConditions() bool {
+	return len(q.Filters) > 0 || q.GroupBy != ""
+}
+
+// Validate 检查过滤条件和分组字段
+func (q EventQuery) Validate() error {
+	_, err := q.compile()
+	return err
+}
+
+// ParseEventFilter 解析过滤表达式，如 extra_data.plan = "pro"、element_class contains "cta"、extra_data.amount >= 100
+// 带双引号的值按字符串处理，否则能解析为数值的按数值处理
+func ParseEventFilter(expr string) (models.EventFilter, error) {
+	expr = strings.TrimSpace(expr)
+
+	end := 0
+	for end < len(expr) && isFieldChar(expr[end]) {
+		end++
+	}
+	filter := models.EventFilter{Field: expr[:end]}
+	rest := strings.TrimSpace(expr[end:])
+
+	for _, op := range filterOperators {
+		if strings.HasPrefix(rest, op) {
+			filter.Op = op
+			rest = strings.TrimSpace(rest[len(op):])
+			break
+		}
+	}
+	if filter.Op == "" {
+		word := rest
+		if i := strings.IndexByte(rest, ' '); i >= 0 {
+			word = rest[:i]
+		}
+		switch word {
+		case models.FilterOpContains, models.FilterOpNotContains, models.FilterOpExists:
+			filter.Op = word
+			rest = strings.TrimSpace(rest[len(word):])
+		default:
+			return filter, fmt.Errorf("%w: 无法解析的过滤条件: %s", ErrInvalidQuery, expr)
+		}
+	}
+
+	switch {
+	case filter.Op == models.FilterOpExists:
+		if rest != "" {
+			return filter, fmt.Errorf("%w: exists 不需要比较值: %s", ErrInvalidQuery, expr)
+		}
+	case strings.HasPrefix(rest, `"`):
+		value, err := strconv.Unquote(rest)
+		if err != nil {
+			return filter, fmt.Errorf("%w: 字符串取值格式错误: %s", ErrInvalidQuery, expr)
+		}
+		filter.Value = value
+	case rest == "":
+		return filter, fmt.Errorf("%w: 缺少比较值: %s", ErrInvalidQuery, expr)
+	default:
+		if number, err := strconv.ParseFloat(rest, 64); err == nil {
+			filter.Value = number
+		} else {
+			filter.Value = rest
+		}
+	}
+
+	if _, _, _, err := fieldExpr(filter.Field, false); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// isFieldChar 字段名允许的字符
+func isFieldChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// fieldExpr 字段对应的SQL表达式及参数（JSON路径作为参数传入）
+// raw 为 true 时JSON字段返回原始JSON值（用于数值比较和存在判断），否则返回去引号后的字符串
+func fieldExpr(field string, raw bool) (string, []interface{}, bool, error) {
+	if column, ok := eventColumnFields[field]; ok {
+		return column, nil, false, nil
+	}
+
+	var document, name string
+	var needsUser bool
+	switch {
+	case strings.HasPrefix(field, extraDataFieldPrefix):
+		document, name = "e.extra_data", strings.TrimPrefix(field, extraDataFieldPrefix)
+	case strings.HasPrefix(field, userFieldPrefix):
+		document, name, needsUser = "u.properties", strings.TrimPrefix(field, userFieldPrefix), true
+	default:
+		return "", nil, false, fmt.Errorf("%w: 不支持的字段: %s", ErrInvalidQuery, field)
+	}
+
+	// 支持嵌套属性（extra_data.order.channel），每一级都必须是合法属性名
+	var path strings.Builder
+	path.WriteString("$")
+	for _, segment := range strings.Split(name, ".") {
+		if !IsValidPropertyName(segment) {
+			return "", nil, false, fmt.Errorf("%w: 属性名不合法: %s", ErrInvalidQuery, field)
+		}
+		path.WriteString(`."` + segment + `"`)
+	}
+
+	expr := "JSON_EXTRACT(" + document + ", ?)"
+	if !raw {
+		expr = "JSON_UNQUOTE(" + expr + ")"
+	}
+	return expr, []interface{}{path.String()}, needsUser, nil
+}
+
+// compiledQuery 编译后的查询条件
+type compiledQuery struct {
+	joins     string
+	where     string
+	args      []interface{}
+	groupExpr string
+	groupArgs []interface{}
+}
+
+// compile 将查询条件编译为参数化SQL片段（表别名 e 为 user_events，u 为 users）
+func (q EventQuery) compile() (*compiledQuery, error) {
+	until := q.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+
+	c := &compiledQuery{
+		where: "e.project_id = ? AND e.timestamp >= ? AND e.timestamp < ?",
+		args:  []interface{}{q.ProjectID, q.Since.UnixMilli(), until.UnixMilli()},
+	}
+	needsUser := false
+
+	// 默认排除机器人流量；过滤或分组引用 bot_reason 时包含机器人流量，用于单独分析
+	includeBots := q.GroupBy == "bot_reason"
+	for _, filter := range q.Filters {
+		includeBots = includeBots || filter.Field == "bot_reason"
+	}
+	if !includeBots {
+		c.where += " AND e.is_bot = 0"
+	}
+
+	for _, filter := range q.Filters {
+		condition, args, filterNeedsUser, err := filterSQL(filter)
+		if err != nil {
+			return nil, err
+		}
+		needsUser = needsUser || filterNeedsUser
+		c.where += " AND " + condition
+		c.args = append(c.args, args...)
+	}
+
+	if q.GroupBy != "" {
+		expr, args, groupNeedsUser, err := fieldExpr(q.GroupBy, false)
+		if err != nil {
+			return nil, err
+		}
+		needsUser = needsUser || groupNeedsUser
+		c.groupExpr = "COALESCE(" + expr + ", '')"
+		c.groupArgs = args
+	}
+
+	c.joins = " " + identityJoin
+	if needsUser {
+		c.joins += " LEFT JOIN users u ON u.project_id = e.project_id AND u.user_id = " + canonicalUserExpr
+	}
+	return c, nil
+}
+
+// filterSQL 单个过滤条件的SQL及参数
+func filterSQL(filter models.EventFilter) (string, []interface{}, bool, error) {
+	switch filter.Op {
+	case models.FilterOpExists:
+		expr, args, needsUser, err := fieldExpr(filter.Field, true)
+		if err != nil {
+			return "", nil, false, err
+		}
+		if _, isColumn := eventColumnFields[filter.Field]; isColumn {
+			return "(" + expr + " IS NOT NULL AND " + expr + " <> '')", args, needsUser, nil
+		}
+		return expr + " IS NOT NULL", args, needsUser, nil
+
+	case models.FilterOpGt, models.FilterOpGte, models.FilterOpLt, models.FilterOpLte:
+		number, ok := filter.Value.(float64)
+		if !ok {
+			return "", nil, false, fmt.Errorf("%w: %s %s 需要数值", ErrInvalidQuery, filter.Field, filter.Op)
+		}
+		expr, args, needsUser, err := fieldExpr(filter.Field, true)
+		if err != nil {
+			return "", nil, false, err
+		}
+		return expr + " " + filter.Op + " ?", append(args, number), needsUser, nil
+
+	case models.FilterOpEq, models.FilterOpNeq, models.FilterOpContains, models.FilterOpNotContains:
+		value, ok := filterValueString(filter.Value)
+		if !ok {
+			return "", nil, false, fmt.Errorf("%w: %s 缺少比较值", ErrInvalidQuery, filter.Field)
+		}
+		expr, args, needsUser, err := fieldExpr(filter.Field, false)
+		if err != nil {
+			return "", nil, false, err
+		}
+		// 表达式重复出现时参数也要重复
+		twice := append(append([]interface{}{}, args...), args...)
+
+		switch filter.Op {
+		case models.FilterOpEq:
+			return expr + " = ?", append(args, value), needsUser, nil
+		case models.FilterOpNeq:
+			return "(" + expr + " IS NULL OR " + expr + " <> ?)", append(twice, value), needsUser, nil
+		case models.FilterOpContains:
+			return expr + " LIKE ?", append(args, "%"+escapeLike(value)+"%"), needsUser, nil
+		default:
+			return "(" + expr + " IS NULL OR " + expr + " NOT LIKE ?)", append(twice, "%"+escapeLike(value)+"%"), needsUser, nil
+		}
+	}
+
+	return "", nil, false, fmt.Errorf("%w: 不支持的操作符: %s", ErrInvalidQuery, filter.Op)
+}
+
+// filterValueString 比较值的字符串形式（JSON属性去引号后按字符串比较）
+func filterValueString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// escapeLike 转义LIKE通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// aggregateRow 聚合结果行
+type aggregateRow struct {
+	Key    string // 主维度取值（事件类型、页面、时间桶等）
+	Group  string // 分组取值
+	Events int64  // 事件数
+	Users  int64  // 规范用户数
+}
+
+// aggregateSpec 聚合查询的主维度和附加条件
+type aggregateSpec struct {
+	keyExpr    string
+	keyArgs    []interface{}
+	extraWhere string // 附加条件（以 AND 连接）
+	extraArgs  []interface{}
+	orderBy    string // 排序（按SELECT列序号）
+	limit      int    // 最多返回的行数（0表示不限制）
+}
+
+// aggregateEvents 按主维度（及分组字段）统计事件数和规范用户数
+// 指定分组字段时先按事件数取前 GroupLimit 个分组，返回值表示是否有分组被截断
+func (ep *EventProcessor) aggregateEvents(ctx context.Context, q EventQuery, spec aggregateSpec) ([]aggregateRow, bool, error) {
+	c, err := q.compile()
+	if err != nil {
+		return nil, false, err
+	}
+
+	where := c.where
+	whereArgs := append([]interface{}{}, c.args...)
+	if spec.extraWhere != "" {
+		where += " AND " + spec.extraWhere
+		whereArgs = append(whereArgs, spec.extraArgs...)
+	}
+
+	truncated := false
+	selectExpr := spec.keyExpr + " AS k, '' AS g"
+	selectArgs := append([]interface{}{}, spec.keyArgs...)
+	groupBy := "1"
+
+	if c.groupExpr != "" {
+		groups, more, err := ep.topGroups(ctx, c, where, whereArgs, q.GroupLimit)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(groups) == 0 {
+			return nil, false, nil
+		}
+		truncated = more
+
+		placeholders := make([]string, len(groups))
+		whereArgs = append(whereArgs, c.groupArgs...)
+		for i, group := range groups {
+			placeholders[i] = "?"
+			whereArgs = append(whereArgs, group)
+		}
+		where += " AND " + c.groupExpr + " IN (" + strings.Join(placeholders, ", ") + ")"
+
+		selectExpr = spec.keyExpr + " AS k, " + c.groupExpr + " AS g"
+		selectArgs = append(selectArgs, c.groupArgs...)
+		groupBy = "1, 2"
+	}
+
+	query := `SELECT ` + selectExpr + `, COUNT(*), COUNT(DISTINCT ` + canonicalUserExpr + `)
+		FROM user_events e` + c.joins + `
+		WHERE ` + where + `
+		GROUP BY ` + groupBy
+	if spec.orderBy != "" {
+		query += " ORDER BY " + spec.orderBy
+	}
+	args := append(selectArgs, whereArgs...)
+	if spec.limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, spec.limit)
+	}
+
+	rows, err := ep.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var result []aggregateRow
+	for rows.Next() {
+		var row aggregateRow
+		if err := rows.Scan(&row.Key, &row.Group, &row.Events, &row.Users); err != nil {
+			return nil, false, err
+		}
+		result = append(result, row)
+	}
+	return result, truncated, rows.Err()
+}
+
+// topGroups 按事件数取前 limit 个分组取值（多取一个用于判断是否截断）
+func (ep *EventProcessor) topGroups(ctx context.Context, c *compiledQuery, where string, whereArgs []interface{}, limit int) ([]string, bool, error) {
+	if limit <= 0 {
+		limit = DefaultGroupLimit
+	}
+	if limit > MaxGroupLimit {
+		limit = MaxGroupLimit
+	}
+
+	args := append(append([]interface{}{}, c.groupArgs...), whereArgs...)
+	args = append(args, limit+1)
+	rows, err := ep.DB.QueryContext(ctx, `
+		SELECT `+c.groupExpr+` AS g, COUNT(*) AS events
+		FROM user_events e`+c.joins+`
+		WHERE `+where+`
+		GROUP BY 1
+		ORDER BY 2 DESC
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var groups []string
+	for rows.Next() {
+		var group string
+		var events int64
+		if err := rows.Scan(&group, &events); err != nil {
+			return nil, false, err
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(groups) > limit {
+		return groups[:limit], true, nil
+	}
+	return groups, false, nil
+}
+
+// QueryEventStats 按过滤条件统计各事件类型的事件数和用户数（指定分组字段时按分组拆分）
+func (ep *EventProcessor) QueryEventStats(ctx context.Context, q EventQuery) (map[string]interface{}, error) {
+	rows, truncated, err := ep.aggregateEvents(ctx, q, aggregateSpec{keyExpr: "e.event_type", orderBy: "3 DESC"})
+	if err != nil {
+		return nil, err
+	}
+
+	var totalEvents int64
+	eventsByType := make(map[string]int64)
+	usersByType := make(map[string]int64)
+	groups := make(map[string]map[string]int64)
+	for _, row := range rows {
+		totalEvents += row.Events
+		if q.GroupBy == "" {
+			eventsByType[row.Key] = row.Events
+			usersByType[row.Key] = row.Users
+			continue
+		}
+		if groups[row.Group] == nil {
+			groups[row.Group] = make(map[string]int64)
+		}
+		groups[row.Group][row.Key] = row.Events
+		eventsByType[row.Key] += row.Events
+	}
+
+	stats := map[string]interface{}{
+		"project_id":     q.ProjectID,
+		"total_events":   totalEvents,
+		"events_by_type": eventsByType,
+		"filters":        q.Filters,
+		"since":          q.Since.UnixMilli(),
+	}
+	if q.GroupBy == "" {
+		stats["users_by_type"] = usersByType
+	} else {
+		stats["group_by"] = q.GroupBy
+		stats["groups"] = groups
+		stats["groups_truncated"] = truncated
+	}
+	return stats, nil
+}
+
+// QueryHotPages 按过滤条件统计热门页面（浏览和点击，指定分组字段时返回各分组的页面）
+func (ep *EventProcessor) QueryHotPages(ctx context.Context, q EventQuery, limit int) ([]models.PageStat, bool, error) {
+	rows, truncated, err := ep.aggregateEvents(ctx, q, aggregateSpec{
+		keyExpr:    "e.page_url",
+		extraWhere: "e.event_type IN (?, ?)",
+		extraArgs:  []interface{}{models.EventTypeView, models.EventTypeClick},
+		orderBy:    "3 DESC",
+		limit:      limit,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	pages := make([]models.PageStat, 0, len(rows))
+	for _, row := range rows {
+		pages = append(pages, models.PageStat{PageURL: row.Key, Views: row.Events, Group: row.Group})
+	}
+	return pages, truncated, nil
+}
+
+// QueryTimeSeries 按时间桶统计事件数和用户数（时间桶按UTC对齐，指定分组字段时按分组拆分）
+func (ep *EventProcessor) QueryTimeSeries(ctx context.Context, q EventQuery, interval time.Duration) ([]models.TimeSeriesPoint, bool, error) {
+	bucket := interval.Milliseconds()
+	if bucket <= 0 {
+		return nil, false, fmt.Errorf("%w: 时间间隔必须大于0", ErrInvalidQuery)
+	}
+
+	rows, truncated, err := ep.aggregateEvents(ctx, q, aggregateSpec{
+		keyExpr: "(e.timestamp DIV ?) * ?",
+		keyArgs: []interface{}{bucket, bucket},
+		orderBy: "1, 2",
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	points := make([]models.TimeSeriesPoint, 0, len(rows))
+	for _, row := range rows {
+		bucketStart, err := strconv.ParseInt(row.Key, 10, 64)
+		if err != nil {
+			return nil, false, err
+		}
+		points = append(points, models.TimeSeriesPoint{Time: bucketStart, Group: row.Group, Events: row.Events, Users: row.Users})
+	}
+	return points, truncated, nil
+}
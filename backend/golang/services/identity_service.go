@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 身份关联相关错误
+var (
+	ErrInvalidIdentity  = errors.New("用户ID不能为空")
+	ErrIdentityConflict = errors.New("该ID已关联到其他用户")
+)
+
+// identityCacheTTL 规范ID缓存时间
+const identityCacheTTL = 10 * time.Minute
+
+// IdentityService 用户身份关联服务
+// user_identities 表记录每个被关联ID到规范ID（登录后的用户ID）的映射，映射始终是扁平的：
+// 合并两个用户时，原先指向旧规范ID的记录全部改为指向新规范ID
+type IdentityService struct {
+	DB    *sql.DB
+	Redis *redis.Client
+}
+
+// NewIdentityService 创建身份关联服务
+func NewIdentityService(db *sql.DB, redis *redis.Client) *IdentityService {
+	return &IdentityService{
+		DB:    db,
+		Redis: redis,
+	}
+}
+
+// Resolve 获取用户的规范ID（未关联时返回自身）
+func (is *IdentityService) Resolve(ctx context.Context, projectID, userID string) string {
+	if userID == "" {
+		return userID
+	}
+
+	cacheKey := ProjectKey(projectID, "identity:"+userID)
+	if canonical, err := is.Redis.Get(ctx, cacheKey).Result(); err == nil {
+		return canonical
+	}
+
+	canonical, err := is.lookup(ctx, is.DB, projectID, userID)
+	if err != nil {
+		log.Printf("查询用户身份失败: %v", err)
+		return userID
+	}
+
+	is.Redis.Set(ctx, cacheKey, canonical, identityCacheTTL)
+	return canonical
+}
+
+// Aliases 获取关联到规范ID的所有ID（不含规范ID本身）
+func (is *IdentityService) Aliases(ctx context.Context, projectID, canonicalID string) []string {
+	rows, err := is.DB.QueryContext(ctx, `
+		SELECT user_id FROM user_identities
+		WHERE project_id = ? AND canonical_id = ? AND user_id <> canonical_id
+		ORDER BY created_at`, projectID, canonicalID)
+	if err != nil {
+		log.Printf("查询关联ID失败: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	aliases := make([]string, 0)
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err == nil {
+			aliases = append(aliases, userID)
+		}
+	}
+	return aliases
+}
+
+// Identify 登录时将匿名ID关联到登录用户ID
+// 匿名ID已关联到其他用户时返回 ErrIdentityConflict（例如共用设备），不做合并
+func (is *IdentityService) Identify(ctx context.Context, projectID, anonymousID, userID string) (string, error) {
+	return is.link(ctx, projectID, anonymousID, userID, false)
+}
+
+// Alias 将旧用户ID（及其已关联的所有ID）合并到新用户ID，用于用户ID变更等场景
+func (is *IdentityService) Alias(ctx context.Context, projectID, previousID, userID string) (string, error) {
+	return is.link(ctx, projectID, previousID, userID, true)
+}
+
+// link 将 previousID 所在的用户并入 userID 所在的用户，并合并 users 汇总数据
+func (is *IdentityService) link(ctx context.Context, projectID, previousID, userID string, allowMerge bool) (string, error) {
+	if previousID == "" || userID == "" {
+		return "", ErrInvalidIdentity
+	}
+
+	tx, err := is.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	target, err := is.lookup(ctx, tx, projectID, userID)
+	if err != nil {
+		return "", err
+	}
+	source, err := is.lookup(ctx, tx, projectID, previousID)
+	if err != nil {
+		return "", err
+	}
+	if source == target {
+		return target, nil
+	}
+
+	if !allowMerge {
+		// 匿名ID已并入其他用户，或已有其他ID并入该匿名ID
+		var linked int
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM user_identities WHERE project_id = ? AND canonical_id = ?`,
+			projectID, source).Scan(&linked); err != nil {
+			return "", err
+		}
+		if source != previousID || linked > 0 {
+			return "", ErrIdentityConflict
+		}
+	}
+
+	// 记录映射变化的ID，提交后清除缓存
+	changed, err := is.identityGroup(ctx, tx, projectID, source)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE user_identities SET canonical_id = ? WHERE project_id = ? AND canonical_id = ?`,
+		target, projectID, source); err != nil {
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_identities (project_id, user_id, canonical_id) VALUES (?, ?, ?), (?, ?, ?)
+		ON DUPLICATE KEY UPDATE canonical_id = VALUES(canonical_id)`,
+		projectID, source, target, projectID, target, target); err != nil {
+		return "", err
+	}
+
+	if err := is.mergeUsers(ctx, tx, projectID, source, target); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	keys := make([]string, 0, len(changed)+1)
+	for _, id := range append(changed, target) {
+		keys = append(keys, ProjectKey(projectID, "identity:"+id))
+	}
+	is.Redis.Del(ctx, keys...)
+
+	log.Printf("用户身份关联: [%s] %s -> %s", projectID, previousID, target)
+	return target, nil
+}
+
+// mergeUsers 将 source 的 users 汇总数据并入 target 并删除 source 记录（同名用户属性以 target 为准）
+func (is *IdentityService) mergeUsers(ctx context.Context, tx *sql.Tx, projectID, source, target string) error {
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO users (project_id, user_id, first_visit, last_visit, total_events, total_sessions, device_type, browser, os, properties)
+		SELECT project_id, ?, first_visit, last_visit, total_events, total_sessions, device_type, browser, os, properties
+		FROM users WHERE project_id = ? AND user_id = ?
+		ON DUPLICATE KEY UPDATE
+		    first_visit = LEAST(users.first_visit, VALUES(first_visit)),
+		    last_visit = GREATEST(users.last_visit, VALUES(last_visit)),
+		    total_events = users.total_events + VALUES(total_events),
+		    total_sessions = users.total_sessions + VALUES(total_sessions),
+		    device_type = COALESCE(users.device_type, VALUES(device_type)),
+		    browser = COALESCE(users.browser, VALUES(browser)),
+		    os = COALESCE(users.os, VALUES(os)),
+		    properties = JSON_MERGE_PATCH(COALESCE(VALUES(properties), JSON_OBJECT()), COALESCE(users.properties, JSON_OBJECT()))`,
+		target, projectID, source); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM users WHERE project_id = ? AND user_id = ?`, projectID, source)
+	return err
+}
+
+// Group 获取用户所在身份组的全部ID（规范ID在前，不经过缓存）
+func (is *IdentityService) Group(ctx context.Context, projectID, userID string) ([]string, error) {
+	if userID == "" {
+		return nil, ErrInvalidIdentity
+	}
+	canonicalID, err := is.lookup(ctx, is.DB, projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return is.identityGroup(ctx, is.DB, projectID, canonicalID)
+}
+
+// identityGroup 获取规范ID及关联到它的所有ID
+func (is *IdentityService) identityGroup(ctx context.Context, db queryer, projectID, canonicalID string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_id FROM user_identities WHERE project_id = ? AND canonical_id = ?`, projectID, canonicalID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	group := []string{canonicalID}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err == nil && userID != canonicalID {
+			group = append(group, userID)
+		}
+	}
+	return group, rows.Err()
+}
+
+// queryer 可执行多行查询的数据库对象（*sql.DB 或 *sql.Tx）
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryRower 可执行单行查询的数据库对象（*sql.DB 或 *sql.Tx）
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// lookup 从数据库查询规范ID（未关联时返回自身）
+func (is *IdentityService) lookup(ctx context.Context, db queryRower, projectID, userID string) (string, error) {
+	var canonical string
+	err := db.QueryRowContext(ctx, `
+		SELECT canonical_id FROM user_identities WHERE project_id = ? AND user_id = ?`,
+		projectID, userID).Scan(&canonical)
+	if err == sql.ErrNoRows {
+		return userID, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return canonical, nil
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"insightflow/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redactedValue 脱敏后的替换值
+const redactedValue = "[REDACTED]"
+
+// ScrubRules 个人敏感信息脱敏规则
+type ScrubRules struct {
+	Patterns           []string // 正则表达式，匹配内容替换为 [REDACTED]
+	QueryParamDenyList []string // page_url 等URL中需要脱敏的查询参数（不区分大小写）
+	TruncateIP         bool     // 截断IP地址（IPv4保留前24位，IPv6保留前48位）
+	HashFields         []string // 需要哈希的字段（如 user_id、extra_data.email）
+	HashSalt           string   // 哈希盐值
+}
+
+// PIIScrubber 个人敏感信息脱敏器
+// 在事件发送到Kafka之前执行：依次做字段哈希、URL查询参数脱敏、正则脱敏和IP截断
+type PIIScrubber struct {
+	Redis *redis.Client // 用于记录脱敏计数（为空则不记录）
+
+	patterns   []*regexp.Regexp
+	denyParams map[string]bool
+	truncateIP bool
+	hashFields map[string]bool
+	hashSalt   string
+}
+
+// NewPIIScrubber 创建脱敏器（正则表达式无法编译时返回错误）
+func NewPIIScrubber(rules ScrubRules, redis *redis.Client) (*PIIScrubber, error) {
+	scrubber := &PIIScrubber{
+		Redis:      redis,
+		denyParams: make(map[string]bool, len(rules.QueryParamDenyList)),
+		truncateIP: rules.TruncateIP,
+		hashFields: make(map[string]bool, len(rules.HashFields)),
+		hashSalt:   rules.HashSalt,
+	}
+
+	for _, pattern := range rules.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("脱敏规则 %q 无效: %w", pattern, err)
+		}
+		scrubber.patterns = append(scrubber.patterns, re)
+	}
+	for _, param := range rules.QueryParamDenyList {
+		scrubber.denyParams[strings.ToLower(param)] = true
+	}
+	for _, field := range rules.HashFields {
+		scrubber.hashFields[field] = true
+	}
+	return scrubber, nil
+}
+
+// Scrub 脱敏单个事件，返回各字段的脱敏次数
+func (ps *PIIScrubber) Scrub(event *models.UserEvent) map[string]int64 {
+	counts := make(map[string]int64)
+	if ps == nil {
+		return counts
+	}
+
+	// 哈希在其他规则之前执行，保证相同原始值得到相同哈希
+	for field, value := range map[string]*string{
+		"user_id":      &event.UserID,
+		"session_id":   &event.SessionID,
+		"ip_address":   &event.IPAddress,
+		"element_text": &event.ElementText,
+		"page_title":   &event.PageTitle,
+	} {
+		if ps.hashFields[field] && *value != "" {
+			*value = ps.hash(*value)
+			counts[field]++
+		}
+	}
+
+	ps.scrubString("page_url", &event.PageURL, true, counts)
+	ps.scrubString("page_title", &event.PageTitle, false, counts)
+	ps.scrubString("element", &event.Element, false, counts)
+	ps.scrubString("element_text", &event.ElementText, false, counts)
+
+	if props, ok := event.ExtraData.(map[string]interface{}); ok {
+		// identify 事件中的匿名ID与 user_id 使用同一规则，保证身份关联仍然有效
+		if anonymousID, ok := props["anonymous_id"].(string); ok && event.EventType == models.EventTypeIdentify {
+			props["anonymous_id"] = ps.HashUserID(anonymousID)
+		}
+		ps.scrubProps("extra_data", props, counts)
+	}
+
+	if ps.truncateIP && !ps.hashFields["ip_address"] && event.IPAddress != "" {
+		if truncated := truncateIP(event.IPAddress); truncated != event.IPAddress {
+			event.IPAddress = truncated
+			counts["ip_address"]++
+		}
+	}
+
+	return counts
+}
+
+// ScrubProperties 脱敏用户属性（格式与 user_properties 事件的 extra_data 相同，按 extra_data 的规则脱敏）
+func (ps *PIIScrubber) ScrubProperties(props map[string]interface{}) map[string]int64 {
+	counts := make(map[string]int64)
+	if ps == nil {
+		return counts
+	}
+	ps.scrubProps("extra_data", props, counts)
+	return counts
+}
+
+// HashUserID 按 user_id 的哈希规则转换用户ID（未配置哈希 user_id 时原样返回）
+// 身份关联、用户属性和用户查询接口直接接收用户ID，需与事件中的 user_id 保持一致
+func (ps *PIIScrubber) HashUserID(userID string) string {
+	if ps == nil || !ps.hashFields["user_id"] || userID == "" {
+		return userID
+	}
+	return ps.hash(userID)
+}
+
+// RecordRedactions 累加项目的脱敏计数（Hash：字段 -> 次数）
+func (ps *PIIScrubber) RecordRedactions(ctx context.Context, projectID string, counts map[string]int64) {
+	if ps == nil || ps.Redis == nil || len(counts) == 0 {
+		return
+	}
+
+	pipe := ps.Redis.Pipeline()
+	key := ProjectKey(projectID, "pii:redactions")
+	for field, count := range counts {
+		pipe.HIncrBy(ctx, key, field, count)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("记录脱敏计数失败: %v", err)
+	}
+}
+
+// GetRedactionCounts 获取项目各字段的累计脱敏次数
+func (ps *PIIScrubber) GetRedactionCounts(ctx context.Context, projectID string) map[string]int64 {
+	counts := make(map[string]int64)
+	if ps == nil || ps.Redis == nil {
+		return counts
+	}
+
+	values, err := ps.Redis.HGetAll(ctx, ProjectKey(projectID, "pii:redactions")).Result()
+	if err != nil {
+		return counts
+	}
+	for field, value := range values {
+		if count, err := strconv.ParseInt(value, 10, 64); err == nil {
+			counts[field] = count
+		}
+	}
+	return counts
+}
+
+// scrubProps 递归脱敏 extra_data 中的字符串属性
+func (ps *PIIScrubber) scrubProps(prefix string, props map[string]interface{}, counts map[string]int64) {
+	for name, value := range props {
+		field := prefix + "." + name
+		switch v := value.(type) {
+		case string:
+			if ps.hashFields[field] && v != "" {
+				props[name] = ps.hash(v)
+				counts[field]++
+				continue
+			}
+			ps.scrubString(field, &v, looksLikeURL(v), counts)
+			props[name] = v
+		case map[string]interface{}:
+			ps.scrubProps(field, v, counts)
+		case []interface{}:
+			for i, item := range v {
+				if s, ok := item.(string); ok {
+					ps.scrubString(field, &s, looksLikeURL(s), counts)
+					v[i] = s
+				}
+			}
+		}
+	}
+}
+
+// scrubString 对字符串做查询参数脱敏（isURL 时）和正则脱敏
+func (ps *PIIScrubber) scrubString(field string, value *string, isURL bool, counts map[string]int64) {
+	if *value == "" {
+		return
+	}
+
+	if isURL && len(ps.denyParams) > 0 {
+		if scrubbed, n := ps.scrubQueryParams(*value); n > 0 {
+			*value = scrubbed
+			counts[field] += int64(n)
+		}
+	}
+
+	for _, re := range ps.patterns {
+		matches := 0
+		*value = re.ReplaceAllStringFunc(*value, func(string) string {
+			matches++
+			return redactedValue
+		})
+		counts[field] += int64(matches)
+	}
+	if counts[field] == 0 {
+		delete(counts, field)
+	}
+}
+
+// scrubQueryParams 替换URL中禁止的查询参数的值，返回新URL和替换个数
+func (ps *PIIScrubber) scrubQueryParams(rawURL string) (string, int) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.RawQuery == "" {
+		return rawURL, 0
+	}
+
+	// 逐段处理，保留参数原有顺序和编码
+	replaced := 0
+	parts := strings.Split(parsed.RawQuery, "&")
+	for i, part := range parts {
+		name, _, hasValue := strings.Cut(part, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		if hasValue && ps.denyParams[strings.ToLower(name)] {
+			parts[i] = part[:strings.IndexByte(part, '=')+1] + url.QueryEscape(redactedValue)
+			replaced++
+		}
+	}
+	if replaced == 0 {
+		return rawURL, 0
+	}
+
+	parsed.RawQuery = strings.Join(parts, "&")
+	return parsed.String(), replaced
+}
+
+// hash 加盐SHA-256哈希
+func (ps *PIIScrubber) hash(value string) string {
+	sum := sha256.Sum256([]byte(ps.hashSalt + value))
+	return hex.EncodeToString(sum[:])
+}
+
+// looksLikeURL 判断字符串是否为带查询参数的URL
+func looksLikeURL(s string) bool {
+	return strings.Contains(s, "?") && (strings.Contains(s, "://") || strings.HasPrefix(s, "/"))
+}
+
+// truncateIP 截断IP地址：IPv4保留前24位，IPv6保留前48位（无法解析时原样返回）
+func truncateIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,656 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"insightflow/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 用户数据删除与导出相关错误
+var (
+	ErrPrivacyJobNotFound = errors.New("任务不存在")
+	ErrExportNotReady     = errors.New("导出文件不可用（任务未完成或文件已过期）")
+)
+
+// 审计日志操作
+const (
+	AuditEraseRequested  = "erase_requested"
+	AuditEraseCompleted  = "erase_completed"
+	AuditExportRequested = "export_requested"
+	AuditExportCompleted = "export_completed"
+	AuditExportDownload  = "export_downloaded"
+	AuditExportExpired   = "export_expired"
+	AuditJobFailed       = "job_failed"
+)
+
+// 任务处理参数
+const (
+	privacyDeleteBatch  = 5000      // 分批删除事件，避免长事务锁表
+	privacyJobBatch     = 10        // 每轮处理的任务数
+	privacyStaleRunning = time.Hour // 处理中超过该时间的任务视为实例中断，重新处理
+)
+
+// PrivacyService 用户数据删除与导出服务（GDPR）
+// 请求只创建任务，由后台协程异步处理；每一步操作写入审计日志。
+// 删除覆盖身份组内的全部ID：MySQL 的 user_events、users、user_identities，
+// Redis 的会话、在线用户、身份缓存和用户事件缓存，以及该用户此前的导出文件。
+// Kafka 和本地spool中尚未消费的事件无法就地删除，删除时为每个ID写入墓碑，
+// 消费时丢弃时间早于删除时间的事件
+type PrivacyService struct {
+	DB             *sql.DB
+	Redis          *redis.Client
+	Identity       *IdentityService
+	Profiles       *UserProfileService
+	ServiceManager *ServiceManager
+
+	ExportDir        string        // 导出文件目录
+	ExportTTL        time.Duration // 导出文件保留时间
+	ErasureTombstone time.Duration // 删除墓碑保留时间
+
+	notify chan struct{}
+}
+
+// NewPrivacyService 创建用户数据删除与导出服务
+func NewPrivacyService(db *sql.DB, redis *redis.Client, identity *IdentityService, profiles *UserProfileService, serviceManager *ServiceManager) *PrivacyService {
+	return &PrivacyService{
+		DB:               db,
+		Redis:            redis,
+		Identity:         identity,
+		Profiles:         profiles,
+		ServiceManager:   serviceManager,
+		ExportDir:        "./data/exports",
+		ExportTTL:        7 * 24 * time.Hour,
+		ErasureTombstone: 7 * 24 * time.Hour,
+		notify:           make(chan struct{}, 1),
+	}
+}
+
+// erasedUserKey 删除墓碑键（值为删除时间，Unix毫秒）
+func erasedUserKey(projectID, userID string) string {
+	return ProjectKey(projectID, "erased:"+userID)
+}
+
+// IsUserErased 判断用户是否已删除且仍在墓碑有效期内（Redis不可用时按未删除处理）
+// 直接修改用户数据的接口（如用户属性）在此期间拒绝请求，避免重建已删除的用户记录
+func IsUserErased(ctx context.Context, rdb *redis.Client, projectID, userID string) bool {
+	if userID == "" {
+		return false
+	}
+	exists, err := rdb.Exists(ctx, erasedUserKey(projectID, userID)).Result()
+	return err == nil && exists > 0
+}
+
+// IsErased 判断事件是否属于已删除用户且发生在删除之前（Redis不可用时按未删除处理）
+func IsErased(ctx context.Context, rdb *redis.Client, projectID, userID string, timestamp int64) bool {
+	if userID == "" {
+		return false
+	}
+	erasedAt, err := rdb.Get(ctx, erasedUserKey(projectID, userID)).Int64()
+	if err != nil {
+		return false
+	}
+	return timestamp <= erasedAt
+}
+
+// Submit 创建删除或导出任务
+func (ps *PrivacyService) Submit(ctx context.Context, projectID, userID, jobType, actor string) (*models.PrivacyJob, error) {
+	if userID == "" {
+		return nil, ErrInvalidIdentity
+	}
+
+	jobID, err := generateKey("job_")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ps.DB.ExecContext(ctx, `
+		INSERT INTO privacy_jobs (job_id, project_id, user_id, job_type, status) VALUES (?, ?, ?, ?, ?)`,
+		jobID, projectID, userID, jobType, models.PrivacyJobPending); err != nil {
+		return nil, err
+	}
+
+	action := AuditEraseRequested
+	if jobType == models.PrivacyJobExport {
+		action = AuditExportRequested
+	}
+	ps.audit(ctx, projectID, jobID, userID, action, actor, nil)
+
+	// 唤醒后台协程立即处理（已有待处理信号时不阻塞）
+	select {
+	case ps.notify <- struct{}{}:
+	default:
+	}
+
+	return ps.GetJob(ctx, projectID, jobID)
+}
+
+// GetJob 查询任务状态
+func (ps *PrivacyService) GetJob(ctx context.Context, projectID, jobID string) (*models.PrivacyJob, error) {
+	var job models.PrivacyJob
+	var result []byte
+	var filePath, errorMessage sql.NullString
+	var createdAt time.Time
+	var startedAt, completedAt sql.NullTime
+
+	err := ps.DB.QueryRowContext(ctx, `
+		SELECT job_id, project_id, user_id, job_type, status, result, file_path, error_message,
+		       created_at, started_at, completed_at
+		FROM privacy_jobs WHERE job_id = ? AND project_id = ?`, jobID, projectID).Scan(
+		&job.JobID, &job.ProjectID, &job.UserID, &job.JobType, &job.Status, &result,
+		&filePath, &errorMessage, &createdAt, &startedAt, &completedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrPrivacyJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result) > 0 {
+		if err := json.Unmarshal(result, &job.Result); err != nil {
+			log.Printf("解析任务结果失败: %s, %v", jobID, err)
+		}
+	}
+	job.FilePath = filePath.String
+	job.Error = errorMessage.String
+	job.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+	if startedAt.Valid {
+		job.StartedAt = startedAt.Time.Format("2006-01-02 15:04:05")
+	}
+	if completedAt.Valid {
+		job.CompletedAt = completedAt.Time.Format("2006-01-02 15:04:05")
+	}
+	return &job, nil
+}
+
+// ExportFile 获取已完成导出任务的文件路径，并记录下载审计
+func (ps *PrivacyService) ExportFile(ctx context.Context, projectID, jobID, actor string) (string, error) {
+	job, err := ps.GetJob(ctx, projectID, jobID)
+	if err != nil {
+		return "", err
+	}
+	if job.JobType != models.PrivacyJobExport || job.Status != models.PrivacyJobCompleted || job.FilePath == "" {
+		return "", ErrExportNotReady
+	}
+	if _, err := os.Stat(job.FilePath); err != nil {
+		return "", ErrExportNotReady
+	}
+
+	ps.audit(ctx, projectID, jobID, job.UserID, AuditExportDownload, actor, nil)
+	return job.FilePath, nil
+}
+
+// Start 启动后台任务处理协程（按间隔轮询，创建任务时立即唤醒）
+func (ps *PrivacyService) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		ps.runPending(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ps.notify:
+			case <-ticker.C:
+				ps.cleanupExports(ctx)
+			}
+			ps.runPending(ctx)
+		}
+	}()
+}
+
+// runPending 处理待执行的任务（多实例部署时通过状态更新抢占，同一任务只由一个实例处理）
+func (ps *PrivacyService) runPending(ctx context.Context) {
+	// 处理中超时的任务（实例在处理过程中退出）重新排队；删除和导出都可以安全重复执行
+	if _, err := ps.DB.ExecContext(ctx, `
+		UPDATE privacy_jobs SET status = ? WHERE status = ? AND started_at < NOW() - INTERVAL ? SECOND`,
+		models.PrivacyJobPending, models.PrivacyJobRunning, int64(privacyStaleRunning.Seconds())); err != nil {
+		log.Printf("重置超时任务失败: %v", err)
+	}
+
+	for ctx.Err() == nil {
+		rows, err := ps.DB.QueryContext(ctx, `
+			SELECT job_id, project_id FROM privacy_jobs WHERE status = ? ORDER BY created_at LIMIT ?`,
+			models.PrivacyJobPending, privacyJobBatch)
+		if err != nil {
+			log.Printf("查询待处理任务失败: %v", err)
+			return
+		}
+
+		type pendingJob struct{ jobID, projectID string }
+		var pending []pendingJob
+		for rows.Next() {
+			var job pendingJob
+			if err := rows.Scan(&job.jobID, &job.projectID); err == nil {
+				pending = append(pending, job)
+			}
+		}
+		rows.Close()
+		if len(pending) == 0 {
+			return
+		}
+
+		processed := 0
+		for _, p := range pending {
+			result, err := ps.DB.ExecContext(ctx, `
+				UPDATE privacy_jobs SET status = ?, started_at = NOW() WHERE job_id = ? AND status = ?`,
+				models.PrivacyJobRunning, p.jobID, models.PrivacyJobPending)
+			if err != nil {
+				log.Printf("领取任务失败: %s, %v", p.jobID, err)
+				continue
+			}
+			if claimed, _ := result.RowsAffected(); claimed == 0 {
+				continue
+			}
+
+			job, err := ps.GetJob(ctx, p.projectID, p.jobID)
+			if err != nil {
+				log.Printf("读取任务失败: %s, %v", p.jobID, err)
+				continue
+			}
+			ps.runJob(ctx, job)
+			processed++
+		}
+		// 本轮没有成功处理任何任务（数据库异常等），等下次轮询再试
+		if processed == 0 {
+			return
+		}
+	}
+}
+
+// runJob 执行单个任务并记录结果
+func (ps *PrivacyService) runJob(ctx context.Context, job *models.PrivacyJob) {
+	var counts map[string]int64
+	var filePath string
+	var err error
+
+	switch job.JobType {
+	case models.PrivacyJobErase:
+		counts, err = ps.eraseUser(ctx, job.ProjectID, job.UserID)
+	case models.PrivacyJobExport:
+		counts, filePath, err = ps.exportUser(ctx, job)
+	default:
+		err = fmt.Errorf("未知任务类型: %s", job.JobType)
+	}
+
+	if err != nil {
+		log.Printf("用户数据任务失败: [%s] %s %s, %v", job.ProjectID, job.JobType, job.JobID, err)
+		message := truncateRunes(err.Error(), 512)
+		if _, dbErr := ps.DB.ExecContext(ctx, `
+			UPDATE privacy_jobs SET status = ?, error_message = ?, completed_at = NOW() WHERE job_id = ?`,
+			models.PrivacyJobFailed, message, job.JobID); dbErr != nil {
+			log.Printf("更新任务状态失败: %s, %v", job.JobID, dbErr)
+		}
+		ps.audit(ctx, job.ProjectID, job.JobID, job.UserID, AuditJobFailed, "system", map[string]string{"error": message})
+		return
+	}
+
+	result, _ := json.Marshal(counts)
+	if _, err := ps.DB.ExecContext(ctx, `
+		UPDATE privacy_jobs SET status = ?, result = ?, file_path = ?, completed_at = NOW() WHERE job_id = ?`,
+		models.PrivacyJobCompleted, string(result), nullString(filePath), job.JobID); err != nil {
+		log.Printf("更新任务状态失败: %s, %v", job.JobID, err)
+	}
+
+	action := AuditEraseCompleted
+	if job.JobType == models.PrivacyJobExport {
+		action = AuditExportCompleted
+	}
+	ps.audit(ctx, job.ProjectID, job.JobID, job.UserID, action, "system", counts)
+	log.Printf("用户数据任务完成: [%s] %s %s %v", job.ProjectID, job.JobType, job.JobID, counts)
+}
+
+// eraseUser 删除用户身份组内所有ID的数据，返回各数据源删除的记录数
+func (ps *PrivacyService) eraseUser(ctx context.Context, projectID, userID string) (map[string]int64, error) {
+	userIDs, err := ps.Identity.Group(ctx, projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{"user_ids": int64(len(userIDs))}
+
+	// 先写墓碑，删除过程中及之后才消费到的旧事件不会重新写入
+	erasedAt := time.Now().UnixMilli()
+	pipe := ps.Redis.Pipeline()
+	for _, id := range userIDs {
+		pipe.Set(ctx, erasedUserKey(projectID, id), erasedAt, ps.ErasureTombstone)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("写入删除墓碑失败: %w", err)
+	}
+
+	sessionIDs, err := ps.sessionIDs(ctx, projectID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	in, args := inClause(projectID, userIDs)
+	for {
+		result, err := ps.DB.ExecContext(ctx, `DELETE FROM user_events WHERE project_id = ? AND user_id IN `+in+` LIMIT ?`,
+			append(args, privacyDeleteBatch)...)
+		if err != nil {
+			return nil, err
+		}
+		deleted, _ := result.RowsAffected()
+		counts["events"] += deleted
+		if deleted < privacyDeleteBatch {
+			break
+		}
+	}
+
+	result, err := ps.DB.ExecContext(ctx, `DELETE FROM users WHERE project_id = ? AND user_id IN `+in, args...)
+	if err != nil {
+		return nil, err
+	}
+	counts["users"], _ = result.RowsAffected()
+
+	result, err = ps.DB.ExecContext(ctx, `DELETE FROM user_identities WHERE project_id = ? AND user_id IN `+in, args...)
+	if err != nil {
+		return nil, err
+	}
+	counts["identities"], _ = result.RowsAffected()
+
+	// Redis：会话、在线用户、身份缓存、用户事件缓存
+	cacheService := ps.ServiceManager.GetCacheService()
+	keys := make([]string, 0, len(sessionIDs)+2*len(userIDs))
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, ProjectKey(projectID, "session:"+sessionID))
+	}
+	for _, id := range userIDs {
+		keys = append(keys, ProjectKey(projectID, "identity:"+id))
+		keys = append(keys, ProjectKey(projectID, cacheService.GenerateCacheKey("user_events", id)))
+	}
+	members := make([]interface{}, len(userIDs))
+	for i, id := range userIDs {
+		members[i] = id
+	}
+
+	pipe = ps.Redis.Pipeline()
+	var sessions *redis.IntCmd
+	if len(sessionIDs) > 0 {
+		sessions = pipe.Del(ctx, keys[:len(sessionIDs)]...)
+	}
+	pipe.Del(ctx, keys[len(sessionIDs):]...)
+	pipe.SRem(ctx, ProjectKey(projectID, "online_users"), members...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("删除Redis数据失败: %w", err)
+	}
+	if sessions != nil {
+		counts["sessions"] = sessions.Val()
+	}
+
+	exports, err := ps.removeExports(ctx, projectID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	counts["exports"] = exports
+
+	return counts, nil
+}
+
+// removeExports 删除用户此前生成的导出文件
+func (ps *PrivacyService) removeExports(ctx context.Context, projectID string, userIDs []string) (int64, error) {
+	in, args := inClause(projectID, userIDs)
+	rows, err := ps.DB.QueryContext(ctx, `
+		SELECT job_id, file_path FROM privacy_jobs
+		WHERE project_id = ? AND user_id IN `+in+` AND job_type = 'export' AND file_path IS NOT NULL`, args...)
+	if err != nil {
+		return 0, err
+	}
+	files := make(map[string]string)
+	for rows.Next() {
+		var jobID, filePath string
+		if err := rows.Scan(&jobID, &filePath); err == nil {
+			files[jobID] = filePath
+		}
+	}
+	rows.Close()
+
+	var removed int64
+	for jobID, filePath := range files {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		if _, err := ps.DB.ExecContext(ctx, `UPDATE privacy_jobs SET file_path = NULL WHERE job_id = ?`, jobID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// exportUser 将用户身份组内所有ID的数据导出为JSON文件，返回各数据源导出的记录数和文件路径
+func (ps *PrivacyService) exportUser(ctx context.Context, job *models.PrivacyJob) (map[string]int64, string, error) {
+	userIDs, err := ps.Identity.Group(ctx, job.ProjectID, job.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	counts := map[string]int64{"user_ids": int64(len(userIDs))}
+
+	profile, err := ps.Profiles.GetProfile(ctx, job.ProjectID, userIDs[0])
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return nil, "", err
+	}
+	if profile != nil {
+		counts["users"] = 1
+	}
+
+	sessionIDs, err := ps.sessionIDs(ctx, job.ProjectID, userIDs)
+	if err != nil {
+		return nil, "", err
+	}
+	sessions := make([]map[string]string, 0)
+	for _, sessionID := range sessionIDs {
+		values, err := ps.Redis.HGetAll(ctx, ProjectKey(job.ProjectID, "session:"+sessionID)).Result()
+		if err == nil && len(values) > 0 {
+			values["session_id"] = sessionID
+			sessions = append(sessions, values)
+		}
+	}
+	counts["sessions"] = int64(len(sessions))
+
+	if err := os.MkdirAll(ps.ExportDir, 0o700); err != nil {
+		return nil, "", err
+	}
+	filePath := filepath.Join(ps.ExportDir, job.JobID+".json")
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	// 事件数量可能很大，逐行写入，不整体放入内存
+	w := bufio.NewWriter(file)
+	header, err := json.Marshal(map[string]interface{}{
+		"project_id":   job.ProjectID,
+		"user_id":      job.UserID,
+		"canonical_id": userIDs[0],
+		"identities":   userIDs,
+		"profile":      profile,
+		"sessions":     sessions,
+		"exported_at":  time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	w.Write(header[:len(header)-1])
+	w.WriteString(`,"events":[`)
+
+	events, err := ps.writeEvents(ctx, w, job.ProjectID, userIDs)
+	if err != nil {
+		os.Remove(filePath)
+		return nil, "", err
+	}
+	counts["events"] = events
+
+	w.WriteString("]}\n")
+	if err := w.Flush(); err != nil {
+		os.Remove(filePath)
+		return nil, "", err
+	}
+	if err := file.Sync(); err != nil {
+		os.Remove(filePath)
+		return nil, "", err
+	}
+	return counts, filePath, nil
+}
+
+// exportNumericColumns 导出时按数值输出的列
+var exportNumericColumns = map[string]bool{"position_x": true, "position_y": true, "timestamp": true}
+
+// writeEvents 按时间顺序写出用户的全部事件（逗号分隔的JSON对象），返回事件数
+func (ps *PrivacyService) writeEvents(ctx context.Context, w *bufio.Writer, projectID string, userIDs []string) (int64, error) {
+	in, args := inClause(projectID, userIDs)
+	rows, err := ps.DB.QueryContext(ctx, `
+		SELECT event_id, user_id, session_id, event_type, page_url, page_title, element, element_text,
+		       element_id, element_class, position_x, position_y, user_agent, device_type, browser,
+		       browser_version, os, os_version, ip_address, country, region, city, timestamp, source,
+		       extra_data, created_at
+		FROM user_events WHERE project_id = ? AND user_id IN `+in+` ORDER BY timestamp, id`, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	var count int64
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return count, err
+		}
+
+		event := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if values[i] == nil {
+				continue
+			}
+			switch {
+			case column == "extra_data":
+				event[column] = json.RawMessage(append([]byte(nil), values[i]...))
+			case exportNumericColumns[column]:
+				if n, err := strconv.ParseInt(string(values[i]), 10, 64); err == nil {
+					event[column] = n
+				}
+			default:
+				event[column] = string(values[i])
+			}
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return count, err
+		}
+		if count > 0 {
+			w.WriteByte(',')
+		}
+		w.Write(data)
+		count++
+	}
+	return count, rows.Err()
+}
+
+// cleanupExports 删除过期的导出文件
+func (ps *PrivacyService) cleanupExports(ctx context.Context) {
+	if ps.ExportTTL <= 0 {
+		return
+	}
+
+	rows, err := ps.DB.QueryContext(ctx, `
+		SELECT job_id, project_id, user_id, file_path FROM privacy_jobs
+		WHERE job_type = 'export' AND file_path IS NOT NULL AND completed_at < NOW() - INTERVAL ? SECOND`,
+		int64(ps.ExportTTL.Seconds()))
+	if err != nil {
+		log.Printf("查询过期导出文件失败: %v", err)
+		return
+	}
+	var expired []models.PrivacyJob
+	for rows.Next() {
+		var job models.PrivacyJob
+		if err := rows.Scan(&job.JobID, &job.ProjectID, &job.UserID, &job.FilePath); err == nil {
+			expired = append(expired, job)
+		}
+	}
+	rows.Close()
+
+	for _, job := range expired {
+		if err := os.Remove(job.FilePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("删除过期导出文件失败: %s, %v", job.FilePath, err)
+			continue
+		}
+		if _, err := ps.DB.ExecContext(ctx, `UPDATE privacy_jobs SET file_path = NULL WHERE job_id = ?`, job.JobID); err != nil {
+			log.Printf("更新导出任务失败: %s, %v", job.JobID, err)
+			continue
+		}
+		ps.audit(ctx, job.ProjectID, job.JobID, job.UserID, AuditExportExpired, "system", nil)
+	}
+}
+
+// sessionIDs 查询用户出现过的会话ID
+func (ps *PrivacyService) sessionIDs(ctx context.Context, projectID string, userIDs []string) ([]string, error) {
+	in, args := inClause(projectID, userIDs)
+	rows, err := ps.DB.QueryContext(ctx, `
+		SELECT DISTINCT session_id FROM user_events WHERE project_id = ? AND user_id IN `+in, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessionIDs := make([]string, 0)
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err == nil && sessionID != "" {
+			sessionIDs = append(sessionIDs, sessionID)
+		}
+	}
+	return sessionIDs, rows.Err()
+}
+
+// audit 写入审计日志（失败只记录日志，不影响任务）
+func (ps *PrivacyService) audit(ctx context.Context, projectID, jobID, userID, action, actor string, details interface{}) {
+	var detailJSON sql.NullString
+	if details != nil {
+		if data, err := json.Marshal(details); err == nil {
+			detailJSON = sql.NullString{String: string(data), Valid: true}
+		}
+	}
+
+	if _, err := ps.DB.ExecContext(ctx, `
+		INSERT INTO privacy_audit_log (project_id, job_id, user_id, action, actor, details) VALUES (?, ?, ?, ?, ?, ?)`,
+		projectID, jobID, userID, action, truncateRunes(actor, 256), detailJSON); err != nil {
+		log.Printf("写入审计日志失败: [%s] %s %s, %v", projectID, action, jobID, err)
+	}
+}
+
+// inClause 生成 "(?, ?, ...)" 及参数（首个参数为项目ID）
+func inClause(projectID string, ids []string) (string, []interface{}) {
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, projectID)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ")", args
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"insightflow/models"
+)
+
+// 项目相关错误
+var (
+	ErrProjectNotFound = errors.New("项目不存在或已停用")
+	ErrInvalidWriteKey = errors.New("无效的写入密钥")
+)
+
+// maxCachedWriteKeys 写入密钥本地缓存的最大条目数（防止大量随机密钥撑满内存）
+const maxCachedWriteKeys = 10000
+
+// projectContextKey 请求上下文中项目的键
+type projectContextKey struct{}
+
+// WithProject 将项目放入上下文
+func WithProject(ctx context.Context, project *models.Project) context.Context {
+	return context.WithValue(ctx, projectContextKey{}, project)
+}
+
+// ProjectFromContext 从上下文获取项目
+func ProjectFromContext(ctx context.Context) (*models.Project, bool) {
+	project, ok := ctx.Value(projectContextKey{}).(*models.Project)
+	return project, ok && project != nil
+}
+
+// ProjectIDFromContext 从上下文获取项目ID（没有时返回默认项目）
+func ProjectIDFromContext(ctx context.Context) string {
+	if project, ok := ProjectFromContext(ctx); ok {
+		return project.ID
+	}
+	return models.DefaultProjectID
+}
+
+// ProjectKey 生成项目隔离的Redis键
+// 默认项目沿用原有键名，兼容多项目改造前的历史数据
+func ProjectKey(projectID, key string) string {
+	if projectID == "" || projectID == models.DefaultProjectID {
+		return key
+	}
+	return "project:" + projectID + ":" + key
+}
+
+// cachedProject 项目本地缓存条目
+type cachedProject struct {
+	project  *models.Project
+	expireAt time.Time
+}
+
+// ProjectService 项目与密钥管理服务
+type ProjectService struct {
+	DB *sql.DB
+
+	mu       sync.RWMutex
+	byKey    map[string]cachedProject
+	cacheTTL time.Duration
+}
+
+// NewProjectService 创建项目服务
+func NewProjectService(db *sql.DB) *ProjectService {
+	return &ProjectService{
+		DB:       db,
+		byKey:    make(map[string]cachedProject),
+		cacheTTL: time.Minute,
+	}
+}
+
+// GetByWriteKey 根据写入密钥获取项目（带本地缓存）
+func (ps *ProjectService) GetByWriteKey(writeKey string) (*models.Project, error) {
+	if writeKey == "" {
+		return nil, ErrInvalidWriteKey
+	}
+
+	ps.mu.RLock()
+	cached, ok := ps.byKey[writeKey]
+	ps.mu.RUnlock()
+	if ok && time.Now().Before(cached.expireAt) {
+		if cached.project == nil {
+			return nil, ErrInvalidWriteKey
+		}
+		return cached.project, nil
+	}
+
+	project, err := ps.queryProject("write_key = ?", writeKey)
+	if err != nil && err != ErrProjectNotFound {
+		return nil, err
+	}
+
+	ps.cacheWriteKey(writeKey, project)
+
+	if project == nil {
+		return nil, ErrInvalidWriteKey
+	}
+	return project, nil
+}
+
+// cacheWriteKey 缓存写入密钥的查询结果
+// 无效密钥同样缓存，避免被反复打到数据库；缓存满时先清理过期条目，仍然满时不再缓存无效密钥，
+// 有效密钥随机淘汰一条已有条目
+func (ps *ProjectService) cacheWriteKey(writeKey string, project *models.Project) {
+	now := time.Now()
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, ok := ps.byKey[writeKey]; !ok && len(ps.byKey) >= maxCachedWriteKeys {
+		for key, cached := range ps.byKey {
+			if !now.Before(cached.expireAt) {
+				delete(ps.byKey, key)
+			}
+		}
+		if len(ps.byKey) >= maxCachedWriteKeys {
+			if project == nil {
+				return
+			}
+			for key := range ps.byKey {
+				delete(ps.byKey, key)
+				break
+			}
+		}
+	}
+	ps.byKey[writeKey] = cachedProject{project: project, expireAt: now.Add(ps.cacheTTL)}
+}
+
+// GetByID 根据项目ID获取项目
+func (ps *ProjectService) GetByID(projectID string) (*models.Project, error) {
+	return ps.queryProject("id = ?", projectID)
+}
+
+// CreateProject 创建项目并生成写入密钥、服务端密钥和读取密钥
+// monthlyQuota 为每月事件配额，0 表示不限制
+func (ps *ProjectService) CreateProject(projectID, name string, monthlyQuota int64) (*models.Project, error) {
+	writeKey, err := generateKey("wk_")
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := generateKey("sk_")
+	if err != nil {
+		return nil, err
+	}
+	readKey, err := generateKey("rk_")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ps.DB.Exec(`
+		INSERT INTO projects (id, name, write_key, secret_key, read_key, monthly_event_quota, is_active)
+		VALUES (?, ?, ?, ?, ?, ?, TRUE)
+	`, projectID, name, writeKey, secretKey, readKey, monthlyQuota)
+	if err != nil {
+		return nil, err
+	}
+
+	return ps.GetByID(projectID)
+}
+
+// RotateWriteKey 轮换项目写入密钥（旧密钥在本地缓存过期后失效）
+func (ps *ProjectService) RotateWriteKey(projectID string) (*models.Project, error) {
+	writeKey, err := generateKey("wk_")
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ps.DB.Exec(`UPDATE projects SET write_key = ? WHERE id = ?`, writeKey, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrProjectNotFound
+	}
+
+	return ps.GetByID(projectID)
+}
+
+// RotateSecretKey 轮换项目服务端密钥（立即生效）
+func (ps *ProjectService) RotateSecretKey(projectID string) (*models.Project, error) {
+	secretKey, err := generateKey("sk_")
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ps.DB.Exec(`UPDATE projects SET secret_key = ? WHERE id = ?`, secretKey, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrProjectNotFound
+	}
+
+	return ps.GetByID(projectID)
+}
+
+// RotateReadKey 轮换项目读取密钥（立即生效）
+func (ps *ProjectService) RotateReadKey(projectID string) (*models.Project, error) {
+	readKey, err := generateKey("rk_")
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ps.DB.Exec(`UPDATE projects SET read_key = ? WHERE id = ?`, readKey, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrProjectNotFound
+	}
+
+	return ps.GetByID(projectID)
+}
+
+// queryProject 查询单个启用的项目
+func (ps *ProjectService) queryProject(condition string, arg interface{}) (*models.Project, error) {
+	var project models.Project
+	var createdAt time.Time
+	err := ps.DB.QueryRow(`
+		SELECT id, name, write_key, COALESCE(secret_key, ''), COALESCE(read_key, ''), monthly_event_quota, is_active, created_at
+		FROM projects WHERE `+condition+` AND is_active = TRUE`, arg).Scan(
+		&project.ID,
+		&project.Name,
+		&project.WriteKey,
+		&project.SecretKey,
+		&project.ReadKey,
+		&project.MonthlyEventQuota,
+		&project.IsActive,
+		&createdAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	project.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+	return &project, nil
+}
+
+// generateKey 生成随机密钥
+func generateKey(prefix string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript 令牌桶限流脚本（原子执行，多个桶全部允许时才同时扣减）
+// KEYS: 各个桶的键  ARGV: 当前时间(毫秒), 本次消耗令牌数, 然后依次为每个桶的 每秒补充令牌数, 桶容量
+// 返回: {是否允许(1/0), 需要等待的毫秒数, 拒绝的桶序号(从1开始，允许时为0)}
+// 消耗量大于桶容量的批次在桶满时放行并按实际数量扣减（令牌数变为负数），之后的请求需等待令牌补足
+var tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+
+local buckets = {}
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[1 + i * 2])
+	local burst = tonumber(ARGV[2 + i * 2])
+
+	local data = redis.call('HMGET', key, 'tokens', 'ts')
+	local tokens = tonumber(data[1]) or burst
+	local ts = tonumber(data[2]) or now
+	tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+	local need = math.min(cost, burst)
+	if tokens < need then
+		return {0, math.ceil((need - tokens) * 1000 / rate), i}
+	end
+	buckets[i] = {key, rate, burst, tokens - cost}
+end
+
+for _, bucket in ipairs(buckets) do
+	local key, rate, burst, tokens = bucket[1], bucket[2], bucket[3], bucket[4]
+	redis.call('HSET', key, 'tokens', tostring(tokens), 'ts', now)
+	redis.call('PEXPIRE', key, math.ceil((burst - tokens) * 1000 / rate) + 1000)
+end
+return {1, 0, 0}
+`)
+
+// RateLimit 令牌桶参数
+type RateLimit struct {
+	Rate  float64 // 每秒补充的令牌数（事件数）
+	Burst int64   // 桶容量（允许的突发事件数）
+}
+
+// Enabled 是否启用限流
+func (rl RateLimit) Enabled() bool {
+	return rl.Rate > 0 && rl.Burst > 0
+}
+
+// RateLimitResult 限流检查结果
+type RateLimitResult struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	Reason     string // 被拒绝的原因：project_rate, ip_rate, monthly_quota
+}
+
+// RateLimiter 基于Redis的事件上报限流与配额服务
+type RateLimiter struct {
+	Redis        *redis.Client
+	ProjectLimit RateLimit
+	IPLimit      RateLimit
+}
+
+// NewRateLimiter 创建限流服务
+func NewRateLimiter(redis *redis.Client, projectLimit, ipLimit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		Redis:        redis,
+		ProjectLimit: projectLimit,
+		IPLimit:      ipLimit,
+	}
+}
+
+// AllowEvents 检查一批事件是否允许上报（项目令牌桶、IP令牌桶、项目月度配额）
+// Redis异常时放行，避免限流组件故障导致数据丢失
+func (rl *RateLimiter) AllowEvents(ctx context.Context, projectID, clientIP string, count int, monthlyQuota int64) RateLimitResult {
+	if count <= 0 {
+		return RateLimitResult{Allowed: true}
+	}
+
+	// 项目和IP令牌桶一起检查，IP被拒绝时不消耗项目的令牌
+	var buckets []tokenBucket
+	if rl.ProjectLimit.Enabled() {
+		buckets = append(buckets, tokenBucket{ProjectKey(projectID, "ratelimit:project"), rl.ProjectLimit, "project_rate"})
+	}
+	if rl.IPLimit.Enabled() && clientIP != "" {
+		buckets = append(buckets, tokenBucket{ProjectKey(projectID, "ratelimit:ip:"+clientIP), rl.IPLimit, "ip_rate"})
+	}
+	if result := rl.takeTokens(ctx, buckets, count); !result.Allowed {
+		rl.recordRejected(ctx, projectID, result.Reason, count)
+		return result
+	}
+
+	if monthlyQuota > 0 {
+		if result := rl.consumeMonthlyQuota(ctx, projectID, count, monthlyQuota); !result.Allowed {
+			result.Reason = "monthly_quota"
+			rl.recordRejected(ctx, projectID, result.Reason, count)
+			return result
+		}
+	}
+
+	return RateLimitResult{Allowed: true}
+}
+
+// GetMonthlyUsage 获取项目本月已使用的事件配额
+func (rl *RateLimiter) GetMonthlyUsage(ctx context.Context, projectID string) int64 {
+	usage, err := rl.Redis.Get(ctx, monthlyQuotaKey(projectID, time.Now())).Int64()
+	if err != nil {
+		return 0
+	}
+	return usage
+}
+
+// GetRejectedCounts 获取各原因被拒绝的事件数
+func (rl *RateLimiter) GetRejectedCounts(ctx context.Context, projectID string) map[string]int64 {
+	values, err := rl.Redis.HGetAll(ctx, ProjectKey(projectID, "ratelimit:rejected_events")).Result()
+	counts := make(map[string]int64, len(values))
+	if err != nil {
+		return counts
+	}
+	for reason, value := range values {
+		if count, err := strconv.ParseInt(value, 10, 64); err == nil {
+			counts[reason] = count
+		}
+	}
+	return counts
+}
+
+// tokenBucket 参与限流检查的令牌桶
+type tokenBucket struct {
+	key    string
+	limit  RateLimit
+	reason string // 该桶拒绝时的原因
+}
+
+// takeTokens 从所有令牌桶中按事件数取出令牌（任一桶不足时都不扣减）
+func (rl *RateLimiter) takeTokens(ctx context.Context, buckets []tokenBucket, count int) RateLimitResult {
+	if len(buckets) == 0 {
+		return RateLimitResult{Allowed: true}
+	}
+
+	keys := make([]string, 0, len(buckets))
+	args := []interface{}{time.Now().UnixMilli(), count}
+	for _, bucket := range buckets {
+		keys = append(keys, bucket.key)
+		args = append(args, bucket.limit.Rate, bucket.limit.Burst)
+	}
+
+	values, err := tokenBucketScript.Run(ctx, rl.Redis, keys, args...).Int64Slice()
+	if err != nil || len(values) != 3 {
+		log.Printf("限流检查失败，放行: %v", err)
+		return RateLimitResult{Allowed: true}
+	}
+	if values[0] == 1 {
+		return RateLimitResult{Allowed: true}
+	}
+
+	result := RateLimitResult{RetryAfter: time.Duration(values[1]) * time.Millisecond}
+	if index := int(values[2]) - 1; index >= 0 && index < len(buckets) {
+		result.Reason = buckets[index].reason
+	}
+	return result
+}
+
+// consumeMonthlyQuota 消耗项目月度配额，超出时回滚并返回距下个月的等待时间
+func (rl *RateLimiter) consumeMonthlyQuota(ctx context.Context, projectID string, count int, quota int64) RateLimitResult {
+	now := time.Now()
+	key := monthlyQuotaKey(projectID, now)
+
+	used, err := rl.Redis.IncrBy(ctx, key, int64(count)).Result()
+	if err != nil {
+		log.Printf("配额检查失败，放行: %v", err)
+		return RateLimitResult{Allowed: true}
+	}
+
+	nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+	if used == int64(count) {
+		rl.Redis.ExpireAt(ctx, key, nextMonth.Add(24*time.Hour))
+	}
+
+	if used > quota {
+		rl.Redis.DecrBy(ctx, key, int64(count))
+		return RateLimitResult{Allowed: false, RetryAfter: time.Until(nextMonth)}
+	}
+	return RateLimitResult{Allowed: true}
+}
+
+// recordRejected 记录被限流拒绝的事件数（按原因统计）
+func (rl *RateLimiter) recordRejected(ctx context.Context, projectID, reason string, count int) {
+	rl.Redis.HIncrBy(ctx, ProjectKey(projectID, "ratelimit:rejected_events"), reason, int64(count))
+}
+
+// monthlyQuotaKey 月度配额计数键
+func monthlyQuotaKey(projectID string, at time.Time) string {
+	return ProjectKey(projectID, "quota:"+at.Format("200601"))
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"insightflow/models"
+)
+
+// SchemaRegistry 事件Schema注册表
+// 数据来源：
+// - JSON配置文件（可选）
+// - MySQL event_schemas 表（可选，同名事件覆盖文件中的定义）
+// models中内置的事件类型始终保留，配置中的同名事件覆盖内置定义
+type SchemaRegistry struct {
+	db       *sql.DB
+	filePath string
+
+	mu       sync.RWMutex
+	schemas  map[string]models.EventSchema
+	loadedAt time.Time
+}
+
+// NewSchemaRegistry 创建事件Schema注册表
+func NewSchemaRegistry(db *sql.DB, filePath string) *SchemaRegistry {
+	return &SchemaRegistry{
+		db:       db,
+		filePath: filePath,
+		schemas:  defaultEventSchemas(),
+	}
+}
+
+// Reload 重新加载Schema（加载失败时保留旧的Schema）
+func (sr *SchemaRegistry) Reload() error {
+	schemas := defaultEventSchemas()
+
+	if sr.filePath != "" {
+		fileSchemas, err := loadSchemasFromFile(sr.filePath)
+		if err != nil {
+			return fmt.Errorf("加载Schema文件失败: %w", err)
+		}
+		for _, schema := range fileSchemas {
+			schemas[schema.EventType] = schema
+		}
+	}
+
+	if sr.db != nil {
+		dbSchemas, err := loadSchemasFromDB(sr.db)
+		if err != nil {
+			return fmt.Errorf("加载Schema数据表失败: %w", err)
+		}
+		for _, schema := range dbSchemas {
+			schemas[schema.EventType] = schema
+		}
+	}
+
+	sr.mu.Lock()
+	sr.schemas = schemas
+	sr.loadedAt = time.Now()
+	sr.mu.Unlock()
+
+	log.Printf("📚 事件Schema已加载: %d 个事件类型", len(schemas))
+	return nil
+}
+
+// StartAutoReload 定时重新加载Schema，无需重启服务
+func (sr *SchemaRegistry) StartAutoReload(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := sr.Reload(); err != nil {
+					log.Printf("定时重新加载Schema失败: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Get 获取指定事件类型的Schema
+func (sr *SchemaRegistry) Get(eventType string) (models.EventSchema, bool) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	schema, ok := sr.schemas[eventType]
+	if !ok || !schema.IsActive {
+		return models.EventSchema{}, false
+	}
+	return schema, true
+}
+
+// List 列出所有Schema（按事件类型排序）
+func (sr *SchemaRegistry) List() []models.EventSchema {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	schemas := make([]models.EventSchema, 0, len(sr.schemas))
+	for _, schema := range sr.schemas {
+		schemas = append(schemas, schema)
+	}
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemas[i].EventType < schemas[j].EventType
+	})
+	return schemas
+}
+
+// LoadedAt 获取最近一次加载时间
+func (sr *SchemaRegistry) LoadedAt() time.Time {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	return sr.loadedAt
+}
+
+// ValidateProperties 按Schema验证事件的extra_data属性
+func (sr *SchemaRegistry) ValidateProperties(event *models.UserEvent) error {
+	schema, ok := sr.Get(event.EventType)
+	if !ok {
+		return nil
+	}
+
+	props, _ := event.ExtraData.(map[string]interface{})
+	for _, prop := range schema.Properties {
+		value, exists := props[prop.Name]
+		if !exists || value == nil {
+			if prop.Required {
+				return &ValidationError{
+					Field:   "extra_data." + prop.Name,
+					Rule:    "required",
+					Code:    models.RejectCodeMissingProperty,
+					Message: fmt.Sprintf("事件%s缺少必填属性: %s", event.EventType, prop.Name),
+				}
+			}
+			continue
+		}
+
+		if !matchesPropertyType(value, prop.Type) {
+			return &ValidationError{
+				Field:   "extra_data." + prop.Name,
+				Rule:    "type_" + prop.Type,
+				Code:    models.RejectCodeInvalidProperty,
+				Message: fmt.Sprintf("属性%s类型错误，期望%s", prop.Name, prop.Type),
+			}
+		}
+
+		if len(prop.AllowedValues) > 0 && !containsValue(prop.AllowedValues, value) {
+			return &ValidationError{
+				Field:   "extra_data." + prop.Name,
+				Rule:    "allowed_values",
+				Code:    models.RejectCodeInvalidProperty,
+				Message: fmt.Sprintf("属性%s的取值不在允许范围内: %v", prop.Name, value),
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadSchemasFromFile 从JSON文件加载Schema
+func loadSchemasFromFile(path string) ([]models.EventSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file models.EventSchemaFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	return file.Schemas, nil
+}
+
+// loadSchemasFromDB 从event_schemas表加载Schema
+func loadSchemasFromDB(db *sql.DB) ([]models.EventSchema, error) {
+	rows, err := db.Query(`
+		SELECT event_type, COALESCE(description, ''), properties, is_active
+		FROM event_schemas`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schemas []models.EventSchema
+	for rows.Next() {
+		var schema models.EventSchema
+		var properties []byte
+
+		if err := rows.Scan(&schema.EventType, &schema.Description, &properties, &schema.IsActive); err != nil {
+			return nil, err
+		}
+		if len(properties) > 0 {
+			if err := json.Unmarshal(properties, &schema.Properties); err != nil {
+				log.Printf("解析事件%s的属性定义失败: %v", schema.EventType, err)
+				continue
+			}
+		}
+		schemas = append(schemas, schema)
+	}
+
+	return schemas, rows.Err()
+}
+
+// defaultEventSchemas 内置事件类型（SDK和服务端处理依赖这些类型，配置中未定义时使用）
+func defaultEventSchemas() map[string]models.EventSchema {
+	builtinTypes := []string{
+		models.EventTypeClick, models.EventTypeView, models.EventTypeScroll,
+		models.EventTypePurchase, models.EventTypeSubmit, models.EventTypeLoad, models.EventTypeExit,
+		models.EventTypeVisibilityChange, models.EventTypeIdentify, models.EventTypeUserProperties,
+	}
+
+	schemas := make(map[string]models.EventSchema, len(builtinTypes))
+	for _, eventType := range builtinTypes {
+		schemas[eventType] = models.EventSchema{EventType: eventType, IsActive: true}
+	}
+	return schemas
+}
+
+// matchesPropertyType 检查JSON值是否匹配属性类型
+func matchesPropertyType(value interface{}, propertyType string) bool {
+	switch propertyType {
+	case models.PropertyTypeString:
+		_, ok := value.(string)
+		return ok
+	case models.PropertyTypeNumber:
+		_, ok := value.(float64)
+		return ok
+	case models.PropertyTypeBoolean:
+		_, ok := value.(bool)
+		return ok
+	case models.PropertyTypeObject:
+		_, ok := value.(map[string]interface{})
+		return ok
+	case models.PropertyTypeArray:
+		_, ok := value.([]interface{})
+		return ok
+	default:
+		return true
+	}
+}
+
+// containsValue 检查值是否在允许列表中
+func containsValue(allowed []interface{}, value interface{}) bool {
+	switch value.(type) {
+	case string, float64, bool:
+	default:
+		return false // 仅支持标量取值限制
+	}
+
+	for _, candidate := range allowed {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -9,6 +9,10 @@ type ServiceManager struct {
 	CacheService   *CacheService
 	UserService    *UserService
 	StatsService   *StatsService
+	SchemaRegistry *SchemaRegistry
+	EventEnricher  *EventEnricher
+	PIIScrubber    *PIIScrubber
+	BotDetector    *BotDetector
 }
 
 // NewServiceManager 创建服务管理器（无外部依赖）
@@ -18,6 +22,7 @@ func NewServiceManager() *ServiceManager {
 		TimeService:    NewTimeService(),
 		CacheService:   NewCacheService(),
 		UserService:    NewUserService(),
+		EventEnricher:  NewEventEnricher(),
 		StatsService:   nil, // 需要后续通过SetStatsService设置
 	}
 }
@@ -29,6 +34,7 @@ func NewServiceManagerWithRedis(redis *redis.Client) *ServiceManager {
 		TimeService:    NewTimeService(),
 		CacheService:   NewCacheService(),
 		UserService:    NewUserService(),
+		EventEnricher:  NewEventEnricher(),
 	}
 	sm.StatsService = NewStatsService(redis, sm)
 	return sm
@@ -39,6 +45,27 @@ func (sm *ServiceManager) SetStatsService(redis *redis.Client) {
 	sm.StatsService = NewStatsService(redis, sm)
 }
 
+// SetSchemaRegistry 设置事件Schema注册表（验证器将由注册表驱动）
+func (sm *ServiceManager) SetSchemaRegistry(registry *SchemaRegistry) {
+	sm.SchemaRegistry = registry
+	sm.EventValidator.SetSchemaRegistry(registry)
+}
+
+// SetGeoLocator 设置IP地理位置查询（用于事件增强）
+func (sm *ServiceManager) SetGeoLocator(locator GeoLocator) {
+	sm.EventEnricher.SetGeoLocator(locator)
+}
+
+// SetPIIScrubber 设置个人敏感信息脱敏器（未设置时不脱敏）
+func (sm *ServiceManager) SetPIIScrubber(scrubber *PIIScrubber) {
+	sm.PIIScrubber = scrubber
+}
+
+// SetBotDetector 设置机器人识别器（未设置时不做判定）
+func (sm *ServiceManager) SetBotDetector(detector *BotDetector) {
+	sm.BotDetector = detector
+}
+
 // GetEventValidator 获取事件验证器
 func (sm *ServiceManager) GetEventValidator() *EventValidator {
 	return sm.EventValidator
@@ -63,3 +90,23 @@ func (sm *ServiceManager) GetUserService() *UserService {
 func (sm *ServiceManager) GetStatsService() *StatsService {
 	return sm.StatsService
 }
+
+// GetSchemaRegistry 获取事件Schema注册表
+func (sm *ServiceManager) GetSchemaRegistry() *SchemaRegistry {
+	return sm.SchemaRegistry
+}
+
+// GetEventEnricher 获取事件增强服务
+func (sm *ServiceManager) GetEventEnricher() *EventEnricher {
+	return sm.EventEnricher
+}
+
+// GetPIIScrubber 获取个人敏感信息脱敏器（未设置时为nil，Scrub 不做任何处理）
+func (sm *ServiceManager) GetPIIScrubber() *PIIScrubber {
+	return sm.PIIScrubber
+}
+
+// GetBotDetector 获取机器人识别器（未设置时为nil，ClassifyRequest 只清除客户端自带的标记）
+func (sm *ServiceManager) GetBotDetector() *BotDetector {
+	return sm.BotDetector
+}
@@ -26,11 +26,13 @@ func NewStatsService(redis *redis.Client, serviceManager *ServiceManager) *Stats
 	}
 }
 
-// GetDashboardStats 获取仪表盘统计数据
-func (ss *StatsService) GetDashboardStats(ctx context.Context) (*models.StatsResponse, error) {
+// GetDashboardStats 获取指定项目的仪表盘统计数据
+func (ss *StatsService) GetDashboardStats(ctx context.Context, projectID string) (*models.StatsResponse, error) {
+	key := func(name string) string { return ProjectKey(projectID, name) }
+
 	// 生成缓存键
 	currentHour := ss.ServiceManager.GetTimeService().GetCurrentTimeString()[:13]
-	cacheKey := ss.ServiceManager.GetCacheService().GenerateCacheKey("dashboard_stats", currentHour)
+	cacheKey := key(ss.ServiceManager.GetCacheService().GenerateCacheKey("dashboard_stats", currentHour))
 
 	// 尝试从缓存获取
 	cachedData, err := ss.Redis.Get(ctx, cacheKey).Result()
@@ -43,11 +45,11 @@ func (ss *StatsService) GetDashboardStats(ctx context.Context) (*models.StatsRes
 	}
 
 	// 缓存未命中，重新计算
-	onlineUsers := ss.Redis.SCard(ctx, "online_users").Val()
-	totalEvents := ss.parseRedisInt(ss.Redis.Get(ctx, "total_events").Val())
-	purchases := ss.parseRedisInt(ss.Redis.Get(ctx, "events:purchase").Val())
-	views := ss.parseRedisInt(ss.Redis.Get(ctx, "events:view").Val())
-	clicks := ss.parseRedisInt(ss.Redis.Get(ctx, "events:click").Val())
+	onlineUsers := ss.Redis.SCard(ctx, key("online_users")).Val()
+	totalEvents := ss.parseRedisInt(ss.Redis.Get(ctx, key("total_events")).Val())
+	purchases := ss.parseRedisInt(ss.Redis.Get(ctx, key("events:purchase")).Val())
+	views := ss.parseRedisInt(ss.Redis.Get(ctx, key("events:view")).Val())
+	clicks := ss.parseRedisInt(ss.Redis.Get(ctx, key("events:click")).Val())
 
 	// 计算转化率
 	var conversionRate float64
@@ -56,7 +58,7 @@ func (ss *StatsService) GetDashboardStats(ctx context.Context) (*models.StatsRes
 	}
 
 	// 获取热门页面
-	hotPages, err := ss.GetHotPages(ctx, 5)
+	hotPages, err := ss.GetHotPages(ctx, projectID, 5)
 	if err != nil {
 		hotPages = []models.PageStat{}
 	}
@@ -82,11 +84,11 @@ func (ss *StatsService) GetDashboardStats(ctx context.Context) (*models.StatsRes
 	return stats, nil
 }
 
-// GetHotPages 获取热门页面
-func (ss *StatsService) GetHotPages(ctx context.Context, limit int64) ([]models.PageStat, error) {
+// GetHotPages 获取指定项目的热门页面
+func (ss *StatsService) GetHotPages(ctx context.Context, projectID string, limit int64) ([]models.PageStat, error) {
 	// 生成缓存键
 	timeKey := ss.ServiceManager.GetTimeService().GetCurrentTimeString()[:15]
-	cacheKey := ss.ServiceManager.GetCacheService().GenerateCacheKey("hot_pages_list", timeKey)
+	cacheKey := ProjectKey(projectID, ss.ServiceManager.GetCacheService().GenerateCacheKey("hot_pages_list", timeKey))
 
 	// 尝试从缓存获取
 	cachedData, err := ss.Redis.Get(ctx, cacheKey).Result()
@@ -99,7 +101,7 @@ func (ss *StatsService) GetHotPages(ctx context.Context, limit int64) ([]models.
 	}
 
 	// 缓存未命中，查询Redis排行榜
-	pages := ss.Redis.ZRevRangeWithScores(ctx, "hot_pages", 0, limit-1).Val()
+	pages := ss.Redis.ZRevRangeWithScores(ctx, ProjectKey(projectID, "hot_pages"), 0, limit-1).Val()
 
 	var hotPages []models.PageStat
 	for _, page := range pages {
@@ -118,16 +120,18 @@ func (ss *StatsService) GetHotPages(ctx context.Context, limit int64) ([]models.
 	return hotPages, nil
 }
 
-// GetOnlineUserCount 获取在线用户数
-func (ss *StatsService) GetOnlineUserCount(ctx context.Context) int64 {
-	return ss.Redis.SCard(ctx, "online_users").Val()
+// GetOnlineUserCount 获取指定项目的在线用户数
+func (ss *StatsService) GetOnlineUserCount(ctx context.Context, projectID string) int64 {
+	return ss.Redis.SCard(ctx, ProjectKey(projectID, "online_users")).Val()
 }
 
-// GetEventStats 获取事件统计
-func (ss *StatsService) GetEventStats(ctx context.Context) map[string]interface{} {
+// GetEventStats 获取指定项目的事件统计
+func (ss *StatsService) GetEventStats(ctx context.Context, projectID string) map[string]interface{} {
+	key := func(name string) string { return ProjectKey(projectID, name) }
+
 	// 生成缓存键
 	timeKey := ss.ServiceManager.GetTimeService().GetCurrentTimeString()[:16]
-	cacheKey := ss.ServiceManager.GetCacheService().GenerateCacheKey("event_stats", timeKey)
+	cacheKey := key(ss.ServiceManager.GetCacheService().GenerateCacheKey("event_stats", timeKey))
 
 	// 尝试从缓存获取
 	cachedData, err := ss.Redis.Get(ctx, cacheKey).Result()
@@ -140,19 +144,24 @@ func (ss *StatsService) GetEventStats(ctx context.Context) map[string]interface{
 	}
 
 	// 缓存未命中，重新计算
-	totalEvents := ss.parseRedisInt(ss.Redis.Get(ctx, "total_events").Val())
-	clickEvents := ss.parseRedisInt(ss.Redis.Get(ctx, "events:click").Val())
-	viewEvents := ss.parseRedisInt(ss.Redis.Get(ctx, "events:view").Val())
-	purchaseEvents := ss.parseRedisInt(ss.Redis.Get(ctx, "events:purchase").Val())
+	totalEvents := ss.parseRedisInt(ss.Redis.Get(ctx, key("total_events")).Val())
+	clickEvents := ss.parseRedisInt(ss.Redis.Get(ctx, key("events:click")).Val())
+	viewEvents := ss.parseRedisInt(ss.Redis.Get(ctx, key("events:view")).Val())
+	purchaseEvents := ss.parseRedisInt(ss.Redis.Get(ctx, key("events:purchase")).Val())
+	lateEvents := ss.parseRedisInt(ss.Redis.Get(ctx, key("late_events")).Val())
+	duplicateEvents := ss.parseRedisInt(ss.Redis.Get(ctx, key("duplicate_events")).Val())
 
 	stats := map[string]interface{}{
-		"total_events": totalEvents,
+		"total_events":     totalEvents,
+		"late_events":      lateEvents,
+		"duplicate_events": duplicateEvents,
 		"events_by_type": map[string]int64{
 			"click":    clickEvents,
 			"view":     viewEvents,
 			"purchase": purchaseEvents,
 		},
-		"timestamp": ss.ServiceManager.GetTimeService().GetCurrentTimeString(),
+		"project_id": projectID,
+		"timestamp":  ss.ServiceManager.GetTimeService().GetCurrentTimeString(),
 	}
 
 	// 缓存结果
@@ -164,10 +173,10 @@ func (ss *StatsService) GetEventStats(ctx context.Context) map[string]interface{
 	return stats
 }
 
-// GetConversionRate 获取转化率
-func (ss *StatsService) GetConversionRate(ctx context.Context) float64 {
-	purchases := ss.parseRedisInt(ss.Redis.Get(ctx, "events:purchase").Val())
-	views := ss.parseRedisInt(ss.Redis.Get(ctx, "events:view").Val())
+// GetConversionRate 获取指定项目的转化率
+func (ss *StatsService) GetConversionRate(ctx context.Context, projectID string) float64 {
+	purchases := ss.parseRedisInt(ss.Redis.Get(ctx, ProjectKey(projectID, "events:purchase")).Val())
+	views := ss.parseRedisInt(ss.Redis.Get(ctx, ProjectKey(projectID, "events:view")).Val())
 
 	if views > 0 {
 		return float64(purchases) / float64(views) * 100
@@ -175,6 +184,99 @@ func (ss *StatsService) GetConversionRate(ctx context.Context) float64 {
 	return 0.0
 }
 
+// GetBreakdown 获取指定维度的事件分布（如设备类型、浏览器、操作系统、国家）
+func (ss *StatsService) GetBreakdown(ctx context.Context, projectID, dimension string) (map[string]int64, error) {
+	values, err := ss.Redis.HGetAll(ctx, ProjectKey(projectID, "breakdown:"+dimension)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := make(map[string]int64, len(values))
+	for value, count := range values {
+		breakdown[value] = ss.parseRedisInt(count)
+	}
+	return breakdown, nil
+}
+
+// GetConsentStats 获取最近若干天（含今天）各处理模式的事件数和同意率
+func (ss *StatsService) GetConsentStats(ctx context.Context, projectID string, days int) (*models.ConsentStats, error) {
+	now := time.Now()
+	pipe := ss.Redis.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, days)
+	for i := 0; i < days; i++ {
+		day := now.AddDate(0, 0, -i)
+		cmds[i] = pipe.HGetAll(ctx, ProjectKey(projectID, "consent:day:"+day.Format("20060102")))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	stats := &models.ConsentStats{Days: make([]models.ConsentDay, 0, days)}
+	for i := days - 1; i >= 0; i-- {
+		values := cmds[i].Val()
+		day := models.ConsentDay{
+			Date:      now.AddDate(0, 0, -i).Format("2006-01-02"),
+			Full:      ss.parseRedisInt(values[models.TrackingModeFull]),
+			Anonymous: ss.parseRedisInt(values[models.TrackingModeAnonymous]),
+			Dropped:   ss.parseRedisInt(values[models.TrackingModeDrop]),
+		}
+		day.ConsentRate = calculateRate(day.Full, day.Full+day.Anonymous+day.Dropped)
+		stats.Days = append(stats.Days, day)
+
+		stats.Full += day.Full
+		stats.Anonymous += day.Anonymous
+		stats.Dropped += day.Dropped
+	}
+	stats.ConsentRate = calculateRate(stats.Full, stats.Full+stats.Anonymous+stats.Dropped)
+	return stats, nil
+}
+
+// GetBotStats 获取机器人流量统计（总事件数、判定原因、事件类型和访问最多的页面）
+func (ss *StatsService) GetBotStats(ctx context.Context, projectID string, limit int64) (*models.BotStats, error) {
+	key := func(name string) string { return ProjectKey(projectID, name) }
+
+	pipe := ss.Redis.Pipeline()
+	totalCmd := pipe.Get(ctx, key("bot:total_events"))
+	reasonsCmd := pipe.HGetAll(ctx, key("bot:reasons"))
+	eventsCmd := pipe.HGetAll(ctx, key("bot:events"))
+	pagesCmd := pipe.ZRevRangeWithScores(ctx, key("bot:hot_pages"), 0, limit-1)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	stats := &models.BotStats{
+		TotalEvents: ss.parseRedisInt(totalCmd.Val()),
+		ByReason:    make(map[string]int64),
+		ByEventType: make(map[string]int64),
+		TopPages:    make([]models.PageStat, 0, limit),
+	}
+	for reason, count := range reasonsCmd.Val() {
+		stats.ByReason[reason] = ss.parseRedisInt(count)
+	}
+	for eventType, count := range eventsCmd.Val() {
+		stats.ByEventType[eventType] = ss.parseRedisInt(count)
+	}
+	for _, page := range pagesCmd.Val() {
+		stats.TopPages = append(stats.TopPages, models.PageStat{
+			PageURL: page.Member.(string),
+			Views:   int64(page.Score),
+		})
+	}
+	return stats, nil
+}
+
+// IsValidDimension 检查统计维度是否支持
+func (ss *StatsService) IsValidDimension(dimension string) bool {
+	switch dimension {
+	case models.DimensionDeviceType, models.DimensionBrowser, models.DimensionOS,
+		models.DimensionCountry, models.DimensionRegion, models.DimensionCity,
+		models.DimensionSource:
+		return true
+	default:
+		return false
+	}
+}
+
 // parseRedisInt 解析Redis整数值
 func (ss *StatsService) parseRedisInt(s string) int64 {
 	if s == "" {
@@ -28,6 +28,12 @@ func (ts *TimeService) IsEventFresh(event *models.UserEvent, maxAgeSeconds int64
 	return ts.GetEventAge(event) <= maxAgeSeconds
 }
 
+// IsEventLate 检查事件是否为迟到事件(超过实时窗口但仍在迟到窗口内)
+func (ts *TimeService) IsEventLate(event *models.UserEvent, freshSeconds, latenessSeconds int64) bool {
+	age := ts.GetEventAge(event)
+	return age > freshSeconds && age <= latenessSeconds
+}
+
 // FormatTimestamp 格式化时间戳为字符串
 func (ts *TimeService) FormatTimestamp(timestamp int64) string {
 	return time.UnixMilli(timestamp).Format("2006-01-02 15:04:05")
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"strings"
+
+	"insightflow/models"
+)
+
+// UserAgentInfo User-Agent解析结果
+type UserAgentInfo struct {
+	DeviceType     string `json:"device_type"`
+	Browser        string `json:"browser"`
+	BrowserVersion string `json:"browser_version"`
+	OS             string `json:"os"`
+	OSVersion      string `json:"os_version"`
+}
+
+// browserSignature 浏览器特征（按顺序匹配，越具体的越靠前）
+type browserSignature struct {
+	token string
+	name  string
+}
+
+// browserSignatures 浏览器特征列表
+// Edge/Opera/Samsung等基于Chromium的浏览器UA中也包含Chrome和Safari，所以必须排在前面
+var browserSignatures = []browserSignature{
+	{"EdgA/", "Edge"},
+	{"EdgiOS/", "Edge"},
+	{"Edg/", "Edge"},
+	{"Edge/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Opera/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"UCBrowser/", "UC Browser"},
+	{"MicroMessenger/", "WeChat"},
+	{"FxiOS/", "Firefox"},
+	{"Firefox/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"MSIE ", "Internet Explorer"},
+}
+
+// windowsVersions Windows NT内核版本到发行版本的映射
+var windowsVersions = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+	"6.0":  "Vista",
+	"5.1":  "XP",
+}
+
+// UserAgentParser User-Agent解析器（基于特征字符串的轻量实现）
+type UserAgentParser struct{}
+
+// NewUserAgentParser 创建User-Agent解析器
+func NewUserAgentParser() *UserAgentParser {
+	return &UserAgentParser{}
+}
+
+// Parse 解析User-Agent
+func (p *UserAgentParser) Parse(ua string) UserAgentInfo {
+	if ua == "" {
+		return UserAgentInfo{}
+	}
+
+	info := UserAgentInfo{}
+	info.Browser, info.BrowserVersion = p.parseBrowser(ua)
+	info.OS, info.OSVersion = p.parseOS(ua)
+	info.DeviceType = p.parseDeviceType(ua)
+	return info
+}
+
+// parseBrowser 解析浏览器名称和版本
+func (p *UserAgentParser) parseBrowser(ua string) (string, string) {
+	for _, sig := range browserSignatures {
+		if idx := strings.Index(ua, sig.token); idx >= 0 {
+			return sig.name, readVersion(ua[idx+len(sig.token):])
+		}
+	}
+
+	// IE11 不再包含MSIE
+	if strings.Contains(ua, "Trident/") {
+		if idx := strings.Index(ua, "rv:"); idx >= 0 {
+			return "Internet Explorer", readVersion(ua[idx+3:])
+		}
+		return "Internet Explorer", ""
+	}
+
+	// Safari 的版本号在 Version/ 后面
+	if strings.Contains(ua, "Safari/") {
+		if idx := strings.Index(ua, "Version/"); idx >= 0 {
+			return "Safari", readVersion(ua[idx+len("Version/"):])
+		}
+		return "Safari", ""
+	}
+
+	return "Other", ""
+}
+
+// parseOS 解析操作系统名称和版本
+func (p *UserAgentParser) parseOS(ua string) (string, string) {
+	switch {
+	case strings.Contains(ua, "Windows NT "):
+		idx := strings.Index(ua, "Windows NT ")
+		ntVersion := readVersion(ua[idx+len("Windows NT "):])
+		if version, ok := windowsVersions[ntVersion]; ok {
+			return "Windows", version
+		}
+		return "Windows", ntVersion
+	case strings.Contains(ua, "iPhone OS "):
+		idx := strings.Index(ua, "iPhone OS ")
+		return "iOS", readUnderscoreVersion(ua[idx+len("iPhone OS "):])
+	case strings.Contains(ua, "iPad") && strings.Contains(ua, "CPU OS "):
+		idx := strings.Index(ua, "CPU OS ")
+		return "iPadOS", readUnderscoreVersion(ua[idx+len("CPU OS "):])
+	case strings.Contains(ua, "Android"):
+		idx := strings.Index(ua, "Android")
+		return "Android", readVersion(strings.TrimLeft(ua[idx+len("Android"):], " "))
+	case strings.Contains(ua, "CrOS"):
+		return "Chrome OS", ""
+	case strings.Contains(ua, "Mac OS X"):
+		idx := strings.Index(ua, "Mac OS X")
+		return "macOS", readUnderscoreVersion(strings.TrimLeft(ua[idx+len("Mac OS X"):], " "))
+	case strings.Contains(ua, "Linux"):
+		return "Linux", ""
+	default:
+		return "Other", ""
+	}
+}
+
+// parseDeviceType 解析设备类型
+func (p *UserAgentParser) parseDeviceType(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPad"), strings.Contains(ua, "Tablet"),
+		strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile"):
+		return models.DeviceTypeTablet
+	case strings.Contains(ua, "Mobi"), strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPod"),
+		strings.Contains(ua, "Android"):
+		return models.DeviceTypeMobile
+	default:
+		return models.DeviceTypeDesktop
+	}
+}
+
+// readVersion 读取以数字和点组成的版本号
+func readVersion(s string) string {
+	end := 0
+	for end < len(s) && (s[end] == '.' || (s[end] >= '0' && s[end] <= '9')) {
+		end++
+	}
+	return strings.TrimRight(s[:end], ".")
+}
+
+// readUnderscoreVersion 读取以下划线分隔的版本号（如 iOS 17_2_1）
+func readUnderscoreVersion(s string) string {
+	end := 0
+	for end < len(s) && (s[end] == '_' || s[end] == '.' || (s[end] >= '0' && s[end] <= '9')) {
+		end++
+	}
+	return strings.ReplaceAll(strings.TrimRight(s[:end], "_."), "_", ".")
+}
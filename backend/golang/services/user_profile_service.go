@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"insightflow/models"
+)
+
+// 用户属性相关错误
+var (
+	ErrUserNotFound          = errors.New("用户不存在")
+	ErrInvalidPropertyUpdate = errors.New("用户属性操作无效")
+	ErrUserErased            = errors.New("用户数据已删除")
+)
+
+// propertyNamePattern 用户属性名格式：字母、数字和下划线（同时用于生成JSON路径）
+var propertyNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+// IsValidPropertyName 检查用户属性名是否合法
+func IsValidPropertyName(name string) bool {
+	return propertyNamePattern.MatchString(name)
+}
+
+// UserProfileService 用户画像服务
+// 用户属性保存在 users.properties（JSON），按规范用户ID存储
+type UserProfileService struct {
+	DB       *sql.DB
+	Identity *IdentityService
+}
+
+// NewUserProfileService 创建用户画像服务
+func NewUserProfileService(db *sql.DB, identity *IdentityService) *UserProfileService {
+	return &UserProfileService{
+		DB:       db,
+		Identity: identity,
+	}
+}
+
+// ParsePropertyUpdate 解析 user_properties 事件的 extra_data
+// 含 $ 开头的操作符时按操作解析，否则整个对象视为 $set（SDK 的 setUserProperties 直接发送属性）
+func ParsePropertyUpdate(extraData interface{}) (models.UserPropertyUpdate, error) {
+	var update models.UserPropertyUpdate
+
+	props, ok := extraData.(map[string]interface{})
+	if !ok || len(props) == 0 {
+		return update, fmt.Errorf("%w: extra_data为空", ErrInvalidPropertyUpdate)
+	}
+
+	for key := range props {
+		if strings.HasPrefix(key, "$") {
+			data, err := json.Marshal(props)
+			if err != nil {
+				return update, err
+			}
+			if err := json.Unmarshal(data, &update); err != nil {
+				return update, fmt.Errorf("%w: %v", ErrInvalidPropertyUpdate, err)
+			}
+			return update, nil
+		}
+	}
+
+	update.Set = props
+	return update, nil
+}
+
+// UpdateProperties 执行用户属性操作，返回更新后的全部属性
+// 用户记录不存在时先创建；任一操作无效时整体不生效
+func (ups *UserProfileService) UpdateProperties(ctx context.Context, projectID, userID string, update models.UserPropertyUpdate) (map[string]interface{}, error) {
+	if userID == "" {
+		return nil, ErrInvalidIdentity
+	}
+	if err := validatePropertyUpdate(update); err != nil {
+		return nil, err
+	}
+
+	canonicalID := ups.Identity.Resolve(ctx, projectID, userID)
+
+	tx, err := ups.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT IGNORE INTO users (project_id, user_id, first_visit, last_visit, total_events, total_sessions)
+		VALUES (?, ?, NOW(), NOW(), 0, 0)`, projectID, canonicalID); err != nil {
+		return nil, err
+	}
+
+	var raw []byte
+	if err := tx.QueryRowContext(ctx, `
+		SELECT properties FROM users WHERE project_id = ? AND user_id = ? FOR UPDATE`,
+		projectID, canonicalID).Scan(&raw); err != nil {
+		return nil, err
+	}
+
+	properties, err := decodeProperties(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPropertyUpdate(properties, update); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(properties)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users SET properties = ? WHERE project_id = ? AND user_id = ?`,
+		string(data), projectID, canonicalID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return properties, nil
+}
+
+// GetProfile 获取用户画像（按规范用户查询，包含自定义属性）
+func (ups *UserProfileService) GetProfile(ctx context.Context, projectID, userID string) (*models.User, error) {
+	canonicalID := ups.Identity.Resolve(ctx, projectID, userID)
+
+	var user models.User
+	var raw []byte
+	err := ups.DB.QueryRowContext(ctx, `
+		SELECT project_id, user_id, first_visit, last_visit, total_events, total_sessions,
+		       device_type, browser, os, properties, created_at, updated_at
+		FROM users WHERE project_id = ? AND user_id = ?`, projectID, canonicalID).Scan(
+		&user.ProjectID,
+		&user.UserID,
+		&user.FirstVisit,
+		&user.LastVisit,
+		&user.TotalEvents,
+		&user.TotalSessions,
+		&user.DeviceType,
+		&user.Browser,
+		&user.OS,
+		&raw,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Properties, err = decodeProperties(raw); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// validatePropertyUpdate 检查属性操作是否为空及属性名是否合法
+func validatePropertyUpdate(update models.UserPropertyUpdate) error {
+	names := make([]string, 0)
+	for name := range update.Set {
+		names = append(names, name)
+	}
+	for name := range update.SetOnce {
+		names = append(names, name)
+	}
+	for name := range update.Increment {
+		names = append(names, name)
+	}
+	for name := range update.Append {
+		names = append(names, name)
+	}
+	names = append(names, update.Unset...)
+
+	if len(names) == 0 {
+		return fmt.Errorf("%w: 没有任何属性操作", ErrInvalidPropertyUpdate)
+	}
+	for _, name := range names {
+		if !IsValidPropertyName(name) {
+			return fmt.Errorf("%w: 属性名不合法: %s", ErrInvalidPropertyUpdate, name)
+		}
+	}
+	return nil
+}
+
+// applyPropertyUpdate 按 $set、$set_once、$increment、$append、$unset 的顺序执行属性操作
+func applyPropertyUpdate(properties map[string]interface{}, update models.UserPropertyUpdate) error {
+	for name, value := range update.Set {
+		properties[name] = value
+	}
+
+	for name, value := range update.SetOnce {
+		if _, exists := properties[name]; !exists {
+			properties[name] = value
+		}
+	}
+
+	for name, delta := range update.Increment {
+		current, exists := properties[name]
+		if !exists || current == nil {
+			properties[name] = delta
+			continue
+		}
+		number, ok := current.(float64)
+		if !ok {
+			return fmt.Errorf("%w: 属性%s不是数值，无法累加", ErrInvalidPropertyUpdate, name)
+		}
+		properties[name] = number + delta
+	}
+
+	for name, value := range update.Append {
+		current, exists := properties[name]
+		if !exists || current == nil {
+			properties[name] = []interface{}{value}
+			continue
+		}
+		list, ok := current.([]interface{})
+		if !ok {
+			return fmt.Errorf("%w: 属性%s不是列表，无法追加", ErrInvalidPropertyUpdate, name)
+		}
+		properties[name] = append(list, value)
+	}
+
+	for _, name := range update.Unset {
+		delete(properties, name)
+	}
+	return nil
+}
+
+// decodeProperties 解析 users.properties（NULL 时返回空属性）
+func decodeProperties(raw []byte) (map[string]interface{}, error) {
+	properties := make(map[string]interface{})
+	if len(raw) == 0 {
+		return properties, nil
+	}
+	if err := json.Unmarshal(raw, &properties); err != nil {
+		return nil, fmt.Errorf("解析用户属性失败: %w", err)
+	}
+	if properties == nil {
+		properties = make(map[string]interface{})
+	}
+	return properties, nil
+}
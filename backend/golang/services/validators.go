@@ -5,8 +5,38 @@ import (
 	"insightflow/models"
 )
 
+// maxEventIDLength event_id最大长度（与数据库字段一致）
+const maxEventIDLength = 64
+
+// ValidationError 事件验证错误，携带字段、规则和错误码
+type ValidationError struct {
+	Field   string
+	Rule    string
+	Code    string
+	Message string
+}
+
+// Error 实现error接口
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// ToRejection 转换为响应中的拒绝明细
+func (e *ValidationError) ToRejection(index int) models.EventRejection {
+	return models.EventRejection{
+		Index:   index,
+		Field:   e.Field,
+		Rule:    e.Rule,
+		Code:    e.Code,
+		Message: e.Message,
+	}
+}
+
 // EventValidator 事件验证器
-type EventValidator struct{}
+// 设置了Schema注册表时，事件类型和extra_data由注册表驱动；否则使用内置事件类型
+type EventValidator struct {
+	schemaRegistry *SchemaRegistry
+}
 
 // NewEventValidator 创建事件验证器
 func NewEventValidator() *EventValidator {
@@ -16,29 +46,62 @@ func NewEventValidator() *EventValidator {
 // Validate 验证UserEvent数据
 func (v *EventValidator) Validate(event *models.UserEvent) error {
 	if event.UserID == "" {
-		return fmt.Errorf("user_id不能为空")
+		return requiredFieldError("user_id")
 	}
 	if event.SessionID == "" {
-		return fmt.Errorf("session_id不能为空")
+		return requiredFieldError("session_id")
 	}
 	if event.EventType == "" {
-		return fmt.Errorf("event_type不能为空")
+		return requiredFieldError("event_type")
 	}
 	if event.PageURL == "" {
-		return fmt.Errorf("page_url不能为空")
+		return requiredFieldError("page_url")
+	}
+	if len(event.EventID) > maxEventIDLength {
+		return &ValidationError{
+			Field:   "event_id",
+			Rule:    "max_length",
+			Code:    models.RejectCodeInvalidEventID,
+			Message: fmt.Sprintf("event_id长度不能超过%d", maxEventIDLength),
+		}
 	}
 	if event.Timestamp <= 0 {
-		return fmt.Errorf("timestamp必须大于0")
+		return &ValidationError{
+			Field:   "timestamp",
+			Rule:    "positive",
+			Code:    models.RejectCodeInvalidTimestamp,
+			Message: "timestamp必须大于0",
+		}
+	}
+	switch event.Consent {
+	case "", models.ConsentGranted, models.ConsentAnonymous, models.ConsentDenied:
+	default:
+		return &ValidationError{
+			Field:   "consent",
+			Rule:    "supported_consent",
+			Code:    models.RejectCodeInvalidConsent,
+			Message: fmt.Sprintf("不支持的同意状态: %s", event.Consent),
+		}
 	}
 	return nil
 }
 
+// SetSchemaRegistry 设置事件Schema注册表
+func (v *EventValidator) SetSchemaRegistry(registry *SchemaRegistry) {
+	v.schemaRegistry = registry
+}
+
 // IsValidEventType 检查事件类型是否有效
 func (v *EventValidator) IsValidEventType(eventType string) bool {
+	if v.schemaRegistry != nil {
+		_, ok := v.schemaRegistry.Get(eventType)
+		return ok
+	}
+
 	validTypes := []string{
 		models.EventTypeClick, models.EventTypeView, models.EventTypeScroll,
 		models.EventTypePurchase, models.EventTypeSubmit, models.EventTypeLoad, models.EventTypeExit,
-		models.EventTypeVisibilityChange,
+		models.EventTypeVisibilityChange, models.EventTypeIdentify, models.EventTypeUserProperties,
 	}
 	for _, validType := range validTypes {
 		if eventType == validType {
@@ -51,7 +114,12 @@ func (v *EventValidator) IsValidEventType(eventType string) bool {
 // ValidateEventType 验证事件类型
 func (v *EventValidator) ValidateEventType(event *models.UserEvent) error {
 	if !v.IsValidEventType(event.EventType) {
-		return fmt.Errorf("不支持的事件类型: %s", event.EventType)
+		return &ValidationError{
+			Field:   "event_type",
+			Rule:    "supported_event_type",
+			Code:    models.RejectCodeInvalidEventType,
+			Message: fmt.Sprintf("不支持的事件类型: %s", event.EventType),
+		}
 	}
 	return nil
 }
@@ -64,5 +132,20 @@ func (v *EventValidator) ValidateComplete(event *models.UserEvent) error {
 	if err := v.ValidateEventType(event); err != nil {
 		return err
 	}
+	if v.schemaRegistry != nil {
+		if err := v.schemaRegistry.ValidateProperties(event); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// requiredFieldError 构造必填字段缺失错误
+func requiredFieldError(field string) *ValidationError {
+	return &ValidationError{
+		Field:   field,
+		Rule:    "required",
+		Code:    models.RejectCodeMissingField,
+		Message: fmt.Sprintf("%s不能为空", field),
+	}
+}
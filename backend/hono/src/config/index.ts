@@ -25,6 +25,7 @@ export const settings = {
   // 外部服务配置
   services: {
     golangServiceUrl: process.env.GOLANG_SERVICE_URL || 'http://localhost:8080',
+    golangReadKey: process.env.GOLANG_READ_KEY || '',
     redisUrl: process.env.REDIS_URL || 'redis://localhost:6379',
   },
 
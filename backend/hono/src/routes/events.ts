@@ -20,6 +20,7 @@ const eventDataSchema = z.object({
   element: z.string().optional(),
   element_text: z.string().optional(),
   timestamp: z.number(),
+  consent: z.enum(['granted', 'anonymous', 'denied']).optional(),
   extra_data: z.record(z.any()).optional(),
 });
 
@@ -35,7 +36,14 @@ events.post('/batch', zValidator('json', batchEventSchema), async (c) => {
   const cleanedEvents = analyticsService.cleanEventData(rawEvents as any[]);
 
   // 转发到Golang服务
-  const golangResult = await golangService.postEvents(cleanedEvents);
+  const privacyHeaders: Record<string, string> = {};
+  for (const name of ['DNT', 'Sec-GPC']) {
+    const value = c.req.header(name);
+    if (value) {
+      privacyHeaders[name] = value;
+    }
+  }
+  const golangResult = await golangService.postEvents(cleanedEvents, privacyHeaders);
 
   // 后台任务：更新实时缓存
   // 注意：在Hono中我们不能直接使用BackgroundTasks，需要用其他方式实现
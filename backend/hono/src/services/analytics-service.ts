@@ -32,6 +32,9 @@ class AnalyticsService {
       if (event.extra_data) {
         cleanedEvent.extra_data = event.extra_data;
       }
+      if (event.consent) {
+        cleanedEvent.consent = event.consent;
+      }
       
       cleanedEvents.push(cleanedEvent);
     }
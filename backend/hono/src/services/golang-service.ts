@@ -7,19 +7,23 @@ import { httpClientManager } from '../core/http-client';
 
 class GolangService {
   private readonly baseUrl: string;
+  private readonly readKey: string;
 
   constructor() {
     this.baseUrl = settings.services.golangServiceUrl;
+    this.readKey = settings.services.golangReadKey;
   }
 
+  // 查询接口需要项目读取密钥
   private async callApi<T = any>(endpoint: string, params?: Record<string, any>): Promise<T> {
     const url = `${this.baseUrl}/api${endpoint}`;
-    return await httpClientManager.get<T>(url, params);
+    const headers = this.readKey ? { 'X-Read-Key': this.readKey } : undefined;
+    return await httpClientManager.get<T>(url, params, headers);
   }
 
-  private async postApi<T = any>(endpoint: string, data?: any): Promise<T> {
+  private async postApi<T = any>(endpoint: string, data?: any, headers?: Record<string, string>): Promise<T> {
     const url = `${this.baseUrl}/api${endpoint}`;
-    return await httpClientManager.post<T>(url, data);
+    return await httpClientManager.post<T>(url, data, headers);
   }
 
   // 统计相关接口
@@ -50,8 +54,9 @@ class GolangService {
   }
 
   // 事件上报接口
-  async postEvents(events: Record<string, any>[]): Promise<Record<string, any>> {
-    return await this.postApi('/events', { events });
+  // headers 透传浏览器的 DNT / Sec-GPC 信号，Go 服务据此决定事件处理模式
+  async postEvents(events: Record<string, any>[], headers?: Record<string, string>): Promise<Record<string, any>> {
+    return await this.postApi('/events', { events }, headers);
   }
 
   // 批量调用接口
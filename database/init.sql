@@ -1,42 +1,137 @@
 -- InsightFlow 数据库初始化脚本
 
+-- 项目表（多项目/租户）
+CREATE TABLE projects (
+    id VARCHAR(64) PRIMARY KEY COMMENT '项目ID',
+    name VARCHAR(128) NOT NULL COMMENT '项目名称',
+    write_key VARCHAR(64) NOT NULL COMMENT '写入密钥（事件上报）',
+    secret_key VARCHAR(64) COMMENT '服务端密钥（签名上报）',
+    read_key VARCHAR(64) COMMENT '读取密钥（查询接口）',
+    monthly_event_quota BIGINT NOT NULL DEFAULT 0 COMMENT '每月事件配额（0表示不限制）',
+    is_active BOOLEAN DEFAULT TRUE COMMENT '是否启用',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_write_key (write_key),
+    UNIQUE KEY uk_read_key (read_key)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='项目表';
+
+-- 默认项目（开发环境写入密钥；服务端密钥和读取密钥不预置，需通过 rotate-secret、rotate-read-key 接口生成）
+INSERT INTO projects (id, name, write_key) VALUES ('default', '默认项目', 'wk_default_dev');
+
 -- 用户事件表（核心表）
 CREATE TABLE user_events (
     id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID',
+    event_id VARCHAR(64) COMMENT '客户端事件ID（去重）',
     user_id VARCHAR(64) NOT NULL COMMENT '用户ID',
     session_id VARCHAR(64) NOT NULL COMMENT '会话ID', 
     event_type VARCHAR(32) NOT NULL COMMENT '事件类型：click, view, scroll, purchase等',
     page_url VARCHAR(512) NOT NULL COMMENT '页面URL',
+    page_title VARCHAR(256) COMMENT '页面标题',
     element VARCHAR(128) COMMENT '元素标识',
     element_text VARCHAR(256) COMMENT '元素文本',
+    element_id VARCHAR(128) COMMENT '元素ID',
+    element_class VARCHAR(256) COMMENT '元素Class',
     position_x INT COMMENT '点击位置X坐标',
     position_y INT COMMENT '点击位置Y坐标',
     user_agent VARCHAR(512) COMMENT '用户代理',
+    device_type VARCHAR(32) COMMENT '设备类型',
+    browser VARCHAR(64) COMMENT '浏览器',
+    browser_version VARCHAR(32) COMMENT '浏览器版本',
+    os VARCHAR(64) COMMENT '操作系统',
+    os_version VARCHAR(32) COMMENT '操作系统版本',
     ip_address VARCHAR(45) COMMENT 'IP地址',
+    country CHAR(2) COMMENT '国家ISO代码',
+    region VARCHAR(128) COMMENT '地区/省份',
+    city VARCHAR(128) COMMENT '城市',
     timestamp BIGINT NOT NULL COMMENT '事件时间戳',
+    source VARCHAR(16) NOT NULL DEFAULT 'client' COMMENT '事件来源：client, server',
+    is_bot TINYINT(1) NOT NULL DEFAULT 0 COMMENT '是否机器人流量',
+    bot_reason VARCHAR(32) COMMENT '判定为机器人的原因：user_agent, datacenter_ip, event_rate, no_interaction',
+    extra_data JSON COMMENT '事件自定义属性',
+    prop_order_id VARCHAR(64) AS (LEFT(JSON_UNQUOTE(JSON_EXTRACT(extra_data, '$.order_id')), 64)) VIRTUAL COMMENT '订单ID（extra_data.order_id）',
+    prop_product_id VARCHAR(64) AS (LEFT(JSON_UNQUOTE(JSON_EXTRACT(extra_data, '$.product_id')), 64)) VIRTUAL COMMENT '商品ID（extra_data.product_id）',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_project_event (project_id, event_id),
     INDEX idx_user_id (user_id),
+    INDEX idx_project_user (project_id, user_id),
+    INDEX idx_project_timestamp (project_id, timestamp),
+    INDEX idx_project_source (project_id, source),
+    INDEX idx_project_bot_timestamp (project_id, is_bot, timestamp),
+    INDEX idx_project_element_id (project_id, element_id),
+    INDEX idx_project_order (project_id, prop_order_id),
+    INDEX idx_project_product (project_id, prop_product_id),
     INDEX idx_event_type (event_type),
     INDEX idx_timestamp (timestamp),
     INDEX idx_page_url (page_url),
-    INDEX idx_session_id (session_id)
+    INDEX idx_session_id (session_id),
+    INDEX idx_device_type (device_type),
+    INDEX idx_browser (browser),
+    INDEX idx_os (os),
+    INDEX idx_country (country)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户行为事件表';
 
 -- 用户信息表
 CREATE TABLE users (
-    user_id VARCHAR(64) PRIMARY KEY COMMENT '用户ID',
+    project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID',
+    user_id VARCHAR(64) NOT NULL COMMENT '用户ID',
     first_visit TIMESTAMP NOT NULL COMMENT '首次访问时间',
     last_visit TIMESTAMP NOT NULL COMMENT '最后访问时间',
     total_events INT DEFAULT 0 COMMENT '总事件数',
     total_sessions INT DEFAULT 0 COMMENT '总会话数',
     device_type VARCHAR(32) COMMENT '设备类型',
     browser VARCHAR(64) COMMENT '浏览器',
+    os VARCHAR(64) COMMENT '操作系统',
+    properties JSON COMMENT '用户自定义属性',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (project_id, user_id),
     INDEX idx_first_visit (first_visit),
     INDEX idx_last_visit (last_visit)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户基础信息表';
 
+-- 用户身份关联表（匿名ID/旧ID -> 规范用户ID）
+CREATE TABLE user_identities (
+    project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID',
+    user_id VARCHAR(64) NOT NULL COMMENT '被关联的用户ID（匿名ID或旧ID）',
+    canonical_id VARCHAR(64) NOT NULL COMMENT '规范用户ID',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (project_id, user_id),
+    INDEX idx_project_canonical (project_id, canonical_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户身份关联表';
+
+-- 用户数据删除与导出任务表（GDPR）
+CREATE TABLE privacy_jobs (
+    job_id VARCHAR(64) PRIMARY KEY COMMENT '任务ID',
+    project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID',
+    user_id VARCHAR(64) NOT NULL COMMENT '请求删除/导出的用户ID',
+    job_type VARCHAR(16) NOT NULL COMMENT '任务类型：erase, export',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' COMMENT '状态：pending, running, completed, failed',
+    result JSON COMMENT '处理结果（各数据源删除/导出的记录数）',
+    file_path VARCHAR(512) COMMENT '导出文件路径（过期删除后清空）',
+    error_message VARCHAR(512) COMMENT '失败原因',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP NULL COMMENT '开始处理时间',
+    completed_at TIMESTAMP NULL COMMENT '完成时间',
+    INDEX idx_status_created (status, created_at),
+    INDEX idx_project_user (project_id, user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户数据删除与导出任务表';
+
+-- 用户数据删除与导出审计日志
+CREATE TABLE privacy_audit_log (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID',
+    job_id VARCHAR(64) COMMENT '任务ID',
+    user_id VARCHAR(64) NOT NULL COMMENT '被处理的用户ID（作为已执行删除的凭证保留）',
+    action VARCHAR(32) NOT NULL COMMENT '操作：erase_requested, erase_completed, export_requested, export_completed, export_downloaded, export_expired, job_failed',
+    actor VARCHAR(256) COMMENT '操作来源（请求IP及请求ID）',
+    details JSON COMMENT '操作详情',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_project_user (project_id, user_id),
+    INDEX idx_job_id (job_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户数据删除与导出审计日志';
+
 -- 分析结果缓存表
 CREATE TABLE analysis_cache (
     cache_key VARCHAR(128) PRIMARY KEY COMMENT '缓存键',
@@ -49,11 +144,13 @@ CREATE TABLE analysis_cache (
 -- 漏斗配置表
 CREATE TABLE funnel_configs (
     id INT AUTO_INCREMENT PRIMARY KEY,
+    project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID',
     funnel_name VARCHAR(64) NOT NULL COMMENT '漏斗名称',
     steps JSON NOT NULL COMMENT '漏斗步骤配置',
     is_active BOOLEAN DEFAULT TRUE COMMENT '是否启用',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_project_id (project_id)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='漏斗配置表';
 
 -- 插入默认漏斗配置
@@ -65,6 +162,48 @@ INSERT INTO funnel_configs (funnel_name, steps) VALUES
     JSON_OBJECT('step', 4, 'name', '完成购买', 'event_type', 'purchase')
 ));
 
+-- 事件Schema注册表
+CREATE TABLE event_schemas (
+    event_type VARCHAR(32) PRIMARY KEY COMMENT '事件类型',
+    description VARCHAR(256) COMMENT '事件描述',
+    properties JSON COMMENT 'extra_data属性定义：[{name, type, required, allowed_values}]',
+    is_active BOOLEAN DEFAULT TRUE COMMENT '是否启用',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='事件Schema注册表';
+
+INSERT INTO event_schemas (event_type, description, properties) VALUES
+('click', '元素点击', JSON_ARRAY()),
+('view', '页面浏览', JSON_ARRAY()),
+('scroll', '页面滚动', JSON_ARRAY(
+    JSON_OBJECT('name', 'scroll_percentage', 'type', 'number', 'required', FALSE)
+)),
+('purchase', '完成购买', JSON_ARRAY(
+    JSON_OBJECT('name', 'order_id', 'type', 'string', 'required', FALSE),
+    JSON_OBJECT('name', 'total_amount', 'type', 'number', 'required', FALSE),
+    JSON_OBJECT('name', 'currency', 'type', 'string', 'required', FALSE)
+)),
+('submit', '提交', JSON_ARRAY()),
+('load', '页面加载', JSON_ARRAY()),
+('exit', '页面退出', JSON_ARRAY()),
+('visibility_change', '页面可见性变化', JSON_ARRAY(
+    JSON_OBJECT('name', 'visibility_state', 'type', 'string', 'required', FALSE,
+                'allowed_values', JSON_ARRAY('visible', 'hidden'))
+)),
+('form_submit', '表单提交', JSON_ARRAY(
+    JSON_OBJECT('name', 'form_id', 'type', 'string', 'required', FALSE),
+    JSON_OBJECT('name', 'form_action', 'type', 'string', 'required', FALSE),
+    JSON_OBJECT('name', 'form_method', 'type', 'string', 'required', FALSE)
+)),
+('user_properties', '用户属性设置', JSON_ARRAY()),
+('identify', '用户登录（关联匿名ID）', JSON_ARRAY(
+    JSON_OBJECT('name', 'anonymous_id', 'type', 'string', 'required', TRUE)
+)),
+('add_to_cart', '加入购物车', JSON_ARRAY(
+    JSON_OBJECT('name', 'product_id', 'type', 'string', 'required', FALSE),
+    JSON_OBJECT('name', 'cart_count', 'type', 'number', 'required', FALSE)
+));
+
 -- 创建测试数据
 INSERT INTO user_events (user_id, session_id, event_type, page_url, element, element_text, position_x, position_y, timestamp) VALUES
 ('user_001', 'session_001', 'view', '/product/123', NULL, NULL, NULL, NULL, UNIX_TIMESTAMP() * 1000),
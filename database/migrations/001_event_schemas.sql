@@ -0,0 +1,40 @@
+-- 001: 事件Schema注册表
+-- 事件类型及其 extra_data 属性定义，由 Go 服务的 SchemaRegistry 定时加载
+
+CREATE TABLE IF NOT EXISTS event_schemas (
+    event_type VARCHAR(32) PRIMARY KEY COMMENT '事件类型',
+    description VARCHAR(256) COMMENT '事件描述',
+    properties JSON COMMENT 'extra_data属性定义：[{name, type, required, allowed_values}]',
+    is_active BOOLEAN DEFAULT TRUE COMMENT '是否启用',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='事件Schema注册表';
+
+INSERT IGNORE INTO event_schemas (event_type, description, properties) VALUES
+('click', '元素点击', JSON_ARRAY()),
+('view', '页面浏览', JSON_ARRAY()),
+('scroll', '页面滚动', JSON_ARRAY(
+    JSON_OBJECT('name', 'scroll_percentage', 'type', 'number', 'required', FALSE)
+)),
+('purchase', '完成购买', JSON_ARRAY(
+    JSON_OBJECT('name', 'order_id', 'type', 'string', 'required', FALSE),
+    JSON_OBJECT('name', 'total_amount', 'type', 'number', 'required', FALSE),
+    JSON_OBJECT('name', 'currency', 'type', 'string', 'required', FALSE)
+)),
+('submit', '提交', JSON_ARRAY()),
+('load', '页面加载', JSON_ARRAY()),
+('exit', '页面退出', JSON_ARRAY()),
+('visibility_change', '页面可见性变化', JSON_ARRAY(
+    JSON_OBJECT('name', 'visibility_state', 'type', 'string', 'required', FALSE,
+                'allowed_values', JSON_ARRAY('visible', 'hidden'))
+)),
+('form_submit', '表单提交', JSON_ARRAY(
+    JSON_OBJECT('name', 'form_id', 'type', 'string', 'required', FALSE),
+    JSON_OBJECT('name', 'form_action', 'type', 'string', 'required', FALSE),
+    JSON_OBJECT('name', 'form_method', 'type', 'string', 'required', FALSE)
+)),
+('user_properties', '用户属性设置', JSON_ARRAY()),
+('add_to_cart', '加入购物车', JSON_ARRAY(
+    JSON_OBJECT('name', 'product_id', 'type', 'string', 'required', FALSE),
+    JSON_OBJECT('name', 'cart_count', 'type', 'number', 'required', FALSE)
+));
@@ -0,0 +1,14 @@
+-- 002: User-Agent解析结果（设备类型、浏览器、操作系统）
+
+ALTER TABLE user_events
+    ADD COLUMN device_type VARCHAR(32) COMMENT '设备类型' AFTER user_agent,
+    ADD COLUMN browser VARCHAR(64) COMMENT '浏览器' AFTER device_type,
+    ADD COLUMN browser_version VARCHAR(32) COMMENT '浏览器版本' AFTER browser,
+    ADD COLUMN os VARCHAR(64) COMMENT '操作系统' AFTER browser_version,
+    ADD COLUMN os_version VARCHAR(32) COMMENT '操作系统版本' AFTER os,
+    ADD INDEX idx_device_type (device_type),
+    ADD INDEX idx_browser (browser),
+    ADD INDEX idx_os (os);
+
+ALTER TABLE users
+    ADD COLUMN os VARCHAR(64) COMMENT '操作系统' AFTER browser;
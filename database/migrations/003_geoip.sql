@@ -0,0 +1,7 @@
+-- 003: IP地理位置（由本地GeoIP数据库解析）
+
+ALTER TABLE user_events
+    ADD COLUMN country CHAR(2) COMMENT '国家ISO代码' AFTER ip_address,
+    ADD COLUMN region VARCHAR(128) COMMENT '地区/省份' AFTER country,
+    ADD COLUMN city VARCHAR(128) COMMENT '城市' AFTER region,
+    ADD INDEX idx_country (country);
@@ -0,0 +1,28 @@
+-- 004: 多项目（租户）支持
+-- 历史数据全部归属默认项目 default
+
+CREATE TABLE IF NOT EXISTS projects (
+    id VARCHAR(64) PRIMARY KEY COMMENT '项目ID',
+    name VARCHAR(128) NOT NULL COMMENT '项目名称',
+    write_key VARCHAR(64) NOT NULL COMMENT '写入密钥（事件上报）',
+    is_active BOOLEAN DEFAULT TRUE COMMENT '是否启用',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_write_key (write_key)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='项目表';
+
+INSERT IGNORE INTO projects (id, name, write_key) VALUES ('default', '默认项目', 'wk_default_dev');
+
+ALTER TABLE user_events
+    ADD COLUMN project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID' AFTER id,
+    ADD INDEX idx_project_user (project_id, user_id),
+    ADD INDEX idx_project_timestamp (project_id, timestamp);
+
+ALTER TABLE users
+    ADD COLUMN project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID' FIRST,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (project_id, user_id);
+
+ALTER TABLE funnel_configs
+    ADD COLUMN project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID' AFTER id,
+    ADD INDEX idx_project_id (project_id);
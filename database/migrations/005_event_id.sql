@@ -0,0 +1,5 @@
+-- 005: 客户端事件ID（幂等写入）
+
+ALTER TABLE user_events
+    ADD COLUMN event_id VARCHAR(64) COMMENT '客户端事件ID（去重）' AFTER project_id,
+    ADD UNIQUE KEY uk_project_event (project_id, event_id);
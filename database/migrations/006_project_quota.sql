@@ -0,0 +1,4 @@
+-- 006: 项目月度事件配额
+
+ALTER TABLE projects
+    ADD COLUMN monthly_event_quota BIGINT NOT NULL DEFAULT 0 COMMENT '每月事件配额（0表示不限制）' AFTER write_key;
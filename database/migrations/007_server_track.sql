@@ -0,0 +1,8 @@
+-- 007: 服务端签名上报（项目服务端密钥、事件来源）
+
+ALTER TABLE projects
+    ADD COLUMN secret_key VARCHAR(64) COMMENT '服务端密钥（签名上报）' AFTER write_key;
+
+ALTER TABLE user_events
+    ADD COLUMN source VARCHAR(16) NOT NULL DEFAULT 'client' COMMENT '事件来源：client, server' AFTER timestamp,
+    ADD INDEX idx_project_source (project_id, source);
@@ -0,0 +1,16 @@
+-- 008: 用户身份关联（匿名ID与登录用户ID合并）
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID',
+    user_id VARCHAR(64) NOT NULL COMMENT '被关联的用户ID（匿名ID或旧ID）',
+    canonical_id VARCHAR(64) NOT NULL COMMENT '规范用户ID',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (project_id, user_id),
+    INDEX idx_project_canonical (project_id, canonical_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户身份关联表';
+
+INSERT IGNORE INTO event_schemas (event_type, description, properties) VALUES
+('identify', '用户登录（关联匿名ID）', JSON_ARRAY(
+    JSON_OBJECT('name', 'anonymous_id', 'type', 'string', 'required', TRUE)
+));
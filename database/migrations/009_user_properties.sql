@@ -0,0 +1,4 @@
+-- 009: 用户自定义属性
+
+ALTER TABLE users
+    ADD COLUMN properties JSON COMMENT '用户自定义属性' AFTER os;
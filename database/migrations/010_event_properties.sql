@@ -0,0 +1,12 @@
+-- 010: 持久化事件扩展字段（页面标题、元素ID/Class、extra_data）
+
+ALTER TABLE user_events
+    ADD COLUMN page_title VARCHAR(256) COMMENT '页面标题' AFTER page_url,
+    ADD COLUMN element_id VARCHAR(128) COMMENT '元素ID' AFTER element_text,
+    ADD COLUMN element_class VARCHAR(256) COMMENT '元素Class' AFTER element_id,
+    ADD COLUMN extra_data JSON COMMENT '事件自定义属性' AFTER source,
+    ADD COLUMN prop_order_id VARCHAR(64) AS (LEFT(JSON_UNQUOTE(JSON_EXTRACT(extra_data, '$.order_id')), 64)) VIRTUAL COMMENT '订单ID（extra_data.order_id）' AFTER extra_data,
+    ADD COLUMN prop_product_id VARCHAR(64) AS (LEFT(JSON_UNQUOTE(JSON_EXTRACT(extra_data, '$.product_id')), 64)) VIRTUAL COMMENT '商品ID（extra_data.product_id）' AFTER prop_order_id,
+    ADD INDEX idx_project_element_id (project_id, element_id),
+    ADD INDEX idx_project_order (project_id, prop_order_id),
+    ADD INDEX idx_project_product (project_id, prop_product_id);
@@ -0,0 +1,30 @@
+-- 011: 用户数据删除与导出任务（GDPR）及审计日志
+
+CREATE TABLE IF NOT EXISTS privacy_jobs (
+    job_id VARCHAR(64) PRIMARY KEY COMMENT '任务ID',
+    project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID',
+    user_id VARCHAR(64) NOT NULL COMMENT '请求删除/导出的用户ID',
+    job_type VARCHAR(16) NOT NULL COMMENT '任务类型：erase, export',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' COMMENT '状态：pending, running, completed, failed',
+    result JSON COMMENT '处理结果（各数据源删除/导出的记录数）',
+    file_path VARCHAR(512) COMMENT '导出文件路径（过期删除后清空）',
+    error_message VARCHAR(512) COMMENT '失败原因',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP NULL COMMENT '开始处理时间',
+    completed_at TIMESTAMP NULL COMMENT '完成时间',
+    INDEX idx_status_created (status, created_at),
+    INDEX idx_project_user (project_id, user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户数据删除与导出任务表';
+
+CREATE TABLE IF NOT EXISTS privacy_audit_log (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    project_id VARCHAR(64) NOT NULL DEFAULT 'default' COMMENT '项目ID',
+    job_id VARCHAR(64) COMMENT '任务ID',
+    user_id VARCHAR(64) NOT NULL COMMENT '被处理的用户ID（作为已执行删除的凭证保留）',
+    action VARCHAR(32) NOT NULL COMMENT '操作：erase_requested, erase_completed, export_requested, export_completed, export_downloaded, export_expired, job_failed',
+    actor VARCHAR(256) COMMENT '操作来源（请求IP及请求ID）',
+    details JSON COMMENT '操作详情',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_project_user (project_id, user_id),
+    INDEX idx_job_id (job_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户数据删除与导出审计日志';
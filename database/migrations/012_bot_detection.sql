@@ -0,0 +1,6 @@
+-- 012: 机器人/爬虫流量标记（默认统计中排除）
+
+ALTER TABLE user_events
+    ADD COLUMN is_bot TINYINT(1) NOT NULL DEFAULT 0 COMMENT '是否机器人流量' AFTER source,
+    ADD COLUMN bot_reason VARCHAR(32) COMMENT '判定为机器人的原因：user_agent, datacenter_ip, event_rate, no_interaction' AFTER is_bot,
+    ADD INDEX idx_project_bot_timestamp (project_id, is_bot, timestamp);
@@ -0,0 +1,3 @@
+-- 013: 清除默认项目预置的服务端密钥（公开的密钥可伪造签名上报，需通过 rotate-secret 接口重新生成）
+
+UPDATE projects SET secret_key = NULL WHERE id = 'default' AND secret_key = 'sk_default_dev';
@@ -0,0 +1,5 @@
+-- 014: 项目读取密钥（查询接口鉴权，按项目隔离数据）
+
+ALTER TABLE projects
+    ADD COLUMN read_key VARCHAR(64) COMMENT '读取密钥（查询接口）' AFTER secret_key,
+    ADD UNIQUE KEY uk_read_key (read_key);
@@ -15,6 +15,7 @@ class InsightFlowSDK {
         };
         
         this.events = [];
+        this.consent = config.consent || localStorage.getItem('insightflow_consent') || null;
         this.sessionId = this.generateSessionId();
         this.batchTimer = null;
         this.isInitialized = false;
@@ -128,6 +129,7 @@ class InsightFlowSDK {
      */
     track(eventType, data = {}) {
         const event = {
+            event_id: 'evt_' + this.generateId(), // 服务端据此去重，重试发送不会重复计数
             user_id: this.config.userId,
             session_id: this.sessionId,
             event_type: eventType,
@@ -142,6 +144,9 @@ class InsightFlowSDK {
             window_height: window.innerHeight,
             ...data
         };
+        if (this.consent) {
+            event.consent = this.consent;
+        }
         
         this.events.push(event);
         this.log('事件已记录:', event);
@@ -216,6 +221,26 @@ class InsightFlowSDK {
         this.log('用户ID已设置:', userId);
     }
     
+    /**
+     * 设置访客同意状态：granted 完整追踪，anonymous 只做匿名统计，denied 不追踪
+     */
+    setConsent(consent) {
+        this.consent = consent;
+        localStorage.setItem('insightflow_consent', consent);
+        this.log('同意状态已设置:', consent);
+    }
+    
+    /**
+     * 用户登录：将当前匿名ID关联到登录用户ID
+     */
+    identify(userId) {
+        const anonymousId = this.config.userId;
+        this.setUserId(userId);
+        if (anonymousId && anonymousId !== userId) {
+            this.track('identify', { anonymous_id: anonymousId });
+        }
+    }
+    
     /**
      * 设置自定义属性
      */
@@ -223,6 +248,20 @@ class InsightFlowSDK {
         this.track('user_properties', properties);
     }
     
+    /**
+     * 设置用户属性（仅在属性不存在时生效，如首次注册渠道）
+     */
+    setUserPropertiesOnce(properties) {
+        this.track('user_properties', { $set_once: properties });
+    }
+    
+    /**
+     * 累加数值型用户属性
+     */
+    incrementUserProperty(name, value = 1) {
+        this.track('user_properties', { $increment: { [name]: value } });
+    }
+    
     /**
      * 生成用户ID
      */
@@ -5,7 +5,10 @@
  */
 
 // 类型定义
+type ConsentState = 'granted' | 'anonymous' | 'denied';
+
 interface EventData {
+  event_id: string;
   user_id: string;
   session_id: string;
   event_type: string;
@@ -13,6 +16,7 @@ interface EventData {
   element?: string;
   element_text?: string;
   timestamp: number;
+  consent?: ConsentState;
   extra_data?: Record<string, any>;
 }
 
@@ -36,6 +40,7 @@ interface SDKConfig {
   debug?: boolean;
   retryAttempts?: number;
   retryDelay?: number;
+  consent?: ConsentState;
   [key: string]: any;
 }
 
@@ -93,6 +98,10 @@ interface UserPropertiesEventData {
   [key: string]: any;
 }
 
+interface IdentifyEventData {
+  anonymous_id: string;
+}
+
 class InsightFlowSDK {
   private config: Required<SDKConfig>;
   private events: EventData[] = [];
@@ -100,6 +109,7 @@ class InsightFlowSDK {
   private batchTimer: number | null = null;
   private isInitialized: boolean = false;
   private scrollTimer: number | null = null;
+  private consent: ConsentState | null = null;
 
   constructor(config: SDKConfig = {}) {
     this.config = {
@@ -114,6 +124,7 @@ class InsightFlowSDK {
       ...config
     };
     
+    this.consent = config.consent || (localStorage.getItem('insightflow_consent') as ConsentState | null);
     this.sessionId = this.generateSessionId();
     this.init();
   }
@@ -226,6 +237,7 @@ class InsightFlowSDK {
    */
   public track(eventType: string, data: Record<string, any> = {}): void {
     const event: EventData = {
+      event_id: 'evt_' + this.generateId(), // 服务端据此去重，重试发送不会重复计数
       user_id: this.config.userId,
       session_id: this.sessionId,
       event_type: eventType,
@@ -250,6 +262,9 @@ class InsightFlowSDK {
     if (data.element_text) {
       event.element_text = String(data.element_text);
     }
+    if (this.consent) {
+      event.consent = this.consent;
+    }
     
     this.events.push(event);
     this.log('事件已记录:', event);
@@ -361,6 +376,26 @@ class InsightFlowSDK {
     this.log('用户ID已设置:', userId);
   }
 
+  /**
+   * 设置访客同意状态：granted 完整追踪，anonymous 只做匿名统计，denied 不追踪
+   */
+  public setConsent(consent: ConsentState): void {
+    this.consent = consent;
+    localStorage.setItem('insightflow_consent', consent);
+    this.log('同意状态已设置:', consent);
+  }
+
+  /**
+   * 用户登录：将当前匿名ID关联到登录用户ID
+   */
+  public identify(userId: string): void {
+    const anonymousId = this.config.userId;
+    this.setUserId(userId);
+    if (anonymousId && anonymousId !== userId) {
+      this.track('identify', { anonymous_id: anonymousId } as IdentifyEventData);
+    }
+  }
+
   /**
    * 设置自定义属性
    */
@@ -368,6 +403,20 @@ class InsightFlowSDK {
     this.track('user_properties', properties);
   }
 
+  /**
+   * 设置用户属性（仅在属性不存在时生效，如首次注册渠道）
+   */
+  public setUserPropertiesOnce(properties: UserPropertiesEventData): void {
+    this.track('user_properties', { $set_once: properties });
+  }
+
+  /**
+   * 累加数值型用户属性
+   */
+  public incrementUserProperty(name: string, value: number = 1): void {
+    this.track('user_properties', { $increment: { [name]: value } });
+  }
+
   /**
    * 生成用户ID
    */
@@ -495,7 +544,9 @@ export type {
   VisibilityEventData,
   PurchaseEventData,
   PageViewEventData,
-  UserPropertiesEventData
+  UserPropertiesEventData,
+  IdentifyEventData,
+  ConsentState
 };
 
 // 导出主类